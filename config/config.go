@@ -21,6 +21,11 @@ type Config struct {
 	APIURL      string         `yaml:"api_url"`
 	MinIOConfig MinIOConfig    `yaml:"minio"`
 	RateLimit   RateLimit      `yaml:"rate_limit"`
+	Lock        LockConfig     `yaml:"lock"`
+	Git         GitConfig      `yaml:"git"`
+	Concurrency Concurrency    `yaml:"concurrency"`
+	Lease       LeaseConfig    `yaml:"lease"`
+	Schedule    ScheduleConfig `yaml:"schedule"`
 }
 
 type MinIOConfig struct {
@@ -34,6 +39,43 @@ type RateLimit struct {
 	RequestsPerSecond int `yaml:"per_second"`
 }
 
+// LockConfig represents how long requests wait for a repository lock
+type LockConfig struct {
+	ReadTimeoutSeconds  int `yaml:"read_timeout_seconds"`
+	WriteTimeoutSeconds int `yaml:"write_timeout_seconds"`
+}
+
+// GitConfig represents the deadlines and retries applied to git subprocesses
+type GitConfig struct {
+	CloneTimeoutSeconds  int `yaml:"clone_timeout_seconds"`
+	FetchTimeoutSeconds  int `yaml:"fetch_timeout_seconds"`
+	PushTimeoutSeconds   int `yaml:"push_timeout_seconds"`
+	LocalTimeoutSeconds  int `yaml:"local_timeout_seconds"`
+	MaxRetries           int `yaml:"max_retries"`
+	RetryBaseDelayMillis int `yaml:"retry_base_delay_millis"`
+}
+
+// Concurrency represents how concurrent edits of collection files are detected
+type Concurrency struct {
+	// RequireIfMatch rejects updates, renames and deletes of existing files that do not send an If-Match header
+	RequireIfMatch bool `yaml:"require_if_match"`
+}
+
+// LeaseConfig represents how long edit leases last and how saves of leased files by others are treated
+type LeaseConfig struct {
+	DefaultTTLSeconds int `yaml:"default_ttl_seconds"`
+	MaxTTLSeconds     int `yaml:"max_ttl_seconds"`
+	// WarnOnly lets others save a leased file with a warning instead of refusing the save
+	WarnOnly bool `yaml:"warn_only"`
+}
+
+// ScheduleConfig represents how often scheduled publications are looked for
+type ScheduleConfig struct {
+	IntervalSeconds int `yaml:"interval_seconds"`
+	// Disabled stops this process from carrying out scheduled publications
+	Disabled bool `yaml:"disabled"`
+}
+
 // JWTConfig represents JWT configuration
 type JWTConfig struct {
 	Secret          string `yaml:"secret"`
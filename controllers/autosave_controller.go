@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"github.com/zhaojunlucky/mkdocs-cms/services"
+)
+
+// AutosaveController handles the autosaves of editors, they are kept on the server and committed on publish
+type AutosaveController struct {
+	BaseController
+	autosaveService              *services.AutosaveService
+	userGitRepoCollectionService *services.UserGitRepoCollectionService
+	userGitRepoLockService       *services.UserGitRepoLockService
+	fileLeaseService             *services.FileLeaseService
+}
+
+func (ctrl *AutosaveController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
+	ctrl.ctx = ctx
+	ctrl.autosaveService = ctx.MustGetService("autosaveService").(*services.AutosaveService)
+	ctrl.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*services.UserGitRepoCollectionService)
+	ctrl.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
+	ctrl.fileLeaseService = ctx.MustGetService("fileLeaseService").(*services.FileLeaseService)
+	autosaves := router.Group("/autosaves")
+	{
+		autosaves.GET("/repo/:repoId/:collectionName", ctrl.ListAutosaves)
+		autosaves.GET("/repo/:repoId/:collectionName/file", ctrl.GetAutosave)
+		autosaves.PUT("/repo/:repoId/:collectionName/file", ctrl.SaveAutosave)
+		autosaves.DELETE("/repo/:repoId/:collectionName/file", ctrl.DiscardAutosave)
+		autosaves.GET("/repo/:repoId/:collectionName/diff", ctrl.DiffAutosave)
+		autosaves.POST("/repo/:repoId/:collectionName/publish", ctrl.PublishAutosave)
+	}
+}
+
+// ListAutosaves lists the autosaves of the user in a collection, without their content
+func (ctrl *AutosaveController) ListAutosaves(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	autosaves, err := ctrl.autosaveService.List(repo, collectionName.String(), userId.String())
+	if err != nil {
+		log.Errorf("Failed to list autosaves: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, autosaves)
+}
+
+// GetAutosave returns the autosave of a file with its content
+func (ctrl *AutosaveController) GetAutosave(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	autosave, err := ctrl.autosaveService.Get(repo, collectionName.String(), userId.String(), pathParam.String())
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, autosave)
+}
+
+// SaveAutosave stores the content of a file without committing it. If-Match names the version the work is
+// based on when the first autosave of the file is stored.
+func (ctrl *AutosaveController) SaveAutosave(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	var req models.SaveAutosaveRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "autosave file")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	baseETag := core.IfMatch(c)
+	if baseETag == core.AnyVersion {
+		baseETag = ""
+	}
+	autosave, err := ctrl.autosaveService.Save(repo, collectionName.String(), userId.String(), req.Path, req.Content, baseETag)
+	if err != nil {
+		log.Errorf("Failed to autosave %s: %v", req.Path, err)
+		core.HandleError(c, err)
+		return
+	}
+	autosave.Content = ""
+	c.JSON(http.StatusOK, autosave)
+}
+
+// DiscardAutosave drops the autosave of a file
+func (ctrl *AutosaveController) DiscardAutosave(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	if err := ctrl.autosaveService.Discard(repo, collectionName.String(), userId.String(), pathParam.String()); err != nil {
+		log.Errorf("Failed to discard the autosave of %s: %v", pathParam.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DiffAutosave compares the autosave of a file with the file at HEAD
+func (ctrl *AutosaveController) DiffAutosave(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "diff autosave")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	diff, err := ctrl.autosaveService.Diff(c.Request.Context(), repo, collectionName.String(), userId.String(), pathParam.String())
+	if err != nil {
+		log.Errorf("Failed to diff the autosave of %s: %v", pathParam.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// PublishAutosave commits the autosave of a file through the normal save path and drops it
+func (ctrl *AutosaveController) PublishAutosave(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	var req models.PublishAutosaveRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "publish autosave")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	options := updateFileOptions(c, writeCondition(c, ctrl.fileLeaseService, repo, collectionName.String(), req.Path, userId.String()))
+	autosave, err := ctrl.autosaveService.Publish(c.Request.Context(), repo, collectionName.String(), userId.String(), req.Path, options)
+	if err != nil {
+		log.Errorf("Failed to publish the autosave of %s: %v", req.Path, err)
+		core.HandleError(c, err)
+		return
+	}
+
+	log.Infof("Autosave of %s published and changes committed successfully", autosave.FilePath)
+	etag, err := ctrl.userGitRepoCollectionService.FileETag(repo, collectionName.String(), autosave.FilePath)
+	if err != nil {
+		log.Errorf("Failed to get the version of %s: %v", autosave.FilePath, err)
+	}
+	core.SetETag(c, etag)
+	c.JSON(http.StatusOK, gin.H{"path": autosave.FilePath, "etag": etag})
+}
@@ -23,6 +23,13 @@ var v1Controllers = []Controller{
 	&UserGitRepoController{},
 	&GitHubAppController{},
 	&StorageController{},
+	&SiteScaffoldController{},
+	&SearchController{},
+	&FileLeaseController{},
+	&AutosaveController{},
+	&PublishScheduleController{},
+	&WorkflowController{},
+	&FileCommentController{},
 }
 
 var apiControllers = []Controller{
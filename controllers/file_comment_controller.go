@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"github.com/zhaojunlucky/mkdocs-cms/services"
+)
+
+// FileCommentController handles the comment threads of collection files. The repository owner and the reviewers
+// assigned to a file may take part, the comment service checks access.
+type FileCommentController struct {
+	BaseController
+	fileCommentService     *services.FileCommentService
+	userGitRepoLockService *services.UserGitRepoLockService
+}
+
+func (ctrl *FileCommentController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
+	ctrl.ctx = ctx
+	ctrl.fileCommentService = ctx.MustGetService("fileCommentService").(*services.FileCommentService)
+	ctrl.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
+	comments := router.Group("/comments")
+	{
+		comments.GET("/mentions", ctrl.ListMentions)
+		comments.GET("/repo/:repoId/threads/:threadId", ctrl.GetThread)
+		comments.POST("/repo/:repoId/threads/:threadId/replies", ctrl.Reply)
+		comments.POST("/repo/:repoId/threads/:threadId/resolve", ctrl.Resolve)
+		comments.POST("/repo/:repoId/threads/:threadId/reopen", ctrl.Reopen)
+		comments.GET("/repo/:repoId/:collectionName", ctrl.ListThreads)
+		comments.POST("/repo/:repoId/:collectionName", ctrl.CreateThread)
+	}
+}
+
+// repo returns the repository of a request to the users who may comment on the file, before any lock of the
+// repository is taken
+func (ctrl *FileCommentController) repo(userId *core.ParamDef, repoIDParam *core.ParamDef, collectionName string, filePath string) (*models.UserGitRepo, error) {
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "Invalid repository ID")
+	}
+	return ctrl.fileCommentService.VerifyAccess(userId.String(), uint(repoID), collectionName, filePath)
+}
+
+// threadRepo returns the repository of a thread request to the users who may comment on the file of the thread,
+// before any lock of the repository is taken
+func (ctrl *FileCommentController) threadRepo(userId *core.ParamDef, repoIDParam *core.ParamDef, threadID uint64) (*models.UserGitRepo, error) {
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "Invalid repository ID")
+	}
+	return ctrl.fileCommentService.VerifyThreadAccess(userId.String(), uint(repoID), uint(threadID))
+}
+
+// ListMentions returns the open threads in which the user was mentioned
+func (ctrl *FileCommentController) ListMentions(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	threads, err := ctrl.fileCommentService.Mentions(userId.String())
+	if err != nil {
+		log.Errorf("Failed to list mentions: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, threads)
+}
+
+// ListThreads returns the threads of a file, resolved ones only with resolved=true
+func (ctrl *FileCommentController) ListThreads(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+	resolvedParam := reqParam.AddQueryParam("resolved", true, regexp.MustCompile(`^(true|false)?$`))
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repo, err := ctrl.repo(userId, repoIDParam, collectionName.String(), pathParam.String())
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "list comments")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	threads, err := ctrl.fileCommentService.List(repo, collectionName.String(), pathParam.String(), userId.String(),
+		resolvedParam.String() == "true")
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, threads)
+}
+
+// CreateThread starts a thread on a file
+func (ctrl *FileCommentController) CreateThread(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	var req models.CreateCommentThreadRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repo, err := ctrl.repo(userId, repoIDParam, collectionName.String(), req.Path)
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "create comment")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	thread, err := ctrl.fileCommentService.Create(repo, collectionName.String(), userId.String(), req)
+	if err != nil {
+		log.Errorf("Failed to comment on %s: %v", req.Path, err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, thread)
+}
+
+// threadParams reads the repository and thread of a thread request
+func (ctrl *FileCommentController) threadParams(c *gin.Context, body any) (*core.ParamDef, *core.ParamDef, *core.ParamDef, error) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	threadIDParam := reqParam.AddUrlParam("threadId", false, regexp.MustCompile(`\d+`))
+	var err error
+	if body != nil {
+		err = reqParam.HandleWithBody(c, body)
+	} else {
+		err = reqParam.Handle(c)
+	}
+	return userId, repoIDParam, threadIDParam, err
+}
+
+// GetThread returns a thread with its comments
+func (ctrl *FileCommentController) GetThread(c *gin.Context) {
+	ctrl.setResolved(c, nil)
+}
+
+// Resolve marks a thread as resolved
+func (ctrl *FileCommentController) Resolve(c *gin.Context) {
+	resolved := true
+	ctrl.setResolved(c, &resolved)
+}
+
+// Reopen marks a resolved thread as open again
+func (ctrl *FileCommentController) Reopen(c *gin.Context) {
+	resolved := false
+	ctrl.setResolved(c, &resolved)
+}
+
+// setResolved returns a thread after resolving or reopening it, a nil resolved leaves it as it is
+func (ctrl *FileCommentController) setResolved(c *gin.Context, resolved *bool) {
+	userId, repoIDParam, threadIDParam, err := ctrl.threadParams(c, nil)
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+	threadID, err := threadIDParam.UInt64()
+	if err != nil {
+		core.HandleError(c, core.NewHTTPErrorStr(http.StatusBadRequest, "Invalid thread ID"))
+		return
+	}
+
+	repo, err := ctrl.threadRepo(userId, repoIDParam, threadID)
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "comment thread")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	var thread *models.CommentThread
+	if resolved == nil {
+		thread, err = ctrl.fileCommentService.Get(repo, uint(threadID), userId.String())
+	} else {
+		thread, err = ctrl.fileCommentService.SetResolved(repo, uint(threadID), userId.String(), *resolved)
+	}
+	if err != nil {
+		log.Errorf("Failed to handle comment thread %d: %v", threadID, err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, thread)
+}
+
+// Reply adds a comment to a thread
+func (ctrl *FileCommentController) Reply(c *gin.Context) {
+	var req models.ReplyCommentRequest
+	userId, repoIDParam, threadIDParam, err := ctrl.threadParams(c, &req)
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+	threadID, err := threadIDParam.UInt64()
+	if err != nil {
+		core.HandleError(c, core.NewHTTPErrorStr(http.StatusBadRequest, "Invalid thread ID"))
+		return
+	}
+
+	repo, err := ctrl.threadRepo(userId, repoIDParam, threadID)
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "reply to comment")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	comment, err := ctrl.fileCommentService.Reply(repo, uint(threadID), userId.String(), req.Body)
+	if err != nil {
+		log.Errorf("Failed to reply to comment thread %d: %v", threadID, err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, comment)
+}
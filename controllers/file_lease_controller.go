@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"github.com/zhaojunlucky/mkdocs-cms/services"
+)
+
+// FileLeaseController handles the edit leases editors hold on collection files
+type FileLeaseController struct {
+	BaseController
+	fileLeaseService             *services.FileLeaseService
+	userGitRepoCollectionService *services.UserGitRepoCollectionService
+}
+
+func (ctrl *FileLeaseController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
+	ctrl.ctx = ctx
+	ctrl.fileLeaseService = ctx.MustGetService("fileLeaseService").(*services.FileLeaseService)
+	ctrl.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*services.UserGitRepoCollectionService)
+	leases := router.Group("/leases")
+	{
+		leases.GET("/repo/:repoId/:collectionName", ctrl.ListLeases)
+		leases.POST("/repo/:repoId/:collectionName", ctrl.AcquireLease)
+		leases.PUT("/:leaseId", ctrl.RenewLease)
+		leases.DELETE("/:leaseId", ctrl.ReleaseLease)
+	}
+}
+
+// ListLeases returns who is editing which files of a collection
+func (ctrl *FileLeaseController) ListLeases(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	if _, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID)); err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	leases, err := ctrl.fileLeaseService.List(uint(repoID), collectionName.String())
+	if err != nil {
+		log.Errorf("Failed to list edit leases: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, leases)
+}
+
+// AcquireLease takes a lease on a collection file, the response holds the lease ID to renew, release and
+// send as X-Edit-Lease with saves
+func (ctrl *FileLeaseController) AcquireLease(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	var req models.AcquireLeaseRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	if _, err := ctrl.userGitRepoCollectionService.GetCollectionByName(repo, collectionName.String()); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lease, err := ctrl.fileLeaseService.Acquire(repo.ID, collectionName.String(), req.Path, userId.String(), req.TTLSeconds, req.Force)
+	if err != nil {
+		log.Errorf("Failed to acquire the edit lease of %s: %v", req.Path, err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, lease)
+}
+
+// RenewLease extends a lease, editors send it as a heartbeat while the file is open
+func (ctrl *FileLeaseController) RenewLease(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	leaseID := reqParam.AddUrlParam("leaseId", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+	// The body is optional, a heartbeat without one renews for the default TTL
+	var req models.RenewLeaseRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			core.ResponseErrStr(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	lease, err := ctrl.fileLeaseService.Renew(leaseID.String(), userId.String(), req.TTLSeconds)
+	if err != nil {
+		log.Errorf("Failed to renew edit lease %s: %v", leaseID.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, lease)
+}
+
+// ReleaseLease gives a lease up, admins release the leases of others with force=true
+func (ctrl *FileLeaseController) ReleaseLease(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	leaseID := reqParam.AddUrlParam("leaseId", false, nil)
+	force := reqParam.AddQueryParam("force", true, regexp.MustCompile(`^(true|false)?$`))
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	err := ctrl.fileLeaseService.Release(leaseID.String(), userId.String(), strings.EqualFold(force.String(), "true"))
+	if err != nil {
+		log.Errorf("Failed to release edit lease %s: %v", leaseID.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
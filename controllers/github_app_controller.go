@@ -1,10 +1,10 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/zhaojunlucky/mkdocs-cms/core"
-	"github.com/zhaojunlucky/mkdocs-cms/database"
 	"net/http"
 	"os"
 	"strconv"
@@ -268,29 +268,8 @@ func (c *GitHubAppController) ImportRepositories(ctx *gin.Context) {
 		core.ResponseErrStr(ctx, http.StatusBadRequest, "Failed to get user information: "+err.Error())
 		return
 	}
-	role := user.GetQuotaRole()
-	if role == nil {
-		log.Errorf("Failed to get user quota role: %v", err)
-		core.ResponseErrStr(ctx, http.StatusForbidden, "user quota role not found")
-		return
-	}
-	var roleQuota models.UserRoleQuota
-	err = database.DB.Preload("Role").Where("role_id = ?", role.ID).First(&roleQuota).Error
-	if err != nil {
-		log.Errorf("Failed to get user quota role: %v", err)
-		core.ResponseErrStr(ctx, http.StatusForbidden, "user quota role not found")
-		return
-	}
-	var userGitRepos []models.UserGitRepo
-	err = database.DB.Where("user_id = ?", userID.String()).Find(&userGitRepos).Error
-	if err != nil {
-		log.Errorf("Failed to list user git repo: %v", err)
-		core.ResponseErrStr(ctx, http.StatusInternalServerError, "Failed to list user git repo: "+err.Error())
-		return
-	}
-	if roleQuota.RepoCount != 0 && len(userGitRepos) >= roleQuota.RepoCount {
-		log.Errorf("User quota exceeded: %v", err)
-		core.ResponseErrStr(ctx, http.StatusForbidden, "user quota exceeded, contact admin")
+	if err := c.userGitRepoService.CheckRepoQuota(user); err != nil {
+		core.HandleError(ctx, err)
 		return
 	}
 
@@ -373,13 +352,13 @@ func (c *GitHubAppController) ImportRepositories(ctx *gin.Context) {
 		// Sync the imported repositories
 		for _, repo := range importedRepos {
 			log.Infof("Syncing repository %d", repo.ID)
-			err := c.userGitRepoService.SyncRepo(&repo, "")
+			err := c.userGitRepoService.SyncRepo(context.Background(), &repo, "")
 			if err != nil {
 				log.Errorf("Failed to sync repository %d: %v", repo.ID, err)
 				c.userGitRepoService.UpdateRepoStatus(&repo, models.StatusFailed, err.Error())
 			}
 
-			if err := c.userGitRepoService.CheckWebHooks(&repo); err != nil {
+			if err := c.userGitRepoService.CheckWebHooks(context.Background(), &repo); err != nil {
 				// Update task status to failed
 				log.Errorf("Failed to check webhooks: %v", err)
 				c.userGitRepoService.UpdateRepoStatus(&repo, models.StatusFailed, err.Error())
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -149,11 +150,16 @@ func (c *GitHubWebhookController) handlePushEvent(ctx *gin.Context, event *githu
 
 func (c *GitHubWebhookController) syncRepo(repo models.UserGitRepo, body []byte, commitID string) {
 	id := fmt.Sprintf("%d", repo.ID)
-	lock := c.userGitRepoLockService.Acquire(id)
-	lock.Lock()
-
+	// A push must not be dropped because an editor holds the lock, the sync waits for it
+	lock, err := c.userGitRepoLockService.LockWait(context.Background(), id, "github-webhook", "sync repository on push")
+	if err != nil {
+		log.Errorf("Failed to acquire lock for repository %s: %v", id, err)
+		c.gitRepoService.UpdateRepoStatus(&repo, models.StatusFailed, fmt.Sprintf("failed to sync the push of %s: %v", commitID, err))
+		return
+	}
 	defer lock.Unlock()
-	if err := c.gitRepoService.SyncRepo(&repo, commitID); err != nil {
+
+	if err := c.gitRepoService.SyncRepo(context.Background(), &repo, commitID); err != nil {
 		log.Errorf("Failed to sync repository %s: %v", id, err)
 		return
 	}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/services"
+)
+
+// PublishScheduleController handles the scheduled publications of collection entries
+type PublishScheduleController struct {
+	BaseController
+	publishScheduleService       *services.PublishScheduleService
+	userGitRepoCollectionService *services.UserGitRepoCollectionService
+	userGitRepoLockService       *services.UserGitRepoLockService
+}
+
+func (ctrl *PublishScheduleController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
+	ctrl.ctx = ctx
+	ctrl.publishScheduleService = ctx.MustGetService("publishScheduleService").(*services.PublishScheduleService)
+	ctrl.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*services.UserGitRepoCollectionService)
+	ctrl.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
+	schedules := router.Group("/schedules")
+	{
+		schedules.GET("/repo/:repoId/upcoming", ctrl.ListUpcoming)
+	}
+}
+
+// ListUpcoming returns the pending publications and unpublications of a repository, soonest first,
+// optionally of one collection
+func (ctrl *PublishScheduleController) ListUpcoming(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddQueryParam("collection", true, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "list upcoming publications")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	schedules, err := ctrl.publishScheduleService.Upcoming(repo, collectionName.String())
+	if err != nil {
+		log.Errorf("Failed to list upcoming publications: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, schedules)
+}
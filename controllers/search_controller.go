@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/query"
+	"github.com/zhaojunlucky/mkdocs-cms/services"
+)
+
+// SearchController handles full-text search over the repositories of a user
+type SearchController struct {
+	BaseController
+	searchService          *services.SearchService
+	userGitRepoService     *services.UserGitRepoService
+	userGitRepoLockService *services.UserGitRepoLockService
+}
+
+func (c *SearchController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
+	c.ctx = ctx
+	c.searchService = ctx.MustGetService("searchService").(*services.SearchService)
+	c.userGitRepoService = ctx.MustGetService("userGitRepoService").(*services.UserGitRepoService)
+	c.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
+	router.GET("/search", c.Search)
+}
+
+// Search runs a full-text query. Query parameters: q, repo_id, collection, fields=title,body, limit and offset.
+func (c *SearchController) Search(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	text := reqParam.AddQueryParam("q", false, nil)
+	repoIDParam := reqParam.AddQueryParam("repo_id", true, regexp.MustCompile(`^\d*$`))
+	collection := reqParam.AddQueryParam("collection", true, nil)
+	fields := reqParam.AddQueryParam("fields", true, nil)
+	limit := reqParam.AddQueryParam("limit", true, regexp.MustCompile(`^\d*$`))
+	offset := reqParam.AddQueryParam("offset", true, regexp.MustCompile(`^\d*$`))
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	q := services.SearchQuery{Text: text.String(), Collection: collection.String(), Limit: query.DefaultLimit}
+	if repoIDParam.String() != "" {
+		repoID, _ := strconv.ParseUint(repoIDParam.String(), 10, 64)
+		q.RepoID = uint(repoID)
+	}
+	for _, field := range strings.Split(fields.String(), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			q.Fields = append(q.Fields, field)
+		}
+	}
+	if n, err := strconv.Atoi(limit.String()); err == nil && n > 0 {
+		q.Limit = min(n, query.MaxLimit)
+	}
+	if n, err := strconv.Atoi(offset.String()); err == nil {
+		q.Offset = n
+	}
+
+	repos, err := c.userGitRepoService.GetReposByUser(userId.String())
+	if err != nil {
+		log.Errorf("Failed to get repositories of user %s: %v", userId.String(), err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	// Bring the searched repositories up to date, a repository that cannot be refreshed is searched as indexed
+	for i := range repos {
+		repo := &repos[i]
+		if q.RepoID != 0 && repo.ID != q.RepoID {
+			continue
+		}
+		lock, err := c.userGitRepoLockService.RLock(ctx.Request.Context(), strconv.FormatUint(uint64(repo.ID), 10), userId.String(), "search")
+		if err != nil {
+			log.Warnf("Skipping search index refresh of repository %d: %v", repo.ID, err)
+			continue
+		}
+		if err := c.searchService.Refresh(repo); err != nil {
+			log.Warnf("Failed to refresh search index of repository %d: %v", repo.ID, err)
+		}
+		lock.Unlock()
+	}
+
+	response, err := c.searchService.Search(repos, q)
+	if err != nil {
+		log.Errorf("Search failed: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/schema"
 )
 
 type SiteController struct {
@@ -15,9 +16,15 @@ func (c *SiteController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
 	auth := router.Group("/site")
 	{
 		auth.GET("/version", c.getVersion)
+		auth.GET("/schema/veda-config.json", c.getVedaConfigSchema)
 	}
 }
 
 func (c *SiteController) getVersion(context *gin.Context) {
 	context.JSON(200, gin.H{"version": c.ctx.Version})
 }
+
+func (c *SiteController) getVedaConfigSchema(context *gin.Context) {
+	context.Header("Content-Type", "application/schema+json")
+	context.JSON(200, schema.VedaConfigJSONSchema())
+}
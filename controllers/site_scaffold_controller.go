@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"github.com/zhaojunlucky/mkdocs-cms/services"
+)
+
+// SiteScaffoldController handles creating new sites from templates
+type SiteScaffoldController struct {
+	BaseController
+	siteScaffoldService    *services.SiteScaffoldService
+	userGitRepoService     *services.UserGitRepoService
+	userService            *services.UserService
+	asyncTaskService       *services.AsyncTaskService
+	userGitRepoLockService *services.UserGitRepoLockService
+}
+
+func (c *SiteScaffoldController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
+	c.ctx = ctx
+	c.siteScaffoldService = ctx.MustGetService("siteScaffoldService").(*services.SiteScaffoldService)
+	c.userGitRepoService = ctx.MustGetService("userGitRepoService").(*services.UserGitRepoService)
+	c.userService = ctx.MustGetService("userService").(*services.UserService)
+	c.asyncTaskService = ctx.MustGetService("asyncTaskService").(*services.AsyncTaskService)
+	c.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
+	sites := router.Group("/sites")
+	{
+		sites.GET("/templates", c.GetTemplates)
+		sites.POST("", c.CreateSite)
+	}
+}
+
+// GetTemplates returns the site templates a new site can be created from
+func (c *SiteScaffoldController) GetTemplates(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	_ = reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	core.ResponseOKArr(ctx, c.siteScaffoldService.ListTemplates())
+}
+
+// CreateSite creates a repository, populates it from a template and imports it
+func (c *SiteScaffoldController) CreateSite(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	var request models.CreateSiteRequest
+
+	if err := reqParam.HandleWithBody(ctx, &request); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	user, err := c.userService.GetUserByID(userId.String())
+	if err != nil {
+		log.Errorf("Failed to get user information: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repo, err := c.siteScaffoldService.CreateSite(ctx.Request.Context(), user, &request)
+	if err != nil {
+		log.Errorf("Failed to create site: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+	repoID := fmt.Sprintf("%d", repo.ID)
+
+	task, err := c.asyncTaskService.CreateTask(models.TaskTypeScaffold, repoID, userId.String())
+	if err != nil {
+		log.Errorf("Failed to create scaffold task: %v", err)
+		core.ResponseErrStr(ctx, http.StatusInternalServerError, "Failed to create scaffold task")
+		return
+	}
+
+	// The lock is handed over to the scaffold goroutine and released when the site is synced
+	lock, err := c.userGitRepoLockService.Lock(ctx.Request.Context(), repoID, userId.String(), "create site")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
+		core.HandleError(ctx, err)
+		return
+	}
+
+	go func() {
+		defer lock.Unlock()
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusRunning,
+			fmt.Sprintf("Populating site from template %s", request.Template))
+
+		if err := c.siteScaffoldService.PopulateSite(context.Background(), repo, request.Template); err != nil {
+			log.Errorf("Failed to populate site: %v", err)
+			if errors.Is(err, services.ErrSiteNotPushed) {
+				c.siteScaffoldService.DiscardSite(context.Background(), repo, &request)
+			} else {
+				// the site exists on the remote, it is kept so that it can be synced again
+				c.userGitRepoService.UpdateRepoStatus(repo, models.StatusFailed, err.Error())
+			}
+			c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
+			return
+		}
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusCompleted, "Site created successfully")
+	}()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Site creation started",
+		"task_id": task.ID,
+		"repo":    repo.ToResponse(false),
+	})
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/base64"
 	log "github.com/sirupsen/logrus"
 	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/query"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -17,24 +19,38 @@ type UserGitRepoCollectionController struct {
 	BaseController
 	service                *services.UserGitRepoCollectionService
 	userGitRepoLockService *services.UserGitRepoLockService
+	fileLeaseService       *services.FileLeaseService
+	fileWorkflowService    *services.FileWorkflowService
 }
 
 func (ctrl *UserGitRepoCollectionController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
 	ctrl.ctx = ctx
 	ctrl.service = ctx.MustGetService("userGitRepoCollectionService").(*services.UserGitRepoCollectionService)
 	ctrl.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
+	ctrl.fileLeaseService = ctx.MustGetService("fileLeaseService").(*services.FileLeaseService)
+	ctrl.fileWorkflowService = ctx.MustGetService("fileWorkflowService").(*services.FileWorkflowService)
 	collections := router.Group("/collections")
 	{
 		collections.GET("/repo/:repoId", ctrl.GetCollectionsByRepo)
 
 		// Collection file routes
 		collections.GET("/repo/:repoId/:collectionName/files", ctrl.GetCollectionFilesInPath)
+		collections.GET("/repo/:repoId/:collectionName/tree", ctrl.GetCollectionTree)
 		collections.POST("/repo/:repoId/:collectionName/files/folder", ctrl.CreateFolder)
 		collections.GET("/repo/:repoId/:collectionName/files/content", ctrl.GetFileContent)
 		collections.PUT("/repo/:repoId/:collectionName/files/content", ctrl.UpdateFileContent)
+		collections.GET("/repo/:repoId/:collectionName/files/document", ctrl.GetDocument)
+		collections.GET("/repo/:repoId/:collectionName/fields/options", ctrl.ListFieldOptions)
+		collections.GET("/repo/:repoId/:collectionName/entries", ctrl.QueryEntries)
+		collections.POST("/repo/:repoId/:collectionName/entries", ctrl.CreateEntry)
+		collections.PUT("/repo/:repoId/:collectionName/files/document", ctrl.UpdateDocument)
+		collections.PATCH("/repo/:repoId/:collectionName/files/document", ctrl.PatchDocument)
 		collections.DELETE("/repo/:repoId/:collectionName/files", ctrl.DeleteFile)
 		collections.POST("/repo/:repoId/:collectionName/files/upload", ctrl.UploadFile)
 		collections.PUT("/repo/:repoId/:collectionName/files/rename", ctrl.RenameFile)
+		collections.POST("/repo/:repoId/:collectionName/files/copy", ctrl.CopyFile)
+		collections.POST("/repo/:repoId/:collectionName/files/bulk-delete", ctrl.BulkDeleteFiles)
+		collections.POST("/repo/:repoId/:collectionName/files/bulk-move", ctrl.BulkMoveFiles)
 	}
 }
 
@@ -66,6 +82,14 @@ func (ctrl *UserGitRepoCollectionController) GetCollectionsByRepo(c *gin.Context
 		return
 	}
 
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "list collections")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
 	collections, err := ctrl.service.GetCollectionsByRepo(repo)
 	if err != nil {
 		log.Errorf("Failed to get collections: %v", err)
@@ -109,6 +133,15 @@ func (ctrl *UserGitRepoCollectionController) GetCollectionFilesInPath(c *gin.Con
 		core.HandleError(c, err)
 		return
 	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "list files")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
 	path := pathParam.String()
 	if path == "" {
 		// If no path is provided, return files at the root of the collection
@@ -135,27 +168,41 @@ func (ctrl *UserGitRepoCollectionController) GetCollectionFilesInPath(c *gin.Con
 	core.ResponseOKArr(c, files)
 }
 
-// GetFileContent returns the content of a file within a collection
-func (ctrl *UserGitRepoCollectionController) GetFileContent(c *gin.Context) {
+// GetCollectionTree lists a collection directory recursively. Query parameters: path, depth, include
+// (format, assets or all), limit per directory and cursor to continue the children of path.
+func (ctrl *UserGitRepoCollectionController) GetCollectionTree(c *gin.Context) {
 	reqParam := core.NewRequestParam()
 	userId := reqParam.AddContextParam("userId", false, nil).
 		SetError(http.StatusUnauthorized, "Unauthorized")
 	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
 	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
-	pathParam := reqParam.AddQueryParam("path", false, nil)
-
+	pathParam := reqParam.AddQueryParam("path", true, nil)
+	depthParam := reqParam.AddQueryParam("depth", true, regexp.MustCompile(`^\d*$`))
+	includeParam := reqParam.AddQueryParam("include", true, nil)
+	limitParam := reqParam.AddQueryParam("limit", true, regexp.MustCompile(`^\d*$`))
+	cursorParam := reqParam.AddQueryParam("cursor", true, nil)
 	if err := reqParam.Handle(c); err != nil {
 		core.HandleError(c, err)
 		return
 	}
 
+	options := services.TreeOptions{
+		Path:    strings.Trim(pathParam.String(), "/"),
+		Include: includeParam.String(),
+		Cursor:  cursorParam.String(),
+	}
+	if n, err := strconv.Atoi(depthParam.String()); err == nil {
+		options.Depth = n
+	}
+	if n, err := strconv.Atoi(limitParam.String()); err == nil {
+		options.Limit = n
+	}
+
 	repoID, err := repoIDParam.UInt64()
 	if err != nil {
-		log.Errorf("Failed to parse repository ID: %v", err)
 		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
 		return
 	}
-	// Verify repository ownership
 	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
 	if err != nil {
 		log.Errorf("Failed to verify repository ownership: %v", err)
@@ -163,12 +210,64 @@ func (ctrl *UserGitRepoCollectionController) GetFileContent(c *gin.Context) {
 		return
 	}
 
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "list tree")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	tree, err := ctrl.service.ListTree(repo, collectionName.String(), options)
+	if err != nil {
+		log.Errorf("Failed to list tree of collection %s: %v", collectionName.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+// GetFileContent returns the content of a file within a collection
+func (ctrl *UserGitRepoCollectionController) GetFileContent(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
 	filePath := pathParam.String()
 	if filePath == "" {
 		log.Errorf("File path is required")
 		core.ResponseErrStr(c, http.StatusBadRequest, "File path is required")
 		return
 	}
+	// The reviewers assigned to an entry may read it as well as the repository owner
+	repo, err := ctrl.fileWorkflowService.VerifyEntryAccess(userId.String(), uint(repoID), collectionName.String(), filePath)
+	if err != nil {
+		log.Errorf("Failed to verify access to %s: %v", filePath, err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "read file")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
 
 	content, contentType, err := ctrl.service.GetFileContent(repo, collectionName.String(), filePath)
 	if err != nil {
@@ -177,6 +276,11 @@ func (ctrl *UserGitRepoCollectionController) GetFileContent(c *gin.Context) {
 		return
 	}
 	log.Infof("File %s content retrieved successfully", filePath)
+	etag := ctrl.setETag(c, repo, collectionName.String(), filePath)
+	if etag != "" && core.ParseIfMatch(c.GetHeader("If-None-Match")) == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	// Set the content type and return the file content
 	c.Header("Content-paramType", contentType)
 	c.Data(http.StatusOK, contentType, content)
@@ -187,6 +291,26 @@ type UpdateFileRequest struct {
 	Content string `json:"content" binding:"required"`
 }
 
+// updateFileOptions reads the front matter headers sent along with a file update
+func updateFileOptions(c *gin.Context, condition services.WriteCondition) services.UpdateFileOptions {
+	return services.UpdateFileOptions{
+		WriteCondition: condition,
+		ApplyDefaults:  strings.EqualFold("true", c.GetHeader("X-Front-Matter-Apply-Defaults")),
+	}
+}
+
+// writeCondition reads the If-Match and X-Edit-Lease headers of a change. When edit leases only warn, the
+// X-Edit-Lease-Warning header of the response tells the editor that someone else is editing the file.
+func writeCondition(c *gin.Context, fileLeaseService *services.FileLeaseService, repo *models.UserGitRepo, collectionName string,
+	filePath string, userId string) services.WriteCondition {
+	condition := services.WriteCondition{IfMatch: core.IfMatch(c), MustNotExist: core.IfNoneMatchAny(c), UserID: userId,
+		LeaseID: c.GetHeader("X-Edit-Lease")}
+	if warning := fileLeaseService.Warning(repo.ID, collectionName, filePath, userId, condition.LeaseID); warning != "" {
+		c.Header("X-Edit-Lease-Warning", warning)
+	}
+	return condition
+}
+
 // UpdateFileContent updates the content of a file within a collection
 func (ctrl *UserGitRepoCollectionController) UpdateFileContent(c *gin.Context) {
 	reqParam := core.NewRequestParam()
@@ -214,26 +338,423 @@ func (ctrl *UserGitRepoCollectionController) UpdateFileContent(c *gin.Context) {
 		return
 	}
 
-	lock := ctrl.userGitRepoLockService.Acquire(c.Param("repoId"))
-	lock.Lock()
-
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "update file")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
 	defer lock.Unlock()
 
-	hasFrontMatter := strings.EqualFold("true", c.GetHeader("X-File-Front-Matter"))
-	var isDraft *bool = nil
-	if hasFrontMatter {
-		isDraft = new(bool)
-		*isDraft = strings.EqualFold("true", c.GetHeader("X-File-Front-Matter-Draft"))
-	}
+	options := updateFileOptions(c, writeCondition(c, ctrl.fileLeaseService, repo, collectionName.String(), req.Path, userId.String()))
 
-	if err := ctrl.service.UpdateFileContent(repo, collectionName.String(), req.Path, []byte(req.Content), isDraft); err != nil {
+	if err := ctrl.service.UpdateFileContent(c.Request.Context(), repo, collectionName.String(), req.Path, []byte(req.Content), options); err != nil {
 		log.Errorf("Failed to update file content: %v", err)
-		core.ResponseErr(c, http.StatusInternalServerError, err)
+		core.HandleError(c, err)
 		return
 	}
 
 	log.Infof("File %s updated and changes committed successfully", req.Path)
-	c.JSON(http.StatusOK, gin.H{"message": "File updated and changes committed successfully"})
+	etag := ctrl.setETag(c, repo, collectionName.String(), req.Path)
+	c.JSON(http.StatusOK, gin.H{"message": "File updated and changes committed successfully", "etag": etag})
+}
+
+// setETag sets the ETag header to the current version of a file and returns it, empty when it is unknown
+func (ctrl *UserGitRepoCollectionController) setETag(c *gin.Context, repo *models.UserGitRepo, collectionName string, filePath string) string {
+	etag, err := ctrl.service.FileETag(repo, collectionName, filePath)
+	if err != nil {
+		log.Errorf("Failed to get the version of %s: %v", filePath, err)
+		return ""
+	}
+	core.SetETag(c, etag)
+	return etag
+}
+
+// QueryEntries lists collection entries from the collection index. Query parameters: repeated
+// filter=field:op:value, sort=-date,title, limit, cursor and fields=title,date.
+func (ctrl *UserGitRepoCollectionController) QueryEntries(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	q, err := query.Parse(c.Request.URL.Query(), "-_name")
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "query entries")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	page, err := ctrl.service.QueryEntries(repo, collectionName.String(), q)
+	if err != nil {
+		log.Errorf("Failed to query entries of collection %s: %v", collectionName.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// CreateEntry creates a markdown file from front matter and body, the file name generator of the collection
+// chooses its name within the requested directory
+func (ctrl *UserGitRepoCollectionController) CreateEntry(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+
+	var req models.CreateEntryRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "create entry")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	entry, err := ctrl.service.CreateEntry(c.Request.Context(), repo, collectionName.String(), strings.Trim(req.Path, "/"),
+		req.FrontMatter, req.Body, updateFileOptions(c, services.WriteCondition{UserID: userId.String()}))
+	if err != nil {
+		log.Errorf("Failed to create entry in collection %s: %v", collectionName.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+
+	log.Infof("Entry %s created and changes committed successfully", entry.Path)
+	c.JSON(http.StatusCreated, entry)
+}
+
+// ListFieldOptions returns the candidate values of a field, e.g. the entries a reference field can point to
+func (ctrl *UserGitRepoCollectionController) ListFieldOptions(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	fieldParam := reqParam.AddQueryParam("field", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "list field options")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	options, err := ctrl.service.ListFieldOptions(repo, collectionName.String(), fieldParam.String())
+	if err != nil {
+		log.Errorf("Failed to list options of field %s: %v", fieldParam.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, options)
+}
+
+// GetDocument returns a markdown file as {front_matter, body}, or an entry of a data file chosen by path and item
+func (ctrl *UserGitRepoCollectionController) GetDocument(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+	itemParam := reqParam.AddQueryParam("item", true, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	// The reviewers assigned to an entry may read it as well as the repository owner
+	repo, err := ctrl.fileWorkflowService.VerifyEntryAccess(userId.String(), uint(repoID), collectionName.String(), pathParam.String())
+	if err != nil {
+		log.Errorf("Failed to verify access to %s: %v", pathParam.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "read document")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	doc, err := ctrl.service.GetDocument(repo, collectionName.String(), pathParam.String(), itemParam.String())
+	if err != nil {
+		log.Errorf("Failed to get document %s: %v", pathParam.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+	ctrl.setETag(c, repo, collectionName.String(), pathParam.String())
+	c.JSON(http.StatusOK, doc)
+}
+
+// UpdateDocument replaces the front matter and body of a markdown file
+func (ctrl *UserGitRepoCollectionController) UpdateDocument(c *gin.Context) {
+	var req models.UpdateDocumentRequest
+	ctrl.writeDocument(c, &req, false)
+}
+
+// PatchDocument applies a JSON merge patch (RFC 7386) to the front matter of a markdown file
+func (ctrl *UserGitRepoCollectionController) PatchDocument(c *gin.Context) {
+	ctrl.writeDocument(c, nil, true)
+}
+
+// writeDocument handles PUT and PATCH of a document, for PATCH the whole request body is the merge patch
+func (ctrl *UserGitRepoCollectionController) writeDocument(c *gin.Context, req *models.UpdateDocumentRequest, mergePatch bool) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+	itemParam := reqParam.AddQueryParam("item", true, nil)
+
+	var err error
+	if mergePatch {
+		err = reqParam.Handle(c)
+		if err == nil {
+			req = &models.UpdateDocumentRequest{}
+			req.FrontMatter, err = c.GetRawData()
+		}
+	} else {
+		err = reqParam.HandleWithBody(c, req)
+	}
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "update document")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	doc, err := ctrl.service.UpdateDocument(c.Request.Context(), repo, collectionName.String(), pathParam.String(), itemParam.String(),
+		req.FrontMatter, req.Body, mergePatch,
+		updateFileOptions(c, writeCondition(c, ctrl.fileLeaseService, repo, collectionName.String(), pathParam.String(), userId.String())))
+	if err != nil {
+		log.Errorf("Failed to update document %s: %v", pathParam.String(), err)
+		core.HandleError(c, err)
+		return
+	}
+
+	log.Infof("Document %s updated and changes committed successfully", pathParam.String())
+	ctrl.setETag(c, repo, collectionName.String(), pathParam.String())
+	c.JSON(http.StatusOK, doc)
+}
+
+// CopyFile copies a file or directory, the response holds the path of the copy
+func (ctrl *UserGitRepoCollectionController) CopyFile(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+
+	var req models.CopyFileRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "copy file")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	newPath, err := ctrl.service.CopyFile(c.Request.Context(), repo, collectionName.String(), req.Path, req.NewPath)
+	if err != nil {
+		log.Errorf("Failed to copy %s: %v", req.Path, err)
+		core.HandleError(c, err)
+		return
+	}
+
+	log.Infof("%s copied to %s successfully", req.Path, newPath)
+	c.JSON(http.StatusCreated, gin.H{"path": newPath})
+}
+
+// BulkDeleteFiles deletes the selected files and directories in one commit
+func (ctrl *UserGitRepoCollectionController) BulkDeleteFiles(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+
+	var req models.BulkDeleteRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "delete files")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	deleted, err := ctrl.service.DeleteFiles(c.Request.Context(), repo, collectionName.String(), req.Paths, req.Versions, userId.String())
+	if err != nil {
+		log.Errorf("Failed to delete files: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	log.Infof("%d files deleted successfully", len(deleted))
+	core.ResponseOKArr(c, deleted)
+}
+
+// BulkMoveFiles moves the selected files and directories into one directory in one commit
+func (ctrl *UserGitRepoCollectionController) BulkMoveFiles(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+
+	var req models.BulkMoveRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	repo, err := ctrl.service.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "move files")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	moves, err := ctrl.service.MoveFiles(c.Request.Context(), repo, collectionName.String(), req.Paths, req.Target, req.Versions, userId.String())
+	if err != nil {
+		log.Errorf("Failed to move files: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	log.Infof("%d files moved to %s successfully", len(moves), req.Target)
+	core.ResponseOKArr(c, moves)
 }
 
 // DeleteFile deletes a file or directory within a collection
@@ -265,14 +786,18 @@ func (ctrl *UserGitRepoCollectionController) DeleteFile(c *gin.Context) {
 	}
 	filePath := pathParam.String()
 
-	lock := ctrl.userGitRepoLockService.Acquire(c.Param("repoId"))
-	lock.Lock()
-
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "delete file")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
 	defer lock.Unlock()
 
-	if err := ctrl.service.DeleteFile(repo, collectionName.String(), filePath); err != nil {
+	condition := writeCondition(c, ctrl.fileLeaseService, repo, collectionName.String(), filePath, userId.String())
+	if err := ctrl.service.DeleteFile(c.Request.Context(), repo, collectionName.String(), filePath, condition); err != nil {
 		log.Errorf("Failed to delete file: %v", err)
-		core.ResponseErr(c, http.StatusInternalServerError, err)
+		core.HandleError(c, err)
 		return
 	}
 	log.Infof("File %s deleted successfully", filePath)
@@ -332,25 +857,24 @@ func (ctrl *UserGitRepoCollectionController) UploadFile(c *gin.Context) {
 		content = []byte(request.Content)
 	}
 
-	lock := ctrl.userGitRepoLockService.Acquire(c.Param("repoId"))
-	lock.Lock()
-
-	defer lock.Unlock()
-	hasFrontMatter := strings.EqualFold("true", c.GetHeader("X-File-Front-Matter"))
-	var isDraft *bool = nil
-	if hasFrontMatter {
-		isDraft = new(bool)
-		*isDraft = strings.EqualFold("true", c.GetHeader("X-File-Front-Matter-Draft"))
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "upload file")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
 	}
+	defer lock.Unlock()
+	options := updateFileOptions(c, writeCondition(c, ctrl.fileLeaseService, repo, collectionName.String(), request.Path, userId.String()))
 
-	if err := ctrl.service.UpdateFileContent(repo, collectionName.String(), request.Path, content, isDraft); err != nil {
+	if err := ctrl.service.UpdateFileContent(c.Request.Context(), repo, collectionName.String(), request.Path, content, options); err != nil {
 		log.Errorf("Failed to update file content: %v", err)
-		core.ResponseErr(c, http.StatusInternalServerError, err)
+		core.HandleError(c, err)
 		return
 	}
 
 	log.Infof("File %s uploaded successfully", request.Path)
-	c.JSON(http.StatusCreated, gin.H{"message": "File uploaded successfully"})
+	etag := ctrl.setETag(c, repo, collectionName.String(), request.Path)
+	c.JSON(http.StatusCreated, gin.H{"message": "File uploaded successfully", "etag": etag})
 }
 
 // RenameFileRequest represents the request body for renaming a file
@@ -364,7 +888,7 @@ type CreateFolderRequest struct {
 	Folder string `json:"folder" binding:"required"`
 }
 
-// RenameFile renames a file in a collection
+// RenameFile renames or moves a file or directory in a collection
 func (ctrl *UserGitRepoCollectionController) RenameFile(c *gin.Context) {
 	reqParam := core.NewRequestParam()
 	userId := reqParam.AddContextParam("userId", false, nil).
@@ -393,20 +917,25 @@ func (ctrl *UserGitRepoCollectionController) RenameFile(c *gin.Context) {
 		return
 	}
 
-	lock := ctrl.userGitRepoLockService.Acquire(c.Param("repoId"))
-	lock.Lock()
-
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "rename file")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
 	defer lock.Unlock()
 
 	// Call service to rename file
-	err = ctrl.service.RenameFile(repo, collectionName.String(), req.OldPath, req.NewPath)
+	condition := writeCondition(c, ctrl.fileLeaseService, repo, collectionName.String(), req.OldPath, userId.String())
+	err = ctrl.service.RenameFile(c.Request.Context(), repo, collectionName.String(), req.OldPath, req.NewPath, condition)
 	if err != nil {
 		log.Errorf("Failed to rename file: %v", err)
-		core.ResponseErr(c, http.StatusInternalServerError, err)
+		core.HandleError(c, err)
 		return
 	}
 
 	log.Infof("File %s renamed to %s successfully", req.OldPath, req.NewPath)
+	ctrl.setETag(c, repo, collectionName.String(), req.NewPath)
 	c.Status(http.StatusOK)
 }
 
@@ -445,13 +974,16 @@ func (ctrl *UserGitRepoCollectionController) CreateFolder(c *gin.Context) {
 		return
 	}
 
-	lock := ctrl.userGitRepoLockService.Acquire(c.Param("repoId"))
-	lock.Lock()
-
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "create folder")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
 	defer lock.Unlock()
 
 	// Call service to create folder
-	err = ctrl.service.CreateFolder(repo, collectionName.String(), req.Path, req.Folder)
+	err = ctrl.service.CreateFolder(c.Request.Context(), repo, collectionName.String(), req.Path, req.Folder)
 	if err != nil {
 		log.Errorf("Failed to create folder: %v", err)
 		core.HandleError(c, err)
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/zhaojunlucky/mkdocs-cms/core"
 	"net/http"
@@ -18,6 +20,8 @@ type UserGitRepoController struct {
 	userGitRepoCollectionService *services.UserGitRepoCollectionService
 	asyncTaskService             *services.AsyncTaskService
 	userGitRepoLockService       *services.UserGitRepoLockService
+	userGitRepoHealthService     *services.UserGitRepoHealthService
+	vedaConfigService            *services.VedaConfigService
 }
 
 func (c *UserGitRepoController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
@@ -26,6 +30,8 @@ func (c *UserGitRepoController) Init(ctx *core.APPContext, router *gin.RouterGro
 	c.asyncTaskService = ctx.MustGetService("asyncTaskService").(*services.AsyncTaskService)
 	c.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
 	c.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*services.UserGitRepoCollectionService)
+	c.userGitRepoHealthService = ctx.MustGetService("userGitRepoHealthService").(*services.UserGitRepoHealthService)
+	c.vedaConfigService = ctx.MustGetService("vedaConfigService").(*services.VedaConfigService)
 	repos := router.Group("/repos")
 	{
 		repos.GET("/:id", c.GetRepo)
@@ -33,6 +39,15 @@ func (c *UserGitRepoController) Init(ctx *core.APPContext, router *gin.RouterGro
 		repos.DELETE("/:id", c.DeleteRepo)
 		repos.POST("/:id/sync", c.SyncRepo)
 		repos.GET("/:id/branches", c.GetRepoBranches)
+		repos.GET("/:id/lock", c.GetRepoLock)
+		repos.GET("/:id/health", c.GetRepoHealth)
+		repos.POST("/:id/repair", c.RepairRepo)
+		repos.GET("/:id/status", c.GetRepoSyncStatus)
+		repos.POST("/:id/push", c.PushPending)
+		repos.GET("/:id/config/proposal", c.ProposeVedaConfig)
+		repos.PUT("/:id/config", c.UpdateVedaConfig)
+		repos.POST("/:id/config/validate", c.ValidateVedaConfig)
+		repos.GET("/locks", c.GetRepoLocks)
 	}
 
 	// User repositories route
@@ -129,7 +144,7 @@ func (c *UserGitRepoController) UpdateRepo(ctx *gin.Context) {
 		return
 	}
 
-	repo, err = c.userGitRepoService.UpdateRepo(repo, request)
+	repo, err = c.userGitRepoService.UpdateRepo(ctx.Request.Context(), repo, request)
 	if err != nil {
 		log.Errorf("Failed to update repository: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -214,13 +229,18 @@ func (c *UserGitRepoController) SyncRepo(ctx *gin.Context) {
 		return
 	}
 
-	lock := c.userGitRepoLockService.Acquire(repoIDParam.String())
-	lock.Lock()
-
-	defer lock.Unlock()
+	// The lock is handed over to the sync goroutine and released when the sync finishes
+	lock, err := c.userGitRepoLockService.Lock(ctx.Request.Context(), repoIDParam.String(), userId.String(), "sync repository")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
+		core.HandleError(ctx, err)
+		return
+	}
 
 	// Update status to syncing
 	if err := c.userGitRepoService.UpdateRepoStatus(repo, models.StatusSyncing, ""); err != nil {
+		lock.Unlock()
 		log.Errorf("Failed to update repository status: %v", err)
 		core.ResponseErr(ctx, http.StatusInternalServerError, err)
 		return
@@ -228,17 +248,18 @@ func (c *UserGitRepoController) SyncRepo(ctx *gin.Context) {
 
 	// Start sync in a goroutine
 	go func() {
+		defer lock.Unlock()
 		// Update task status to running
 		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusRunning, "Repository sync in progress")
 
-		if err := c.userGitRepoService.SyncRepo(repo, ""); err != nil {
+		if err := c.userGitRepoService.SyncRepo(context.Background(), repo, ""); err != nil {
 			// Update task status to failed
 			log.Errorf("Failed to sync repository: %v", err)
 			c.userGitRepoService.UpdateRepoStatus(repo, models.StatusFailed, err.Error())
 			c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
 			return
 		}
-		if err := c.userGitRepoService.CheckWebHooks(repo); err != nil {
+		if err := c.userGitRepoService.CheckWebHooks(context.Background(), repo); err != nil {
 			// Update task status to failed
 			log.Errorf("Failed to check webhooks: %v", err)
 			c.userGitRepoService.UpdateRepoStatus(repo, models.StatusFailed, err.Error())
@@ -295,12 +316,417 @@ func (c *UserGitRepoController) GetRepoBranches(ctx *gin.Context) {
 	}
 
 	// Get the branches
-	branches, err := c.userGitRepoService.GetRepoBranches(repo)
+	branches, err := c.userGitRepoService.GetRepoBranches(ctx.Request.Context(), repo)
 	if err != nil {
 		log.Errorf("Failed to get repository branches: %v", err)
-		core.ResponseErr(ctx, http.StatusInternalServerError, err)
+		core.HandleError(ctx, err)
 		return
 	}
 
 	core.ResponseOKArr(ctx, branches)
 }
+
+// GetRepoLock returns who holds or is waiting for the lock of a specific git repository
+func (c *UserGitRepoController) GetRepoLock(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	// Verify repository ownership
+	if _, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID)); err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.userGitRepoLockService.GetLockState(repoIDParam.String()))
+}
+
+// GetRepoLocks returns the lock state of every git repository owned by the user
+func (c *UserGitRepoController) GetRepoLocks(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repos, err := c.userGitRepoService.GetReposByUser(userId.String())
+	if err != nil {
+		log.Errorf("Failed to get repositories: %v", err)
+		core.ResponseErr(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	repoIDs := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		repoIDs = append(repoIDs, fmt.Sprintf("%d", repo.ID))
+	}
+
+	core.ResponseOKArr(ctx, c.userGitRepoLockService.GetLockStates(repoIDs))
+}
+
+// GetRepoHealth diagnoses the local clone of a specific git repository
+func (c *UserGitRepoController) GetRepoHealth(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	// Verify repository ownership
+	repo, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	lock, err := c.userGitRepoLockService.RLock(ctx.Request.Context(), repoIDParam.String(), userId.String(), "diagnose repository")
+	if err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+	defer lock.Unlock()
+
+	diagnosis, err := c.userGitRepoHealthService.Diagnose(ctx.Request.Context(), repo)
+	if err != nil {
+		log.Errorf("Failed to diagnose repository: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, diagnosis)
+}
+
+// RepairRepo runs a repair action on the local clone of a specific git repository
+func (c *UserGitRepoController) RepairRepo(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+	var request models.RepairUserGitRepoRequest
+
+	if err := reqParam.HandleWithBody(ctx, &request); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	action, err := services.ParseRepairAction(request.Action)
+	if err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	// Verify repository ownership
+	repo, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	task, err := c.asyncTaskService.CreateTask(models.TaskTypeRepair, repoIDParam.String(), userId.String())
+	if err != nil {
+		log.Errorf("Failed to create repair task: %v", err)
+		core.ResponseErrStr(ctx, http.StatusInternalServerError, "Failed to create repair task")
+		return
+	}
+
+	// The lock is handed over to the repair goroutine and released when the repair finishes
+	lock, err := c.userGitRepoLockService.Lock(ctx.Request.Context(), repoIDParam.String(), userId.String(), fmt.Sprintf("repair repository (%s)", action))
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
+		core.HandleError(ctx, err)
+		return
+	}
+
+	go func() {
+		defer lock.Unlock()
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusRunning, fmt.Sprintf("Repository repair %s in progress", action))
+
+		message, err := c.userGitRepoHealthService.Repair(context.Background(), repo, action)
+		if err != nil {
+			log.Errorf("Failed to repair repository: %v", err)
+			c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
+			return
+		}
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusCompleted,
+			fmt.Sprintf("Repository repair %s completed: %s", action, message))
+	}()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Repository repair started",
+		"task_id": task.ID,
+	})
+}
+
+// GetRepoSyncStatus reports how far the local clone of a git repository diverges from its remote branch
+func (c *UserGitRepoController) GetRepoSyncStatus(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	// Verify repository ownership
+	repo, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	// fetching updates the remote tracking refs, so it must not overlap with a save
+	lock, err := c.userGitRepoLockService.Lock(ctx.Request.Context(), repoIDParam.String(), userId.String(), "fetch repository status")
+	if err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+	defer lock.Unlock()
+
+	status, err := c.userGitRepoService.GetSyncStatus(ctx.Request.Context(), repo)
+	if err != nil {
+		log.Errorf("Failed to get repository status: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+// PushPending retries delivering local commits of a git repository to its remote branch
+func (c *UserGitRepoController) PushPending(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	// Verify repository ownership
+	repo, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	task, err := c.asyncTaskService.CreateTask(models.TaskTypePush, repoIDParam.String(), userId.String())
+	if err != nil {
+		log.Errorf("Failed to create push task: %v", err)
+		core.ResponseErrStr(ctx, http.StatusInternalServerError, "Failed to create push task")
+		return
+	}
+
+	// The lock is handed over to the push goroutine and released when the push finishes
+	lock, err := c.userGitRepoLockService.Lock(ctx.Request.Context(), repoIDParam.String(), userId.String(), "push pending commits")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
+		core.HandleError(ctx, err)
+		return
+	}
+
+	go func() {
+		defer lock.Unlock()
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusRunning, "Pushing pending commits")
+
+		if err := c.userGitRepoService.PushPending(context.Background(), repo); err != nil {
+			log.Errorf("Failed to push pending commits: %v", err)
+			c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusFailed, err.Error())
+			return
+		}
+		c.asyncTaskService.UpdateTaskStatus(task.ID, models.TaskStatusCompleted, "Pending commits pushed successfully")
+	}()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Push of pending commits started",
+		"task_id": task.ID,
+	})
+}
+
+// ProposeVedaConfig infers a veda/config.yml from the mkdocs.yml and docs tree of a git repository
+func (c *UserGitRepoController) ProposeVedaConfig(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+
+	if err := reqParam.Handle(ctx); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	// Verify repository ownership
+	repo, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	lock, err := c.userGitRepoLockService.RLock(ctx.Request.Context(), repoIDParam.String(), userId.String(), "propose veda config")
+	if err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+	defer lock.Unlock()
+
+	proposal, err := c.vedaConfigService.ProposeConfig(repo)
+	if err != nil {
+		log.Errorf("Failed to propose veda config: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, proposal)
+}
+
+// UpdateVedaConfig commits a new veda/config.yml to a git repository
+func (c *UserGitRepoController) UpdateVedaConfig(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+	var request models.UpdateVedaConfigRequest
+
+	if err := reqParam.HandleWithBody(ctx, &request); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	// Verify repository ownership
+	repo, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	lock, err := c.userGitRepoLockService.Lock(ctx.Request.Context(), repoIDParam.String(), userId.String(), "update veda config")
+	if err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+	defer lock.Unlock()
+
+	if err := c.vedaConfigService.CommitConfig(ctx.Request.Context(), repo, []byte(request.Content)); err != nil {
+		log.Errorf("Failed to commit veda config: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "veda/config.yml updated successfully"})
+}
+
+// ValidateVedaConfig validates an edited veda/config.yml of a git repository before it is committed
+func (c *UserGitRepoController) ValidateVedaConfig(ctx *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", true, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("id", true, regexp.MustCompile(`\d+`))
+	var request models.UpdateVedaConfigRequest
+
+	if err := reqParam.HandleWithBody(ctx, &request); err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		log.Errorf("Failed to parse repository ID: %v", err)
+		core.ResponseErrStr(ctx, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+
+	// Verify repository ownership
+	repo, err := c.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(ctx, err)
+		return
+	}
+
+	lock, err := c.userGitRepoLockService.RLock(ctx.Request.Context(), repoIDParam.String(), userId.String(), "validate veda config")
+	if err != nil {
+		core.HandleError(ctx, err)
+		return
+	}
+	defer lock.Unlock()
+
+	findings := c.vedaConfigService.ValidateConfig(repo, []byte(request.Content))
+	ctx.JSON(http.StatusOK, gin.H{
+		"valid":  !findings.HasErrors(),
+		"errors": findings,
+	})
+}
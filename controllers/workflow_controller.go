@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"github.com/zhaojunlucky/mkdocs-cms/services"
+)
+
+// WorkflowController handles the editorial workflows of collection entries. Besides the repository owner the
+// reviewers assigned to an entry have access to it, the workflow service checks who may do what.
+type WorkflowController struct {
+	BaseController
+	fileWorkflowService          *services.FileWorkflowService
+	userGitRepoCollectionService *services.UserGitRepoCollectionService
+	userGitRepoLockService       *services.UserGitRepoLockService
+}
+
+func (ctrl *WorkflowController) Init(ctx *core.APPContext, router *gin.RouterGroup) {
+	ctrl.ctx = ctx
+	ctrl.fileWorkflowService = ctx.MustGetService("fileWorkflowService").(*services.FileWorkflowService)
+	ctrl.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*services.UserGitRepoCollectionService)
+	ctrl.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*services.UserGitRepoLockService)
+	workflow := router.Group("/workflow")
+	{
+		workflow.GET("/reviews", ctrl.ListReviews)
+		workflow.GET("/repo/:repoId/:collectionName/file", ctrl.GetWorkflow)
+		workflow.POST("/repo/:repoId/:collectionName/transition", ctrl.Transition)
+		workflow.PUT("/repo/:repoId/:collectionName/reviewers", ctrl.AssignReviewers)
+		workflow.POST("/repo/:repoId/:collectionName/review", ctrl.Review)
+	}
+}
+
+// repo returns the repository of a request to the repository owner or a reviewer of the entry, before any lock
+// of the repository is taken. What else the user may do is checked by the workflow service.
+func (ctrl *WorkflowController) repo(userId *core.ParamDef, repoIDParam *core.ParamDef, collectionName string, filePath string) (*models.UserGitRepo, error) {
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "Invalid repository ID")
+	}
+	return ctrl.fileWorkflowService.VerifyEntryAccess(userId.String(), uint(repoID), collectionName, filePath)
+}
+
+// ListReviews returns the entries the user is assigned to review
+func (ctrl *WorkflowController) ListReviews(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	workflows, err := ctrl.fileWorkflowService.Assigned(userId.String())
+	if err != nil {
+		log.Errorf("Failed to list assigned reviews: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, workflows)
+}
+
+// GetWorkflow returns the state, reviewers, allowed transitions and history of an entry
+func (ctrl *WorkflowController) GetWorkflow(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	pathParam := reqParam.AddQueryParam("path", false, nil)
+	if err := reqParam.Handle(c); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repo, err := ctrl.repo(userId, repoIDParam, collectionName.String(), pathParam.String())
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "get workflow")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	workflow, err := ctrl.fileWorkflowService.Get(repo, collectionName.String(), pathParam.String(), userId.String())
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, workflow)
+}
+
+// Transition moves an entry to another state, entering or leaving the publish state commits the draft key.
+// If-Match names the version of the entry the transition is based on.
+func (ctrl *WorkflowController) Transition(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	var req models.WorkflowTransitionRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repo, err := ctrl.repo(userId, repoIDParam, collectionName.String(), req.Path)
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.Lock(c.Request.Context(), repoIDParam.String(), userId.String(), "workflow transition")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	status, err := ctrl.fileWorkflowService.Transition(c.Request.Context(), repo, collectionName.String(), req.Path,
+		userId.String(), req.To, req.Comment, core.IfMatch(c))
+	if err != nil {
+		log.Errorf("Failed to move %s to %s: %v", req.Path, req.To, err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// AssignReviewers replaces the reviewers of an entry
+func (ctrl *WorkflowController) AssignReviewers(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	var req models.AssignReviewersRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repoID, err := repoIDParam.UInt64()
+	if err != nil {
+		core.ResponseErrStr(c, http.StatusBadRequest, "Invalid repository ID")
+		return
+	}
+	// only the repository owner assigns reviewers
+	repo, err := ctrl.userGitRepoCollectionService.VerifyRepoOwnership(userId.String(), uint(repoID))
+	if err != nil {
+		log.Errorf("Failed to verify repository ownership: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "assign reviewers")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	reviewers, err := ctrl.fileWorkflowService.AssignReviewers(repo, collectionName.String(), req.Path, userId.String(), req.Reviewers)
+	if err != nil {
+		log.Errorf("Failed to assign the reviewers of %s: %v", req.Path, err)
+		core.HandleError(c, err)
+		return
+	}
+	core.ResponseOKArr(c, reviewers)
+}
+
+// Review approves an entry or asks for changes
+func (ctrl *WorkflowController) Review(c *gin.Context) {
+	reqParam := core.NewRequestParam()
+	userId := reqParam.AddContextParam("userId", false, nil).
+		SetError(http.StatusUnauthorized, "Unauthorized")
+	repoIDParam := reqParam.AddUrlParam("repoId", false, regexp.MustCompile(`\d+`))
+	collectionName := reqParam.AddUrlParam("collectionName", false, nil)
+	var req models.WorkflowReviewRequest
+	if err := reqParam.HandleWithBody(c, &req); err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	repo, err := ctrl.repo(userId, repoIDParam, collectionName.String(), req.Path)
+	if err != nil {
+		core.HandleError(c, err)
+		return
+	}
+
+	lock, err := ctrl.userGitRepoLockService.RLock(c.Request.Context(), repoIDParam.String(), userId.String(), "review entry")
+	if err != nil {
+		log.Errorf("Failed to acquire repository lock: %v", err)
+		core.HandleError(c, err)
+		return
+	}
+	defer lock.Unlock()
+
+	status, err := ctrl.fileWorkflowService.Review(repo, collectionName.String(), req.Path, userId.String(), req.Decision, req.Comment)
+	if err != nil {
+		log.Errorf("Failed to review %s: %v", req.Path, err)
+		core.HandleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/google/go-github/v45/github"
 	"github.com/zhaojunlucky/mkdocs-cms/config"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
 	"github.com/zhaojunlucky/mkdocs-cms/models"
 	"gorm.io/gorm"
 )
@@ -18,6 +19,13 @@ func (e *HTTPError) Error() string {
 	return fmt.Sprintf("HTTPError: StatusCode=%d, Message=%s", e.StatusCode, e.Message)
 }
 
+// MessagesError is an error that is reported to clients as several messages, for example one per invalid field
+type MessagesError interface {
+	error
+	StatusCode() int
+	Messages() []string
+}
+
 func NewHTTPErrorStr(code int, message string) error {
 	return &HTTPError{
 		StatusCode: code,
@@ -52,6 +60,7 @@ type APPContext struct {
 	ServiceMap        map[string]interface{}
 	Config            *config.Config
 	GithubAppClient   *github.Client
+	Git               *git.Runner
 	RepoBasePath      string
 	LogDirPath        string
 	Version           string
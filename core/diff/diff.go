@@ -0,0 +1,125 @@
+package diff
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Hunk is a changed block of lines, as in a unified diff header. A block with OldCount 0 inserts lines after
+// OldStart, one with NewCount 0 deletes lines before NewStart.
+type Hunk struct {
+	OldStart int
+	OldCount int
+	NewStart int
+	NewCount int
+}
+
+// File is the change of one file. OldPath is empty for an added file, NewPath for a deleted one. Renames have
+// both paths, with or without hunks.
+type File struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+}
+
+var hunkRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Parse reads the output of git diff. Only the hunk headers are used, so the output of -U0 is enough.
+func Parse(out []byte) []File {
+	var files []File
+	var current *File
+	inHunks := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			files = append(files, File{})
+			current = &files[len(files)-1]
+			inHunks = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if match := hunkRegex.FindStringSubmatch(line); match != nil {
+			inHunks = true
+			current.Hunks = append(current.Hunks, Hunk{
+				OldStart: atoi(match[1], 0),
+				OldCount: atoi(match[2], 1),
+				NewStart: atoi(match[3], 0),
+				NewCount: atoi(match[4], 1),
+			})
+			continue
+		}
+		// Changed lines may look like headers, the headers of a file come before its hunks
+		if inHunks {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "rename from "):
+			current.OldPath = unquote(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			current.NewPath = unquote(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "--- "):
+			current.OldPath = headerPath(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "+++ "):
+			current.NewPath = headerPath(strings.TrimPrefix(line, "+++ "), "b/")
+		}
+	}
+	return files
+}
+
+func atoi(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// unquote decodes a path git quoted because of special characters
+func unquote(p string) string {
+	if strings.HasPrefix(p, `"`) {
+		if unquoted, err := strconv.Unquote(p); err == nil {
+			return unquoted
+		}
+	}
+	return p
+}
+
+// headerPath returns the path of a ---/+++ line, empty for /dev/null
+func headerPath(p string, prefix string) string {
+	p = unquote(strings.TrimSuffix(p, "\t"))
+	if p == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(p, prefix)
+}
+
+// MapLine maps a 1-based line of the old side to the new side. exact is false for a line of a changed block,
+// which maps into the block that replaced it. A deleted line maps to 0.
+func MapLine(hunks []Hunk, line int) (newLine int, exact bool) {
+	offset := 0
+	for _, hunk := range hunks {
+		if hunk.OldCount == 0 {
+			if line > hunk.OldStart {
+				offset += hunk.NewCount
+				continue
+			}
+			break
+		}
+		if line < hunk.OldStart {
+			break
+		}
+		if line < hunk.OldStart+hunk.OldCount {
+			if hunk.NewCount == 0 {
+				return 0, false
+			}
+			return hunk.NewStart + min(line-hunk.OldStart, hunk.NewCount-1), false
+		}
+		offset += hunk.NewCount - hunk.OldCount
+	}
+	return line + offset, true
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnyVersion is the If-Match value that only requires the file to exist
+const AnyVersion = "*"
+
+// PreconditionFailedError reports a write that was based on a stale version of a file. ETag is the
+// current version and empty when the file no longer exists.
+type PreconditionFailedError struct {
+	Path string
+	ETag string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	if e.ETag == "" {
+		return fmt.Sprintf("%s does not exist anymore", e.Path)
+	}
+	return fmt.Sprintf("%s was changed in the meantime, reload it and apply your changes again", e.Path)
+}
+
+// PreconditionFailedDTO is the body of a 412 response, it carries the current version of the file
+type PreconditionFailedDTO struct {
+	ErrorMessageDTO
+	CurrentETag string `json:"currentETag"`
+}
+
+// FormatETag quotes a version for the ETag header
+func FormatETag(version string) string {
+	return `"` + version + `"`
+}
+
+// ParseIfMatch returns the version of an If-Match header, without quotes and weak marker
+func ParseIfMatch(header string) string {
+	header = strings.TrimSpace(header)
+	header = strings.TrimPrefix(header, "W/")
+	return strings.Trim(header, `"`)
+}
+
+// IfMatch returns the version the client based its write on, empty when it did not send one
+func IfMatch(c *gin.Context) string {
+	return ParseIfMatch(c.GetHeader("If-Match"))
+}
+
+// IfNoneMatchAny tells whether the client sent If-None-Match: *, the write may then only create the file
+func IfNoneMatchAny(c *gin.Context) bool {
+	return strings.TrimSpace(c.GetHeader("If-None-Match")) == AnyVersion
+}
+
+// SetETag sets the ETag header, nothing is set for an empty version
+func SetETag(c *gin.Context, version string) {
+	if version != "" {
+		c.Header("ETag", FormatETag(version))
+	}
+}
+
+func responsePreconditionFailed(c *gin.Context, err *PreconditionFailedError) {
+	SetETag(c, err.ETag)
+	c.JSON(http.StatusPreconditionFailed, PreconditionFailedDTO{
+		ErrorMessageDTO: NewErrorMessageDTO(http.StatusPreconditionFailed, err),
+		CurrentETag:     err.ETag,
+	})
+}
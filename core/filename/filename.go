@@ -0,0 +1,214 @@
+package filename
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// TypeSlug names files after the slug of the title, e.g. my-first-post.md
+	TypeSlug = "slug"
+	// TypeDate prefixes the slug with the date, e.g. 2025-04-18-my-first-post.md
+	TypeDate = "date"
+	// TypeDateFolder puts the slug into year, month and day folders, e.g. 2025/04/18/my-first-post.md
+	TypeDateFolder = "date_folder"
+	// TypeSequence prefixes the slug with the next number of the directory, e.g. 12-my-first-post.md
+	TypeSequence = "sequence"
+	// TypeUUID names files with a random uuid
+	TypeUUID = "uuid"
+
+	// maxSlugLength keeps generated names well below file system limits
+	maxSlugLength = 80
+	// maxAttempts bounds the suffixes tried when a name is taken
+	maxAttempts = 1000
+	untitled    = "untitled"
+)
+
+// Types are the supported generator types
+var Types = []string{TypeDate, TypeSequence, TypeSlug, TypeDateFolder, TypeUUID}
+
+var sequenceRegex = regexp.MustCompile(`^(\d+)(?:[-_.]|$)`)
+
+// transliterations covers letters that do not decompose into an ASCII letter and a combining mark
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'þ': "th", 'ł': "l", 'ı': "i", 'ŋ': "ng",
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "yo", 'є': "ye", 'ж': "zh",
+	'з': "z", 'и': "i", 'і': "i", 'ї': "yi", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh",
+	'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k",
+	'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Generator creates the names of new collection files, it mirrors file_name_generator of veda/config.yml
+type Generator struct {
+	Type string
+	// First is the name of the first file of a sequence, a number sets the start and the zero padding
+	First string
+}
+
+// Input is what a new name is derived from
+type Input struct {
+	Title string
+	// Date is used by the date generators, the zero time means now
+	Date time.Time
+	// Ext is the extension including the dot
+	Ext string
+	// Siblings are the names in the target directory, the sequence generator continues their numbering
+	Siblings []string
+	// Exists reports whether a path is taken, a suffix is appended until it is not
+	Exists func(p string) bool
+}
+
+// Generate returns a free path for a new file in dir, dir and the result are slash separated
+func (g Generator) Generate(dir string, in Input) (string, error) {
+	date := in.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	slug := Slugify(in.Title)
+
+	var name string
+	switch g.Type {
+	case "", TypeSlug:
+		name = orUntitled(slug)
+	case TypeDate:
+		name = joinNonEmpty(date.Format("2006-01-02"), slug)
+	case TypeDateFolder:
+		dir = path.Join(dir, date.Format("2006"), date.Format("01"), date.Format("02"))
+		name = orUntitled(slug)
+	case TypeSequence:
+		name = g.nextInSequence(in.Siblings, slug)
+	case TypeUUID:
+		name = uuid.New().String()
+	default:
+		return "", fmt.Errorf("unsupported file name generator %q", g.Type)
+	}
+
+	for i := 1; i <= maxAttempts; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", name, i)
+		}
+		p := path.Join(dir, candidate+in.Ext)
+		if in.Exists == nil || !in.Exists(p) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no free file name for %q after %d attempts", name, maxAttempts)
+}
+
+// nextInSequence numbers the file one above the highest numbered sibling, keeping their zero padding
+func (g Generator) nextInSequence(siblings []string, slug string) string {
+	highest, width, found := 0, 0, false
+	for _, sibling := range siblings {
+		match := sequenceRegex.FindStringSubmatch(sibling)
+		if match == nil {
+			continue
+		}
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		if !found || n > highest {
+			highest = n
+		}
+		if strings.HasPrefix(match[1], "0") {
+			width = max(width, len(match[1]))
+		}
+		found = true
+	}
+
+	next := highest + 1
+	if !found {
+		first, err := strconv.Atoi(g.First)
+		if g.First != "" && err != nil {
+			return g.First
+		}
+		next = 1
+		if err == nil {
+			next = first
+			if strings.HasPrefix(g.First, "0") {
+				width = len(g.First)
+			}
+		}
+	}
+	return joinNonEmpty(fmt.Sprintf("%0*d", width, next), slug)
+}
+
+// Slugify lowercases text, transliterates accented, Cyrillic and Greek letters to ASCII and joins words
+// with dashes. Letters of other scripts, e.g. CJK, are kept as they are.
+func Slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	write := func(s string) {
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteString(s)
+	}
+
+	for _, r := range strings.ToLower(text) {
+		if s, ok := transliterations[r]; ok {
+			if s != "" {
+				write(s)
+			}
+			continue
+		}
+		// Decompose accented letters and drop the marks, Hangul syllables would fall apart into jamo
+		decomposed := string(r)
+		if !unicode.Is(unicode.Hangul, r) {
+			decomposed = norm.NFD.String(decomposed)
+		}
+		for _, d := range decomposed {
+			switch {
+			case unicode.Is(unicode.Mn, d):
+			case unicode.IsLetter(d) || unicode.IsDigit(d):
+				if s, ok := transliterations[d]; ok {
+					write(s)
+				} else {
+					write(string(d))
+				}
+			default:
+				dash = true
+			}
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		// Cut at a word boundary and never in the middle of a multi-byte letter
+		if i := strings.LastIndexByte(slug, '-'); i > maxSlugLength/2 {
+			slug = slug[:i]
+		}
+		slug = strings.ToValidUTF8(slug, "")
+	}
+	return strings.Trim(slug, "-")
+}
+
+func orUntitled(slug string) string {
+	if slug == "" {
+		return untitled
+	}
+	return slug
+}
+
+func joinNonEmpty(prefix string, slug string) string {
+	if slug == "" {
+		return prefix
+	}
+	return prefix + "-" + slug
+}
@@ -0,0 +1,202 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/config"
+)
+
+// Op classifies a git invocation so that it gets the matching deadline
+type Op string
+
+const (
+	OpClone Op = "clone"
+	OpFetch Op = "fetch"
+	OpPush  Op = "push"
+	OpLocal Op = "local"
+)
+
+const (
+	defaultCloneTimeout = 5 * time.Minute
+	defaultFetchTimeout = 2 * time.Minute
+	defaultPushTimeout  = 2 * time.Minute
+	defaultLocalTimeout = 30 * time.Second
+)
+
+var (
+	// ErrTimeout is matched by every error caused by a git command exceeding its deadline
+	ErrTimeout = errors.New("git command timed out")
+	// ErrCanceled is matched by every error caused by the caller abandoning a git command
+	ErrCanceled = errors.New("git command canceled")
+	// tokenRegex matches the credentials of a token remote URL
+	tokenRegex = regexp.MustCompile(`x-access-token:[^@\s]+@`)
+)
+
+// TimeoutError is returned when a git command is killed because its deadline expired
+type TimeoutError struct {
+	Op      Op
+	Args    []string
+	Timeout time.Duration
+	Output  string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("git %s timed out after %s", e.Args[0], e.Timeout)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// CanceledError is returned when the context of a git command is canceled before it finishes
+type CanceledError struct {
+	Op   Op
+	Args []string
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("git %s canceled", e.Args[0])
+}
+
+func (e *CanceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+// CommandError is returned when a git command exits with a non-zero status
+type CommandError struct {
+	Op     Op
+	Args   []string
+	Output string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("git %s failed: %v", e.Args[0], e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Runner executes git commands with per operation deadlines
+type Runner struct {
+	timeouts map[Op]time.Duration
+	retry    RetryPolicy
+}
+
+// NewRunner creates a Runner from the git section of the application configuration
+func NewRunner(cfg config.GitConfig) *Runner {
+	r := &Runner{
+		timeouts: map[Op]time.Duration{
+			OpClone: secondsOr(cfg.CloneTimeoutSeconds, defaultCloneTimeout),
+			OpFetch: secondsOr(cfg.FetchTimeoutSeconds, defaultFetchTimeout),
+			OpPush:  secondsOr(cfg.PushTimeoutSeconds, defaultPushTimeout),
+			OpLocal: secondsOr(cfg.LocalTimeoutSeconds, defaultLocalTimeout),
+		},
+		retry: RetryPolicy{
+			MaxRetries: defaultMaxRetries,
+			BaseDelay:  defaultRetryBaseDelay,
+			MaxDelay:   defaultRetryMaxDelay,
+		},
+	}
+	if cfg.MaxRetries > 0 {
+		r.retry.MaxRetries = cfg.MaxRetries
+	}
+	if cfg.RetryBaseDelayMillis > 0 {
+		r.retry.BaseDelay = time.Duration(cfg.RetryBaseDelayMillis) * time.Millisecond
+	}
+	return r
+}
+
+func secondsOr(seconds int, def time.Duration) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return def
+}
+
+// Timeout returns the deadline applied to the given operation
+func (r *Runner) Timeout(op Op) time.Duration {
+	return r.timeouts[op]
+}
+
+// Output runs git in dir and returns its standard output, dir may be empty for commands like clone
+func (r *Runner) Output(ctx context.Context, op Op, dir string, args ...string) ([]byte, error) {
+	stdout, _, err := r.run(ctx, op, dir, args)
+	return stdout, err
+}
+
+// CombinedOutput runs git in dir and returns its standard output and standard error
+func (r *Runner) CombinedOutput(ctx context.Context, op Op, dir string, args ...string) ([]byte, error) {
+	_, combined, err := r.run(ctx, op, dir, args)
+	return combined, err
+}
+
+// Run runs git in dir and discards its output
+func (r *Runner) Run(ctx context.Context, op Op, dir string, args ...string) error {
+	_, _, err := r.run(ctx, op, dir, args)
+	return err
+}
+
+func (r *Runner) run(ctx context.Context, op Op, dir string, args []string) ([]byte, []byte, error) {
+	timeout := r.Timeout(op)
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fullArgs := args
+	if dir != "" {
+		fullArgs = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.CommandContext(cmdCtx, "git", fullArgs...)
+	// never block on a credential prompt, a missing token must fail instead of hanging
+	cmd.Env = append(cmd.Environ(), "GIT_TERMINAL_PROMPT=0")
+	setProcessGroup(cmd)
+
+	var stdout, combined bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, &combined)
+	cmd.Stderr = &combined
+
+	start := time.Now()
+	err := cmd.Run()
+	if err == nil {
+		log.Debugf("git %s finished in %s", args[0], time.Since(start))
+		return stdout.Bytes(), combined.Bytes(), nil
+	}
+
+	output := redact(combined.String())
+	switch {
+	case errors.Is(cmdCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		log.Errorf("git %s timed out after %s: %s", args[0], timeout, output)
+		return stdout.Bytes(), combined.Bytes(), &TimeoutError{Op: op, Args: args, Timeout: timeout, Output: output}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		log.Errorf("git %s exceeded the caller deadline: %s", args[0], output)
+		return stdout.Bytes(), combined.Bytes(), &TimeoutError{Op: op, Args: args, Timeout: time.Since(start).Round(time.Second), Output: output}
+	case ctx.Err() != nil:
+		log.Warnf("git %s canceled: %v", args[0], ctx.Err())
+		return stdout.Bytes(), combined.Bytes(), &CanceledError{Op: op, Args: args}
+	}
+	return stdout.Bytes(), combined.Bytes(), &CommandError{Op: op, Args: args, Output: output, Err: err}
+}
+
+// redact removes access tokens embedded in remote URLs from git output
+func redact(output string) string {
+	return tokenRegex.ReplaceAllString(output, "x-access-token:***@")
+}
+
+// BlobSHA returns the object id git assigns to a file with the given content
+func BlobSHA(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
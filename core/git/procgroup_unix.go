@@ -0,0 +1,19 @@
+//go:build !windows
+
+package git
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup starts git in its own process group so that a timeout kills
+// the helpers it spawns (remote-https, ssh, credential helpers) as well
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+}
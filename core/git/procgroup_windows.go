@@ -0,0 +1,13 @@
+//go:build windows
+
+package git
+
+import (
+	"os/exec"
+	"time"
+)
+
+// setProcessGroup only kills git itself on windows, there are no process groups to signal
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
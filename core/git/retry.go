@@ -0,0 +1,217 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FailureClass tells whether a failed git command is worth retrying
+type FailureClass string
+
+const (
+	// FailureTransient covers network hiccups and GitHub side 5xx errors
+	FailureTransient FailureClass = "transient"
+	// FailureAuth means the credentials were rejected, usually an expired installation token
+	FailureAuth FailureClass = "auth"
+	// FailurePermanent means retrying cannot help
+	FailurePermanent FailureClass = "permanent"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 10 * time.Second
+)
+
+var transientPatterns = []string{
+	"the remote end hung up unexpectedly",
+	"connection reset by peer",
+	"connection refused",
+	"connection timed out",
+	"operation timed out",
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"early eof",
+	"rpc failed",
+	"unexpected disconnect",
+	"ssl_read",
+	"gnutls_handshake",
+	"tls handshake timeout",
+	"http/2 stream",
+	"the requested url returned error: 500",
+	"the requested url returned error: 502",
+	"the requested url returned error: 503",
+	"the requested url returned error: 504",
+	"internal server error",
+	"bad gateway",
+	"service unavailable",
+}
+
+var authPatterns = []string{
+	"authentication failed",
+	"invalid username or password",
+	"the requested url returned error: 401",
+	"could not read username",
+	"bad credentials",
+}
+
+// permanentHints turns well known permanent failures into something the user can act on
+var permanentHints = []struct {
+	pattern string
+	hint    string
+}{
+	{"[rejected]", "the remote branch has commits that are not in the local copy, sync the repository and try again"},
+	{"non-fast-forward", "the remote branch has commits that are not in the local copy, sync the repository and try again"},
+	{"protected branch", "the branch is protected on GitHub, allow the app to push or choose another branch"},
+	{"repository not found", "the repository no longer exists or the GitHub App lost access to it, check the app installation"},
+	{"the requested url returned error: 403", "the GitHub App is not allowed to write to this repository, grant it contents write permission"},
+	{"permission to", "the GitHub App is not allowed to write to this repository, grant it contents write permission"},
+	{"couldn't find remote ref", "the branch does not exist on the remote, update the repository branch"},
+	{"not a git repository", "the local copy is damaged, repair or re-clone the repository"},
+	{"index.lock", "another git process left a lock file behind, repair the repository"},
+	{"would be overwritten", "the local copy has uncommitted changes that conflict with the remote, repair the repository"},
+	{"merge conflict", "the local copy conflicts with the remote, repair the repository"},
+	{"exceeds github's file size limit", "a file is larger than GitHub allows, remove it and try again"},
+}
+
+// PermanentError wraps a git failure that will not go away by retrying, with a hint for the user
+type PermanentError struct {
+	Err  error
+	Hint string
+}
+
+func (e *PermanentError) Error() string {
+	if e.Hint == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Err, e.Hint)
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Classify inspects a git error and its output to decide whether it is transient
+func Classify(err error) FailureClass {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrCanceled) {
+		return FailurePermanent
+	}
+	if errors.Is(err, ErrTimeout) {
+		return FailureTransient
+	}
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return FailurePermanent
+	}
+	output := strings.ToLower(cmdErr.Output)
+	for _, p := range authPatterns {
+		if strings.Contains(output, p) {
+			return FailureAuth
+		}
+	}
+	for _, p := range transientPatterns {
+		if strings.Contains(output, p) {
+			return FailureTransient
+		}
+	}
+	return FailurePermanent
+}
+
+// Hint returns an actionable description of a permanent git failure, or an empty string
+func Hint(err error) string {
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return ""
+	}
+	output := strings.ToLower(cmdErr.Output)
+	for _, h := range permanentHints {
+		if strings.Contains(output, h.pattern) {
+			return h.hint
+		}
+	}
+	return strings.TrimSpace(lastLine(cmdErr.Output))
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
+
+// RetryPolicy controls how often and how fast transient failures are retried
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// Delay returns the jittered exponential backoff before the given retry, starting at 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// full jitter between half and the whole delay keeps concurrent retries apart
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// RetryFunc performs one attempt, lastFailure is empty on the first attempt and
+// FailureAuth when the previous attempt was rejected so credentials must be refreshed
+type RetryFunc func(attempt int, lastFailure FailureClass) error
+
+// Retry runs fn until it succeeds, fails permanently or runs out of retries.
+// Auth failures are retried once so that fn can fetch a fresh token. Timeouts are not retried, the attempt
+// already took the whole deadline of the operation and retrying would hold the repository lock for several.
+// The error returned once retrying stops is a PermanentError with a hint for the user.
+func (r *Runner) Retry(ctx context.Context, name string, fn RetryFunc, onRetry func(class FailureClass, err error)) error {
+	var lastFailure FailureClass
+	authRetried := false
+	for attempt := 0; ; attempt++ {
+		err := fn(attempt, lastFailure)
+		if err == nil {
+			return nil
+		}
+
+		class := Classify(err)
+		switch {
+		case errors.Is(err, ErrTimeout):
+			log.Errorf("%s timed out, not retrying: %v", name, err)
+			return &PermanentError{Err: err, Hint: "the remote did not answer in time, check the GitHub status and try again later"}
+		case class == FailurePermanent:
+			return &PermanentError{Err: err, Hint: Hint(err)}
+		case class == FailureAuth && authRetried:
+			return &PermanentError{Err: err, Hint: "GitHub rejected a freshly issued token, check the app installation"}
+		case attempt >= r.retry.MaxRetries:
+			log.Errorf("%s failed after %d attempts: %v", name, attempt+1, err)
+			return &PermanentError{Err: err, Hint: fmt.Sprintf(
+				"the remote kept failing after %d attempts, check the GitHub status and try again later", attempt+1)}
+		}
+
+		delay := r.retry.Delay(attempt + 1)
+		if class == FailureAuth {
+			authRetried = true
+			delay = 0
+		}
+		log.Warnf("%s failed with a %s error, retrying in %s (attempt %d/%d): %v",
+			name, class, delay, attempt+1, r.retry.MaxRetries, err)
+		if onRetry != nil {
+			onRetry(class, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		lastFailure = class
+	}
+}
@@ -0,0 +1,182 @@
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// rule is one gitignore pattern, base is the slash separated directory of the file it came from
+type rule struct {
+	base    string
+	regex   *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides whether paths are ignored by gitignore style patterns. Patterns added later take
+// precedence, so the files of parent directories must be added before the files of their children.
+type Matcher struct {
+	rules []rule
+}
+
+// NewMatcher returns a matcher for the given patterns, which are relative to the root
+func NewMatcher(patterns []string) *Matcher {
+	m := &Matcher{}
+	m.AddPatterns("", patterns)
+	return m
+}
+
+// AddPatterns adds patterns of a .gitignore file in the directory base, relative to the root
+func (m *Matcher) AddPatterns(base string, patterns []string) {
+	base = strings.Trim(filepath.ToSlash(base), "/")
+	if base == "." {
+		base = ""
+	}
+	for _, pattern := range patterns {
+		if r, ok := compile(base, pattern); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+}
+
+// AddFile adds the patterns of a .gitignore file, a missing file is not an error
+func (m *Matcher) AddFile(base string, file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	m.AddPatterns(base, patterns)
+	return nil
+}
+
+// Match reports whether the slash separated path, relative to the root, is ignored.
+// It does not look at parent directories, callers walking a tree skip ignored directories.
+func (m *Matcher) Match(p string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	p = strings.Trim(filepath.ToSlash(p), "/")
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel := p
+		if r.base != "" {
+			if !strings.HasPrefix(p, r.base+"/") {
+				continue
+			}
+			rel = p[len(r.base)+1:]
+		}
+		if r.regex.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// compile converts a gitignore pattern into a regular expression
+func compile(base string, pattern string) (rule, bool) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return rule{}, false
+	}
+
+	r := rule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return rule{}, false
+	}
+
+	// A slash at the start or in the middle anchors the pattern to its directory
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			_, size := utf8.DecodeRuneInString(pattern[i+1:])
+			expr.WriteString(regexp.QuoteMeta(pattern[i+1 : i+1+size]))
+			i += size
+		default:
+			// copy the whole rune, a single byte of a multi-byte character is no literal
+			_, size := utf8.DecodeRuneInString(pattern[i:])
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+size]))
+			i += size - 1
+		}
+	}
+	expr.WriteString("$")
+
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return rule{}, false
+	}
+	r.regex = regex
+	return r, true
+}
+
+// Join is path.Join for slash separated paths relative to the root, the root itself is ""
+func Join(dir string, name string) string {
+	if dir == "" || dir == "." {
+		return name
+	}
+	return path.Join(dir, name)
+}
@@ -0,0 +1,62 @@
+package md
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+var frontMatterDelimiter = []byte("---")
+
+// SplitFrontMatter splits a markdown document into its YAML front matter and body.
+// ok is false when the document does not start with a front matter block.
+func SplitFrontMatter(content []byte) (frontMatter []byte, body []byte, ok bool) {
+	content = bytes.TrimPrefix(content, []byte("\ufeff"))
+	if !bytes.HasPrefix(content, frontMatterDelimiter) {
+		return nil, content, false
+	}
+	firstLineEnd := bytes.IndexByte(content, '\n')
+	if firstLineEnd < 0 || len(bytes.TrimSpace(content[:firstLineEnd])) != len(frontMatterDelimiter) {
+		return nil, content, false
+	}
+
+	rest := content[firstLineEnd+1:]
+	offset := 0
+	for offset <= len(rest) {
+		lineEnd := bytes.IndexByte(rest[offset:], '\n')
+		var line []byte
+		next := len(rest) + 1
+		if lineEnd < 0 {
+			line = rest[offset:]
+		} else {
+			line = rest[offset : offset+lineEnd]
+			next = offset + lineEnd + 1
+		}
+		if bytes.Equal(bytes.TrimRight(line, " \t\r"), frontMatterDelimiter) {
+			if next > len(rest) {
+				return rest[:offset], nil, true
+			}
+			return rest[:offset], rest[next:], true
+		}
+		offset = next
+	}
+	return nil, content, false
+}
+
+// ParseFrontMatter decodes the front matter of a markdown document into a map, a document
+// without front matter yields an empty map
+func ParseFrontMatter(content []byte) (map[string]interface{}, []byte, error) {
+	frontMatter, body, ok := SplitFrontMatter(content)
+	values := map[string]interface{}{}
+	if !ok {
+		return values, body, nil
+	}
+	if err := yaml.Unmarshal(frontMatter, &values); err != nil {
+		return nil, body, fmt.Errorf("invalid front matter: %v", err)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, body, nil
+}
@@ -0,0 +1,50 @@
+package md
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Heading is an ATX heading of a markdown document
+type Heading struct {
+	// Line is 1-based and counts the front matter lines
+	Line  int
+	Level int
+	Text  string
+}
+
+var (
+	headingRegex = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	fenceRegex   = regexp.MustCompile("^ {0,3}(```|~~~)")
+)
+
+// Headings returns the headings of a markdown document, the front matter and fenced code blocks are skipped
+func Headings(content []byte) []Heading {
+	lines := strings.Split(string(content), "\n")
+	start := 0
+	if frontMatter, _, ok := SplitFrontMatter(content); ok {
+		// the opening and closing delimiters
+		start = strings.Count(string(frontMatter), "\n") + 2
+	}
+
+	var headings []Heading
+	fence := ""
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if match := fenceRegex.FindStringSubmatch(line); match != nil {
+			if fence == "" {
+				fence = match[1]
+			} else if fence == match[1] {
+				fence = ""
+			}
+			continue
+		}
+		if fence != "" {
+			continue
+		}
+		if match := headingRegex.FindStringSubmatch(line); match != nil {
+			headings = append(headings, Heading{Line: i + 1, Level: len(match[1]), Text: strings.TrimSpace(match[2])})
+		}
+	}
+	return headings
+}
@@ -0,0 +1,396 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+)
+
+const (
+	// DefaultLimit is the page size used when a query does not set one
+	DefaultLimit = 50
+	// MaxLimit caps the page size of a query
+	MaxLimit = 500
+)
+
+// Operators are the supported filter operators
+var Operators = []string{"eq", "ne", "gt", "gte", "lt", "lte", "contains", "in", "prefix", "exists"}
+
+// timeLayouts are tried when two strings are compared, so that dates sort chronologically
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// Filter is one "field:op:value" condition, all filters of a query must match
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// SortKey orders results by a field
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Query selects, orders and pages the entries of a collection
+type Query struct {
+	Filters []Filter
+	Sort    []SortKey
+	Limit   int
+	Cursor  string
+	// Fields projects the front matter onto these keys, all keys are returned when empty
+	Fields []string
+}
+
+// Item is something a query runs over, Get resolves a possibly dotted field name
+type Item interface {
+	Get(field string) (interface{}, bool)
+	Key() string
+}
+
+// Parse reads a query from URL parameters: repeated filter=field:op:value, sort=-date,title,
+// limit, cursor and fields=title,date. defaultSort is used when no sort is given.
+func Parse(values url.Values, defaultSort string) (*Query, error) {
+	q := &Query{Limit: DefaultLimit, Cursor: values.Get("cursor")}
+
+	for _, raw := range values["filter"] {
+		parts := strings.SplitN(raw, ":", 3)
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("invalid filter %q, expected field:op:value", raw))
+		}
+		filter := Filter{Field: parts[0], Op: parts[1]}
+		if len(parts) == 3 {
+			filter.Value = parts[2]
+		}
+		if !slices.Contains(Operators, filter.Op) {
+			return nil, core.NewHTTPErrorStr(http.StatusBadRequest,
+				fmt.Sprintf("unsupported filter operator %q, expected one of %s", filter.Op, strings.Join(Operators, ", ")))
+		}
+		q.Filters = append(q.Filters, filter)
+	}
+
+	sortParam := values.Get("sort")
+	if sortParam == "" {
+		sortParam = defaultSort
+	}
+	for _, field := range splitList(sortParam) {
+		key := SortKey{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if key.Field != "" {
+			q.Sort = append(q.Sort, key)
+		}
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "limit must be a positive number")
+		}
+		q.Limit = n
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	q.Fields = splitList(values.Get("fields"))
+	return q, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Match reports whether an item satisfies every filter of the query
+func (q *Query) Match(item Item) bool {
+	for _, filter := range q.Filters {
+		value, ok := item.Get(filter.Field)
+		if !matchFilter(filter, value, ok) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchFilter(filter Filter, value interface{}, ok bool) bool {
+	if filter.Op == "exists" {
+		want := filter.Value == "" || filter.Value == "true"
+		return (ok && value != nil) == want
+	}
+	if !ok || value == nil {
+		return filter.Op == "ne"
+	}
+
+	// Conditions on a list match when any element matches, ne when no element is equal
+	if list, isList := value.([]interface{}); isList {
+		switch filter.Op {
+		case "contains":
+			return anyMatch(list, Filter{Op: "eq", Value: filter.Value})
+		case "ne":
+			return !anyMatch(list, Filter{Op: "eq", Value: filter.Value})
+		}
+		return anyMatch(list, filter)
+	}
+
+	switch filter.Op {
+	case "contains":
+		text, isText := value.(string)
+		return isText && strings.Contains(strings.ToLower(text), strings.ToLower(filter.Value))
+	case "prefix":
+		text, isText := value.(string)
+		return isText && strings.HasPrefix(text, filter.Value)
+	case "in":
+		for _, candidate := range strings.Split(filter.Value, ",") {
+			if c, ok := Compare(value, coerce(candidate, value)); ok && c == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	c, comparable := Compare(value, coerce(filter.Value, value))
+	if !comparable {
+		return filter.Op == "ne"
+	}
+	switch filter.Op {
+	case "eq":
+		return c == 0
+	case "ne":
+		return c != 0
+	case "gt":
+		return c > 0
+	case "gte":
+		return c >= 0
+	case "lt":
+		return c < 0
+	case "lte":
+		return c <= 0
+	}
+	return false
+}
+
+func anyMatch(list []interface{}, filter Filter) bool {
+	for _, element := range list {
+		if matchFilter(filter, element, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// coerce converts a filter value from the URL to the type of the value it is compared with
+func coerce(raw string, like interface{}) interface{} {
+	switch like.(type) {
+	case float64:
+		if n, err := strconv.ParseFloat(raw, 64); err == nil {
+			return n
+		}
+	case bool:
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+// Compare orders two values of the same kind, strings that are both dates compare chronologically.
+// ok is false when the values cannot be compared.
+func Compare(a interface{}, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			}
+			return 1, true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			if tx, okX := parseTime(x); okX {
+				if ty, okY := parseTime(y); okY {
+					return tx.Compare(ty), true
+				}
+			}
+			return strings.Compare(x, y), true
+		}
+	}
+	return 0, false
+}
+
+func parseTime(value string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// compareItems orders items by the sort keys, missing values sort last, the key breaks ties
+func (q *Query) compareItems(a Item, b Item) int {
+	for _, key := range q.Sort {
+		va, okA := a.Get(key.Field)
+		vb, okB := b.Get(key.Field)
+		okA, okB = okA && va != nil, okB && vb != nil
+		switch {
+		case !okA && !okB:
+			continue
+		case !okA:
+			return 1
+		case !okB:
+			return -1
+		}
+		c, ok := Compare(sortValue(va), sortValue(vb))
+		if !ok {
+			c = strings.Compare(fmt.Sprint(va), fmt.Sprint(vb))
+		}
+		if c != 0 {
+			if key.Desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return strings.Compare(a.Key(), b.Key())
+}
+
+// sortValue sorts a list by its first element
+func sortValue(value interface{}) interface{} {
+	if list, ok := value.([]interface{}); ok {
+		if len(list) == 0 {
+			return nil
+		}
+		return list[0]
+	}
+	return value
+}
+
+// cursor marks the last item of a page by its sort values, so that inserts and deletes do not shift pages
+type cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	Key    string        `json:"k"`
+}
+
+// cursorItem is the position stored in a cursor
+type cursorItem struct {
+	keys   []SortKey
+	values []interface{}
+	key    string
+}
+
+func (c cursorItem) Get(field string) (interface{}, bool) {
+	for i, key := range c.keys {
+		if key.Field == field && i < len(c.values) {
+			return c.values[i], c.values[i] != nil
+		}
+	}
+	return nil, false
+}
+
+func (c cursorItem) Key() string {
+	return c.key
+}
+
+func (q *Query) sortSignature() string {
+	var parts []string
+	for _, key := range q.Sort {
+		if key.Desc {
+			parts = append(parts, "-"+key.Field)
+		} else {
+			parts = append(parts, key.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (q *Query) encodeCursor(item Item) string {
+	c := cursor{Sort: q.sortSignature(), Key: item.Key()}
+	for _, key := range q.Sort {
+		value, _ := item.Get(key.Field)
+		c.Values = append(c.Values, value)
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q *Query) decodeCursor() (*cursorItem, error) {
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "invalid cursor")
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "invalid cursor")
+	}
+	if c.Sort != q.sortSignature() {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "cursor was created for a different sort order")
+	}
+	return &cursorItem{keys: q.Sort, values: c.Values, key: c.Key}, nil
+}
+
+// Page is one page of query results
+type Page[T Item] struct {
+	Items      []T
+	Total      int
+	NextCursor string
+}
+
+// Run filters, sorts and pages items. Total counts every match, not only the returned page.
+func Run[T Item](q *Query, items []T) (*Page[T], error) {
+	var matched []T
+	for _, item := range items {
+		if q.Match(item) {
+			matched = append(matched, item)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return q.compareItems(matched[i], matched[j]) < 0
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		after, err := q.decodeCursor()
+		if err != nil {
+			return nil, err
+		}
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.compareItems(matched[i], after) > 0
+		})
+	}
+
+	end := start + q.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page := &Page[T]{Items: matched[start:end], Total: len(matched)}
+	if end < len(matched) && end > start {
+		page.NextCursor = q.encodeCursor(matched[end-1])
+	}
+	return page, nil
+}
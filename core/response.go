@@ -3,6 +3,7 @@ package core
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
 	"net/http"
 )
 
@@ -23,8 +24,16 @@ func HandleError(c *gin.Context, err error) {
 		panic("unreachable")
 	}
 	var httpErr *HTTPError
-	if errors.As(err, &httpErr) {
+	var messagesErr MessagesError
+	var preconditionErr *PreconditionFailedError
+	if errors.As(err, &preconditionErr) {
+		responsePreconditionFailed(c, preconditionErr)
+	} else if errors.As(err, &messagesErr) {
+		c.JSON(messagesErr.StatusCode(), NewErrorMessageDTOStr(messagesErr.StatusCode(), messagesErr.Messages()...))
+	} else if errors.As(err, &httpErr) {
 		c.JSON(httpErr.StatusCode, NewErrorMessageDTO(httpErr.StatusCode, err))
+	} else if errors.Is(err, git.ErrTimeout) {
+		c.JSON(http.StatusGatewayTimeout, NewErrorMessageDTO(http.StatusGatewayTimeout, err))
 	} else {
 		c.JSON(http.StatusInternalServerError, NewErrorMessageDTO(http.StatusInternalServerError, err))
 	}
@@ -0,0 +1,122 @@
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateSuffix marks files rendered with text/template, other files are copied as is
+const templateSuffix = ".tmpl"
+
+// DefaultTemplate is used when a request does not name a template
+const DefaultTemplate = "docs"
+
+//go:embed all:templates
+var templatesFS embed.FS
+
+// TemplateData is the data available to template files. Templates use [[ ]] as delimiters
+// so that GitHub Actions expressions like ${{ }} can be written without escaping.
+type TemplateData struct {
+	SiteName    string
+	Description string
+	RepoURL     string
+	Branch      string
+	Date        string
+}
+
+var funcs = template.FuncMap{
+	// quote emits a double-quoted scalar, which is valid YAML for any input
+	"quote": strconv.Quote,
+}
+
+// Templates returns the names of the available site templates
+func Templates() []string {
+	entries, err := templatesFS.ReadDir("templates")
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasTemplate returns true if a site template with the given name exists
+func HasTemplate(name string) bool {
+	for _, t := range Templates() {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Render writes the files of the named template into dest and returns their paths relative to dest
+func Render(name string, data TemplateData, dest string) ([]string, error) {
+	if !HasTemplate(name) {
+		return nil, fmt.Errorf("site template %s not found", name)
+	}
+
+	root := path.Join("templates", name)
+	var files []string
+	err := fs.WalkDir(templatesFS, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		content, err := templatesFS.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(p, root+"/")
+		if strings.HasSuffix(rel, templateSuffix) {
+			rel = strings.TrimSuffix(rel, templateSuffix)
+			content, err = execute(rel, content, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render site template %s: %v", name, err)
+	}
+	return files, nil
+}
+
+func execute(name string, content []byte, data TemplateData) ([]byte, error) {
+	tmpl, err := template.New(name).Delims("[[", "]]").Funcs(funcs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
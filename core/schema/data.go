@@ -0,0 +1,351 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gopkg.in/yaml.v3"
+)
+
+// AppendItem is the item that appends a new entry to the list of a list collection
+const AppendItem = "-"
+
+// DataLayout describes where the entries of a yaml or json collection are
+type DataLayout struct {
+	Format models.ContentFormat
+	// List makes every item of the list, or every value of the mapping, at Root an entry instead of the whole file
+	List bool
+	// Root is the dotted key of the list within a file, the file itself when empty
+	Root string
+}
+
+// LayoutOf returns the data layout of a collection
+func LayoutOf(collection models.UserGitRepoCollection) DataLayout {
+	return DataLayout{Format: collection.Format, List: collection.Entries == models.EntriesList, Root: collection.Root}
+}
+
+// DataItem is one entry of a data file, Item is its index or key in a list file and empty otherwise
+type DataItem struct {
+	Item        string
+	FrontMatter json.RawMessage
+}
+
+// dataEntry is the node of one entry, prefix is the name its fields are reported under
+type dataEntry struct {
+	item   string
+	node   *yaml.Node
+	prefix string
+}
+
+// parseData decodes a yaml or json file into a document node, an empty file yields an empty document
+func parseData(format models.ContentFormat, content []byte) (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return doc, nil
+	}
+	if format == models.FormatJSON {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		node, err := readJSONNode(decoder)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, errors.New("invalid JSON: trailing data after the value")
+		}
+		doc.Content = []*yaml.Node{node}
+		return doc, nil
+	}
+	if err := yaml.Unmarshal(content, doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+	}
+	return doc, nil
+}
+
+// encodeData writes a document node back in the format of the file, JSON is indented by two spaces
+func encodeData(format models.ContentFormat, doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if format == models.FormatJSON {
+		var compact bytes.Buffer
+		if len(doc.Content) == 0 {
+			compact.WriteString("{}")
+		} else if err := writeNodeJSON(&compact, doc.Content[0], nil, nil); err != nil {
+			return nil, err
+		}
+		if err := json.Indent(&buf, compact.Bytes(), "", "  "); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// container returns the node holding the entries: the file mapping, or the list or mapping at the root
+// key of a list file. With create, missing nodes are added to the document.
+func container(doc *yaml.Node, layout DataLayout, create bool) (*yaml.Node, error) {
+	if len(doc.Content) == 0 {
+		if !create {
+			return nil, nil
+		}
+		kind, tag := yaml.MappingNode, "!!map"
+		if layout.List && layout.Root == "" {
+			kind, tag = yaml.SequenceNode, "!!seq"
+		}
+		doc.Content = []*yaml.Node{{Kind: kind, Tag: tag}}
+	}
+	node := doc.Content[0]
+	if !layout.List {
+		if node.Kind != yaml.MappingNode {
+			return nil, errors.New("a data file must hold a mapping of field names to values")
+		}
+		return node, nil
+	}
+
+	path := ""
+	if layout.Root != "" {
+		for _, key := range strings.Split(layout.Root, ".") {
+			if node.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%s must be a mapping", path)
+			}
+			path = strings.TrimPrefix(path+"."+key, ".")
+			child := mappingValue(node, key)
+			if child == nil {
+				if !create {
+					return nil, nil
+				}
+				child = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+			}
+			node = child
+		}
+	}
+	if node.Kind != yaml.SequenceNode && node.Kind != yaml.MappingNode {
+		if path == "" {
+			return nil, errors.New("the data file must hold a list or a mapping of entries")
+		}
+		return nil, fmt.Errorf("%s must be a list or a mapping of entries", path)
+	}
+	return node, nil
+}
+
+// dataEntries lists the entries of a parsed data file
+func dataEntries(doc *yaml.Node, layout DataLayout) ([]dataEntry, error) {
+	node, err := container(doc, layout, false)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		if layout.List {
+			return nil, nil
+		}
+		node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	if !layout.List {
+		return []dataEntry{{node: node}}, nil
+	}
+
+	var entries []dataEntry
+	if node.Kind == yaml.SequenceNode {
+		for i, item := range node.Content {
+			index := strconv.Itoa(i)
+			entries = append(entries, dataEntry{item: index, node: item, prefix: "[" + index + "]."})
+		}
+		return entries, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		entries = append(entries, dataEntry{item: key, node: node.Content[i+1], prefix: key + "."})
+	}
+	return entries, nil
+}
+
+func checkEntryNode(entry dataEntry) error {
+	if entry.node.Kind != yaml.MappingNode {
+		if entry.item == "" {
+			return errors.New("a data file must hold a mapping of field names to values")
+		}
+		return fmt.Errorf("entry %s must be a mapping of field names to values", entry.item)
+	}
+	return nil
+}
+
+// ParseDataItems returns every entry of a data file, values are typed according to the fields
+func ParseDataItems(fields []models.Field, layout DataLayout, content []byte) ([]DataItem, error) {
+	doc, err := parseData(layout.Format, content)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := dataEntries(doc, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := fieldsByName(fields)
+	items := make([]DataItem, 0, len(entries))
+	for _, entry := range entries {
+		if err := checkEntryNode(entry); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := writeNodeJSON(&buf, entry.node, byName, nil); err != nil {
+			return nil, err
+		}
+		items = append(items, DataItem{Item: entry.item, FrontMatter: buf.Bytes()})
+	}
+	return items, nil
+}
+
+// ParseDataDocument returns one entry of a data file as a Document without body. The item must be
+// empty for collections whose entries are files and name an entry otherwise.
+func ParseDataDocument(fields []models.Field, layout DataLayout, content []byte, item string) (*Document, error) {
+	if err := checkItem(layout, item); err != nil {
+		return nil, err
+	}
+	items, err := ParseDataItems(fields, layout, content)
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusUnprocessableEntity, err.Error())
+	}
+	for _, dataItem := range items {
+		if dataItem.Item == item {
+			return &Document{FrontMatter: dataItem.FrontMatter}, nil
+		}
+	}
+	return nil, core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("item %s not found", item))
+}
+
+func checkItem(layout DataLayout, item string) error {
+	if layout.List && item == "" {
+		return core.NewHTTPErrorStr(http.StatusBadRequest, "item is required for collections whose entries are list items")
+	}
+	if !layout.List && item != "" {
+		return core.NewHTTPErrorStr(http.StatusBadRequest, "item is only supported for collections whose entries are list items")
+	}
+	return nil
+}
+
+// ComposeDataDocument writes one entry into a data file, the front matter replaces the entry or is applied
+// to it as a JSON merge patch like ComposeDocument does. AppendItem adds an entry to a list and a new key
+// adds one to a mapping. It returns the content and the item that was written.
+func ComposeDataDocument(fields []models.Field, layout DataLayout, existing []byte, item string,
+	frontMatter json.RawMessage, mergePatch bool) ([]byte, string, error) {
+	if err := checkItem(layout, item); err != nil {
+		return nil, "", err
+	}
+	doc, err := parseData(layout.Format, existing)
+	if err != nil {
+		return nil, "", core.NewHTTPErrorStr(http.StatusUnprocessableEntity, err.Error())
+	}
+	node, err := container(doc, layout, true)
+	if err != nil {
+		return nil, "", core.NewHTTPErrorStr(http.StatusUnprocessableEntity, err.Error())
+	}
+	update, err := readFrontMatterUpdate(fields, frontMatter)
+	if err != nil {
+		return nil, "", err
+	}
+	if update == nil {
+		update = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+
+	target := node
+	if layout.List {
+		target = nil
+		if node.Kind == yaml.SequenceNode {
+			if item == AppendItem {
+				item = strconv.Itoa(len(node.Content))
+			} else if index, err := strconv.Atoi(item); err == nil && index >= 0 && index < len(node.Content) {
+				target = node.Content[index]
+			} else {
+				return nil, "", core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("item %s not found", item))
+			}
+		} else {
+			if item == AppendItem {
+				return nil, "", core.NewHTTPErrorStr(http.StatusBadRequest, "entries of a mapping are added by their key")
+			}
+			target = mappingValue(node, item)
+		}
+
+		if target == nil {
+			if mergePatch {
+				return nil, "", core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("item %s not found", item))
+			}
+			target = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			if node.Kind == yaml.SequenceNode {
+				node.Content = append(node.Content, target)
+			} else {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item}, target)
+			}
+		}
+		if target.Kind != yaml.MappingNode {
+			return nil, "", core.NewHTTPErrorStr(http.StatusUnprocessableEntity,
+				fmt.Sprintf("entry %s must be a mapping of field names to values", item))
+		}
+	}
+
+	mergeNode(target, update, mergePatch)
+	content, err := encodeData(layout.Format, doc)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, item, nil
+}
+
+// ValidateData checks every entry of a data file against the fields of its collection, with the same rules
+// and coercions as ValidateFrontMatter. The content is re-encoded only when a value had to be changed.
+func ValidateData(fields []models.Field, layout DataLayout, content []byte, options FrontMatterOptions) ([]byte, error) {
+	var checked []models.Field
+	for _, field := range fields {
+		if !isContentField(field) {
+			checked = append(checked, field)
+		}
+	}
+
+	doc, err := parseData(layout.Format, content)
+	if err != nil {
+		return nil, FrontMatterErrors{{Message: err.Error()}}
+	}
+	entries, err := dataEntries(doc, layout)
+	if err != nil {
+		return nil, FrontMatterErrors{{Message: err.Error()}}
+	}
+
+	var errs FrontMatterErrors
+	changed := false
+	for _, entry := range entries {
+		if err := checkEntryNode(entry); err != nil {
+			errs = append(errs, FieldError{Message: err.Error()})
+			continue
+		}
+		entryErrs, entryChanged := validateMapping(checked, entry.node, entry.prefix, options)
+		errs = append(errs, entryErrs...)
+		changed = changed || entryChanged
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if !changed || len(doc.Content) == 0 {
+		return content, nil
+	}
+	return encodeData(layout.Format, doc)
+}
@@ -0,0 +1,346 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/md"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Document is a markdown file split into its front matter and body. FrontMatter is a JSON object
+// whose keys keep the order of the YAML source.
+type Document struct {
+	FrontMatter json.RawMessage `json:"front_matter"`
+	Body        string          `json:"body"`
+}
+
+// ParseDocument splits a markdown file into a Document, front matter values are typed according to the fields
+// of the collection so that, for example, a date is always returned as its text and a title as a string
+func ParseDocument(fields []models.Field, content []byte) (*Document, error) {
+	frontMatter, body, _ := md.SplitFrontMatter(content)
+	mapping, err := parseFrontMatterNode(frontMatter)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeNodeJSON(&buf, mapping, fieldsByName(fields), nil); err != nil {
+		return nil, err
+	}
+	return &Document{FrontMatter: buf.Bytes(), Body: string(body)}, nil
+}
+
+// ComposeDocument writes front matter and body back into a markdown file. The front matter of the
+// existing content is updated in place so that key order and comments survive: with mergePatch the front
+// matter is applied as an RFC 7386 JSON merge patch, otherwise it replaces the existing front matter.
+// A nil body keeps the existing body.
+func ComposeDocument(fields []models.Field, existing []byte, frontMatter json.RawMessage, body *string, mergePatch bool) ([]byte, error) {
+	oldFrontMatter, oldBody, _ := md.SplitFrontMatter(existing)
+	mapping, err := parseFrontMatterNode(oldFrontMatter)
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	update, err := readFrontMatterUpdate(fields, frontMatter)
+	if err != nil {
+		return nil, err
+	}
+	if update != nil {
+		mergeNode(mapping, update, mergePatch)
+	}
+
+	if body == nil {
+		text := string(oldBody)
+		body = &text
+	}
+
+	var out bytes.Buffer
+	if len(mapping.Content) > 0 {
+		out.WriteString("---\n")
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(mapping); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+	}
+	out.WriteString(*body)
+	return out.Bytes(), nil
+}
+
+// readFrontMatterUpdate decodes the JSON object of an update into a mapping node tagged by the fields,
+// an empty update yields nil
+func readFrontMatterUpdate(fields []models.Field, frontMatter json.RawMessage) (*yaml.Node, error) {
+	if len(bytes.TrimSpace(frontMatter)) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(frontMatter))
+	decoder.UseNumber()
+	update, err := readJSONNode(decoder)
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("invalid front matter: %v", err))
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "invalid front matter: trailing data after the JSON object")
+	}
+	if update.Kind != yaml.MappingNode {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "front matter must be a JSON object")
+	}
+	applyFieldTags(update, fieldsByName(fields))
+	return update, nil
+}
+
+func fieldsByName(fields []models.Field) map[string]models.Field {
+	byName := make(map[string]models.Field, len(fields))
+	for _, field := range fields {
+		if !isContentField(field) {
+			byName[field.Name] = field
+		}
+	}
+	return byName
+}
+
+// writeNodeJSON encodes a YAML node as JSON, keeping the order of mapping keys.
+// field is the definition of the top level key the node belongs to, if any.
+func writeNodeJSON(buf *bytes.Buffer, node *yaml.Node, fields map[string]models.Field, field *models.Field) error {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		if fields == nil && field != nil && field.Type == "object" {
+			fields = fieldsByName(field.Fields)
+		}
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+
+			var child *models.Field
+			if fields != nil {
+				if f, ok := fields[node.Content[i].Value]; ok {
+					child = &f
+				}
+			}
+			if err := writeNodeJSON(buf, node.Content[i+1], nil, child); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeNodeJSON(buf, item, nil, field); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		value, err := scalarValue(node, field)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(encoded)
+	default:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+// scalarValue converts a scalar to the Go value of its field type, untyped keys keep their YAML type
+// except timestamps, which are returned as written
+func scalarValue(node *yaml.Node, field *models.Field) (interface{}, error) {
+	if node.Tag == "!!null" {
+		return nil, nil
+	}
+	if field != nil && (isTextField(*field) || field.Type == "date" || field.Type == "datetime") {
+		return node.Value, nil
+	}
+	if node.Tag == "!!timestamp" {
+		return node.Value, nil
+	}
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// readJSONNode reads the next JSON value from the decoder as a YAML node, object keys keep their order
+func readJSONNode(decoder *json.Decoder) (*yaml.Node, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		switch value {
+		case '{':
+			node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, ok := keyToken.(string)
+				if !ok {
+					return nil, errors.New("object keys must be strings")
+				}
+				child, err := readJSONNode(decoder)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+			}
+			_, err := decoder.Token()
+			return node, err
+		case '[':
+			node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for decoder.More() {
+				child, err := readJSONNode(decoder)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, child)
+			}
+			_, err := decoder.Token()
+			return node, err
+		}
+		return nil, fmt.Errorf("unexpected %v", value)
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(value.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprintf("%t", value)}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected token %v", token)
+}
+
+// applyFieldTags marks the string values of date and datetime fields as timestamps so that they are written
+// unquoted, the values of object fields are handled with their subfields
+func applyFieldTags(mapping *yaml.Node, fields map[string]models.Field) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		field, ok := fields[mapping.Content[i].Value]
+		if !ok {
+			continue
+		}
+		values := []*yaml.Node{mapping.Content[i+1]}
+		if values[0].Kind == yaml.SequenceNode {
+			values = values[0].Content
+		}
+		for _, value := range values {
+			switch {
+			case field.Type == "object" && value.Kind == yaml.MappingNode:
+				applyFieldTags(value, fieldsByName(field.Fields))
+			case (field.Type == "date" || field.Type == "datetime") && value.Kind == yaml.ScalarNode && value.Tag == "!!str":
+				var resolved yaml.Node
+				if yaml.Unmarshal([]byte(value.Value), &resolved) == nil && len(resolved.Content) == 1 &&
+					resolved.Content[0].Tag == "!!timestamp" && resolved.Content[0].Value == value.Value {
+					value.Tag = "!!timestamp"
+				}
+			}
+		}
+	}
+}
+
+// mergeNode applies update onto the mapping dst. As a merge patch, null deletes a key and nested
+// objects are merged; otherwise keys missing from update are removed. Existing keys keep their position
+// and comments, new keys are appended.
+func mergeNode(dst *yaml.Node, update *yaml.Node, mergePatch bool) {
+	seen := make(map[string]bool, len(update.Content)/2)
+	for i := 0; i+1 < len(update.Content); i += 2 {
+		key, value := update.Content[i].Value, update.Content[i+1]
+		seen[key] = true
+
+		index := -1
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key {
+				index = j
+				break
+			}
+		}
+
+		if mergePatch && value.Tag == "!!null" {
+			if index >= 0 {
+				dst.Content = append(dst.Content[:index], dst.Content[index+2:]...)
+			}
+			continue
+		}
+		if index >= 0 && dst.Content[index+1].Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			mergeNode(dst.Content[index+1], value, mergePatch)
+			continue
+		}
+		if mergePatch {
+			stripNulls(value)
+		}
+		if index < 0 {
+			dst.Content = append(dst.Content, update.Content[i], value)
+			continue
+		}
+
+		old := dst.Content[index+1]
+		value.HeadComment, value.LineComment, value.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		dst.Content[index+1] = value
+	}
+
+	if mergePatch {
+		return
+	}
+	kept := dst.Content[:0]
+	for j := 0; j+1 < len(dst.Content); j += 2 {
+		if seen[dst.Content[j].Value] {
+			kept = append(kept, dst.Content[j], dst.Content[j+1])
+		}
+	}
+	dst.Content = kept
+}
+
+// stripNulls removes null members from a patch value that is inserted as a whole, as RFC 7386 requires
+func stripNulls(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	kept := node.Content[:0]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i+1].Tag == "!!null" {
+			continue
+		}
+		stripNulls(node.Content[i+1])
+		kept = append(kept, node.Content[i], node.Content[i+1])
+	}
+	node.Content = kept
+}
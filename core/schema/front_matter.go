@@ -0,0 +1,436 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhaojunlucky/mkdocs-cms/core/md"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is a problem with a single front matter field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FrontMatterErrors lists every invalid field of a document, it is reported with status 422
+type FrontMatterErrors []FieldError
+
+func (e FrontMatterErrors) Error() string {
+	return strings.Join(e.Messages(), "; ")
+}
+
+func (e FrontMatterErrors) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+func (e FrontMatterErrors) Messages() []string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		if fieldErr.Field == "" {
+			messages = append(messages, fieldErr.Message)
+		} else {
+			messages = append(messages, fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message))
+		}
+	}
+	return messages
+}
+
+// dateLayouts are accepted for date fields without an explicit format
+var dateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+}
+
+// dateFormatTokens translates the display formats used in veda/config.yml into Go layouts
+var dateFormatTokens = strings.NewReplacer(
+	"yyyy", "2006",
+	"MM", "01",
+	"dd", "02",
+	"HH", "15",
+	"mm", "04",
+	"ss", "05",
+)
+
+// isContentField reports whether a field describes the markdown body rather than a front matter key
+func isContentField(field models.Field) bool {
+	return field.Type == "markdown" || field.Name == "body"
+}
+
+// FrontMatterOptions controls ValidateFrontMatter
+type FrontMatterOptions struct {
+	// ApplyDefaults fills missing optional fields from their default
+	ApplyDefaults bool
+	// ReferenceExists reports whether value is an entry of the collection, references are not checked when nil
+	ReferenceExists func(collection string, value string) bool
+}
+
+// ValidateFrontMatter checks the front matter of a markdown document against the fields of its collection.
+// Boolean and number strings are coerced, and datetimes without an offset get the field time zone.
+// The returned content differs from the input only when the front matter had to be changed, key
+// order and comments of the other keys are kept.
+func ValidateFrontMatter(fields []models.Field, content []byte, options FrontMatterOptions) ([]byte, error) {
+	var checked []models.Field
+	for _, field := range fields {
+		if !isContentField(field) {
+			checked = append(checked, field)
+		}
+	}
+	if len(checked) == 0 {
+		return content, nil
+	}
+
+	frontMatter, body, hasFrontMatter := md.SplitFrontMatter(content)
+	mapping, err := parseFrontMatterNode(frontMatter)
+	if err != nil {
+		return nil, FrontMatterErrors{{Message: err.Error()}}
+	}
+
+	errs, changed := validateMapping(checked, mapping, "", options)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if !changed {
+		return content, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(mapping); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("---\n")
+	out.Write(buf.Bytes())
+	out.WriteString("---\n")
+	if hasFrontMatter {
+		out.Write(body)
+	} else {
+		out.Write(content)
+	}
+	return out.Bytes(), nil
+}
+
+// parseFrontMatterNode decodes front matter into a mapping node, empty front matter yields an empty mapping
+func parseFrontMatterNode(frontMatter []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(frontMatter, &doc); err != nil {
+		return nil, fmt.Errorf("invalid front matter: %v", err)
+	}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	mapping := doc.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("front matter must be a mapping of field names to values")
+	}
+	return mapping, nil
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func isEmptyNode(node *yaml.Node) bool {
+	if node == nil {
+		return true
+	}
+	if node.Kind == yaml.ScalarNode {
+		return node.Tag == "!!null" || (node.Tag == "!!str" && strings.TrimSpace(node.Value) == "")
+	}
+	return node.Kind == yaml.SequenceNode && len(node.Content) == 0
+}
+
+// validateMapping checks the keys of a front matter mapping or of an object field value against fields
+func validateMapping(fields []models.Field, mapping *yaml.Node, prefix string, options FrontMatterOptions) (errs FrontMatterErrors, changed bool) {
+	for _, field := range fields {
+		name := prefix + field.Name
+		value := mappingValue(mapping, field.Name)
+		if isEmptyNode(value) {
+			if options.ApplyDefaults && field.Default != "" {
+				defaultNode, err := defaultValueNode(field)
+				if err != nil {
+					errs = append(errs, FieldError{Field: name, Message: err.Error()})
+					continue
+				}
+				if value == nil {
+					mapping.Content = append(mapping.Content,
+						&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.Name}, defaultNode)
+				} else {
+					*value = *defaultNode
+				}
+				changed = true
+				continue
+			}
+			if field.Required {
+				errs = append(errs, FieldError{Field: name, Message: "is required"})
+			}
+			continue
+		}
+
+		fieldErrs, coerced := validateFieldValue(field, value, name, options)
+		errs = append(errs, fieldErrs...)
+		changed = changed || coerced
+	}
+	return errs, changed
+}
+
+// defaultValueNode builds the node of a field default, list defaults may be written as a YAML flow sequence
+func defaultValueNode(field models.Field) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(field.Default), &doc); err != nil || len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.Default}}
+	}
+	node := doc.Content[0]
+	if node.Kind == yaml.ScalarNode && isTextField(field) {
+		node = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.Default}
+	}
+	if field.List && node.Kind != yaml.SequenceNode {
+		node = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{node}}
+	}
+	node.Line, node.Column = 0, 0
+	if errs, _ := validateFieldValue(field, node, field.Name, FrontMatterOptions{}); len(errs) > 0 {
+		return nil, fmt.Errorf("default value is invalid: %s", errs[0].Message)
+	}
+	return node, nil
+}
+
+// isTextField reports whether the values of a field are always strings
+func isTextField(field models.Field) bool {
+	switch field.Type {
+	case "boolean", "number", "date", "datetime", "object":
+		return false
+	}
+	return true
+}
+
+// validateFieldValue checks a present value, coerced reports whether the node was rewritten
+func validateFieldValue(field models.Field, value *yaml.Node, name string, options FrontMatterOptions) (errs FrontMatterErrors, coerced bool) {
+	if value.Kind == yaml.AliasNode && value.Alias != nil {
+		value = value.Alias
+	}
+	if !field.List {
+		if value.Kind == yaml.SequenceNode {
+			return FrontMatterErrors{{Field: name, Message: "must be a single value, not a list"}}, false
+		}
+		return validateItem(field, value, name, options)
+	}
+
+	if value.Kind != yaml.SequenceNode {
+		return FrontMatterErrors{{Field: name, Message: "must be a list"}}, false
+	}
+	for i, item := range value.Content {
+		itemErrs, itemCoerced := validateItem(field, item, fmt.Sprintf("%s[%d]", name, i), options)
+		errs = append(errs, itemErrs...)
+		coerced = coerced || itemCoerced
+	}
+	return errs, coerced
+}
+
+// validateItem checks a single value, that is the value of a scalar field or one element of a list field
+func validateItem(field models.Field, node *yaml.Node, name string, options FrontMatterOptions) (FrontMatterErrors, bool) {
+	if field.Type == "object" {
+		if node.Kind != yaml.MappingNode {
+			return FrontMatterErrors{{Field: name, Message: "must be an object"}}, false
+		}
+		return validateMapping(field.Fields, node, name+".", options)
+	}
+	if node.Kind != yaml.ScalarNode {
+		return FrontMatterErrors{{Field: name, Message: "must be a single value"}}, false
+	}
+	msg, coerced := validateScalar(field, node, options)
+	if msg != "" {
+		return FrontMatterErrors{{Field: name, Message: msg}}, false
+	}
+	return nil, coerced
+}
+
+// ImageExtensions are the file types accepted by image fields
+var ImageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".ico"}
+
+// datetimeLayouts are accepted for datetime values without an offset
+var datetimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// validateScalar returns an error message for an invalid value, or whether it was coerced in place
+func validateScalar(field models.Field, node *yaml.Node, options FrontMatterOptions) (string, bool) {
+	switch field.Type {
+	case "boolean":
+		if node.Tag == "!!bool" {
+			return "", false
+		}
+		switch strings.ToLower(strings.TrimSpace(node.Value)) {
+		case "true", "yes", "on":
+			setBool(node, true)
+			return "", true
+		case "false", "no", "off":
+			setBool(node, false)
+			return "", true
+		}
+		return "must be true or false", false
+	case "date":
+		if node.Tag == "!!timestamp" {
+			return "", false
+		}
+		if field.Format != "" {
+			layout := dateFormatTokens.Replace(field.Format)
+			if _, err := time.Parse(layout, node.Value); err == nil {
+				return "", false
+			}
+		}
+		for _, layout := range dateLayouts {
+			if _, err := time.Parse(layout, node.Value); err == nil {
+				return "", false
+			}
+		}
+		if field.Format != "" {
+			return fmt.Sprintf("must be a date in the format %s", field.Format), false
+		}
+		return "must be a date such as 2006-01-02 or 2006-01-02T15:04:05Z07:00", false
+	case "datetime":
+		return validateDatetime(field, node)
+	case "number":
+		return validateNumber(field, node)
+	case "select":
+		for _, option := range field.Options {
+			if node.Value == option {
+				return "", false
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(field.Options, ", ")), false
+	case "reference":
+		if strings.TrimSpace(node.Value) == "" {
+			return "must name an entry", false
+		}
+		if options.ReferenceExists != nil && !options.ReferenceExists(field.Collection, node.Value) {
+			return fmt.Sprintf("no entry %q in collection %s", node.Value, field.Collection), false
+		}
+	case "image":
+		lower := strings.ToLower(node.Value)
+		if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "data:image/") {
+			return "", false
+		}
+		for _, ext := range ImageExtensions {
+			if path.Ext(lower) == ext {
+				return "", false
+			}
+		}
+		return fmt.Sprintf("must be an image URL or a path ending in one of %s", strings.Join(ImageExtensions, ", ")), false
+	}
+	return "", false
+}
+
+// validateDatetime accepts RFC 3339 values, values without an offset are moved into the field time zone
+func validateDatetime(field models.Field, node *yaml.Node) (string, bool) {
+	value := strings.TrimSpace(node.Value)
+	if _, err := time.Parse(time.RFC3339, value); err == nil {
+		return "", false
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05Z07:00", value); err == nil {
+		return "", false
+	}
+
+	location := time.UTC
+	if field.Timezone != "" {
+		loaded, err := time.LoadLocation(field.Timezone)
+		if err != nil {
+			return fmt.Sprintf("unknown time zone %q", field.Timezone), false
+		}
+		location = loaded
+	}
+	for _, layout := range datetimeLayouts {
+		t, err := time.ParseInLocation(layout, value, location)
+		if err != nil {
+			continue
+		}
+		if field.Timezone == "" {
+			return "", false
+		}
+		node.Tag = "!!timestamp"
+		node.Style = 0
+		node.Value = t.Format(time.RFC3339)
+		return "", true
+	}
+	return "must be a date and time such as 2006-01-02T15:04:05Z07:00", false
+}
+
+// ParseDatetime parses a datetime value, values written without an offset are in the named time zone
+// or in UTC when it is empty
+func ParseDatetime(value string, timezone string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05Z07:00"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	location := time.UTC
+	if timezone != "" {
+		loaded, err := time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %q", timezone)
+		}
+		location = loaded
+	}
+	for _, layout := range datetimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date and time such as 2006-01-02T15:04:05Z07:00", value)
+}
+
+// validateNumber checks the bounds of a number, numeric strings are coerced to numbers
+func validateNumber(field models.Field, node *yaml.Node) (string, bool) {
+	number, err := strconv.ParseFloat(strings.TrimSpace(node.Value), 64)
+	if err != nil || (node.Tag != "!!int" && node.Tag != "!!float" && node.Tag != "!!str") {
+		return "must be a number", false
+	}
+	if field.Min != nil && number < *field.Min {
+		return fmt.Sprintf("must be at least %s", strconv.FormatFloat(*field.Min, 'f', -1, 64)), false
+	}
+	if field.Max != nil && number > *field.Max {
+		return fmt.Sprintf("must be at most %s", strconv.FormatFloat(*field.Max, 'f', -1, 64)), false
+	}
+	if node.Tag != "!!str" {
+		return "", false
+	}
+	node.Value = strings.TrimSpace(node.Value)
+	node.Style = 0
+	node.Tag = "!!float"
+	if _, err := strconv.ParseInt(node.Value, 10, 64); err == nil {
+		node.Tag = "!!int"
+	}
+	return "", true
+}
+
+func setBool(node *yaml.Node, value bool) {
+	node.Tag = "!!bool"
+	node.Style = 0
+	node.Value = fmt.Sprintf("%t", value)
+}
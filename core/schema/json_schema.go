@@ -0,0 +1,207 @@
+package schema
+
+// VedaConfigJSONSchemaID identifies the published schema of veda/config.yml
+const VedaConfigJSONSchemaID = "https://github.com/zhaojunlucky/mkdocs-cms/schema/veda-config.json"
+
+func stringSchema(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description}
+}
+
+func enumSchema(description string, values []string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description, "enum": values}
+}
+
+// requiredForType makes keys required when a field has the given type
+func requiredForType(fieldType string, keys ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"if":   map[string]interface{}{"properties": map[string]interface{}{"type": map[string]interface{}{"const": fieldType}}},
+		"then": map[string]interface{}{"required": keys},
+	}
+}
+
+// VedaConfigJSONSchema returns the JSON Schema of veda/config.yml for editor tooling,
+// it is built from the same enums as ValidateVedaConfig so the two cannot drift apart
+func VedaConfigJSONSchema() map[string]interface{} {
+	field := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"type", "name", "label"},
+		"properties": map[string]interface{}{
+			"type":     enumSchema("Editor widget of the field", FieldTypes),
+			"name":     stringSchema("Front matter key, unique within the collection"),
+			"label":    stringSchema("Label shown in the editor"),
+			"required": map[string]interface{}{"type": "boolean", "default": false},
+			"format":   stringSchema("Display format of the value"),
+			"list":     map[string]interface{}{"type": "boolean", "default": false, "description": "The value is a list"},
+			"default": map[string]interface{}{
+				"type":        []string{"string", "number", "boolean"},
+				"description": "Value used for new entries",
+			},
+			"options": map[string]interface{}{
+				"type":        "array",
+				"minItems":    1,
+				"uniqueItems": true,
+				"items":       map[string]interface{}{"type": []string{"string", "number", "boolean"}},
+				"description": "Allowed values of a select field",
+			},
+			"collection": stringSchema("Collection whose entries a reference field points to"),
+			"path":       stringSchema("Directory relative to the repository root that holds the images of an image field"),
+			"min":        map[string]interface{}{"type": "number", "description": "Smallest value of a number field"},
+			"max":        map[string]interface{}{"type": "number", "description": "Largest value of a number field"},
+			"timezone":   stringSchema("IANA time zone applied to datetime values without an offset"),
+			"fields": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"$ref": "#/$defs/field"},
+				"description": "Subfields of an object field",
+			},
+		},
+		"allOf": []interface{}{
+			requiredForType("select", "options"),
+			requiredForType("reference", "collection"),
+			requiredForType("object", "fields"),
+		},
+	}
+
+	fileNameGenerator := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"type"},
+		"properties": map[string]interface{}{
+			"type":  enumSchema("How names of new files are generated", FileNameGeneratorTypes),
+			"first": stringSchema("Name of the first file of a sequence"),
+		},
+	}
+
+	schedule := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"mode":     enumSchema("How publish_at and unpublish_at of the entries are carried out", ScheduleModes),
+			"drafts":   stringSchema("Folder, relative to the collection path, holding unpublished entries in the folder mode"),
+			"timezone": stringSchema("Time zone of publish_at and unpublish_at values written without an offset"),
+		},
+		"if":   map[string]interface{}{"required": []string{"mode"}, "properties": map[string]interface{}{"mode": map[string]interface{}{"const": "folder"}}},
+		"then": map[string]interface{}{"required": []string{"drafts"}},
+	}
+
+	stateName := map[string]interface{}{"type": "string", "pattern": namePattern}
+	workflow := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"states"},
+		"description":          "Editorial states the entries go through before they are published",
+		"properties": map[string]interface{}{
+			"states": map[string]interface{}{
+				"type":        "array",
+				"items":       stateName,
+				"minItems":    1,
+				"uniqueItems": true,
+				"description": "States in order, the first is the state of new entries",
+			},
+			"publish": stringSchema("State in which entries are published, the last state by default"),
+			"required_approvals": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Approvals of assigned reviewers needed before an entry is published",
+			},
+			"transitions": map[string]interface{}{
+				"type":        "array",
+				"description": "Allowed state changes, without them an entry moves to the next state or back to any earlier one",
+				"items": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": false,
+					"required":             []string{"from", "to"},
+					"properties": map[string]interface{}{
+						"from": stringSchema("State the change starts from, '*' for every state"),
+						"to":   stateName,
+						"roles": map[string]interface{}{
+							"type":        "array",
+							"items":       map[string]interface{}{"type": "string"},
+							"description": "Who may make the change: owner, reviewer or a user role. Only the owner may when empty",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	collection := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"name", "label", "path", "format"},
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Unique identifier of the collection",
+				"pattern":     namePattern,
+			},
+			"label": stringSchema("Name shown in the editor"),
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Directory relative to the repository root",
+				"minLength":   1,
+				"not":         map[string]interface{}{"pattern": `^(/|\.\.(/|$)|\.git(/|$))`},
+			},
+			"format":              enumSchema("Format of the entries", ContentFormats),
+			"file_name_generator": fileNameGenerator,
+			"exclude": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Gitignore style patterns, relative to the collection path, of files hidden from the editor",
+			},
+			"entries":  enumSchema("Whether each file or each list item of a yaml or json file is an entry", EntryLayouts),
+			"root":     stringSchema("Dotted key of the list holding the entries of a list collection"),
+			"schedule": schedule,
+			"workflow": workflow,
+			"draft_key": map[string]interface{}{
+				"type":        "string",
+				"description": "Front matter key marking an entry as a draft, dots address nested objects",
+				"default":     "draft",
+				"pattern":     `^[^.]+(\.[^.]+)*$`,
+			},
+			"fields": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"$ref": "#/$defs/field"},
+			},
+		},
+	}
+
+	codeBlockTransform := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"from_lang", "to_lang"},
+		"properties": map[string]interface{}{
+			"from_lang": map[string]interface{}{"type": "string", "minLength": 1},
+			"to_lang":   map[string]interface{}{"type": "string", "minLength": 1},
+			"direction": enumSchema("When the transform is applied", TransformDirections),
+			"enabled":   map[string]interface{}{"type": "boolean", "default": true},
+		},
+	}
+
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  VedaConfigJSONSchemaID,
+		"title":                "veda/config.yml",
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"collections"},
+		"$defs":                map[string]interface{}{"field": field},
+		"properties": map[string]interface{}{
+			"collections": map[string]interface{}{
+				"type":     "array",
+				"minItems": 1,
+				"items":    collection,
+			},
+			"md_config": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					"code_block_transforms": map[string]interface{}{
+						"type":  "array",
+						"items": codeBlockTransform,
+					},
+				},
+			},
+		},
+	}
+}
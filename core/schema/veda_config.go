@@ -0,0 +1,648 @@
+package schema
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhaojunlucky/mkdocs-cms/core/filename"
+	"gopkg.in/yaml.v3"
+)
+
+// Severity tells whether a validation finding blocks the config from being used
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+var (
+	// FieldTypes are the field types the editor can render
+	FieldTypes = []string{"string", "date", "boolean", "markdown", "select", "reference", "image", "number", "datetime", "object"}
+	// ContentFormats are the collection formats the editor can open
+	ContentFormats = []string{"md", "yaml", "json"}
+	// EntryLayouts are the values of the entries key of a collection
+	EntryLayouts = []string{"file", "list"}
+	// FileNameGeneratorTypes are the supported file name generators
+	FileNameGeneratorTypes = filename.Types
+	// ScheduleModes are how scheduled publications of a collection are carried out
+	ScheduleModes = []string{"draft", "folder"}
+	// WorkflowAnyState is the from of a workflow transition allowed from every state
+	WorkflowAnyState = "*"
+	// TransformDirections are the directions of a code block transform
+	TransformDirections = []string{"read", "write", "both"}
+
+	// fieldTypeOptions are the field keys that only apply to some field types
+	fieldTypeOptions = map[string][]string{
+		"options":    {"select"},
+		"collection": {"reference"},
+		"path":       {"image"},
+		"min":        {"number"},
+		"max":        {"number"},
+		"timezone":   {"datetime"},
+		"fields":     {"object"},
+	}
+
+	namePattern = `^[A-Za-z0-9_-]+$`
+	nameRegex   = regexp.MustCompile(namePattern)
+)
+
+// ValidationError is a finding located at a YAML line and column
+type ValidationError struct {
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Path     string   `json:"path"`
+	Message  string   `json:"message"`
+	Severity Severity `json:"severity"`
+}
+
+func (e ValidationError) String() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+// ValidationErrors is the result of validating a config, it is an error when it holds at least one error
+type ValidationErrors []ValidationError
+
+// HasErrors returns true when a finding has error severity
+func (v ValidationErrors) HasErrors() bool {
+	for _, e := range v {
+		if e.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Messages returns the findings of the given severity as strings
+func (v ValidationErrors) Messages(severity Severity) []string {
+	var messages []string
+	for _, e := range v {
+		if e.Severity == severity {
+			messages = append(messages, e.String())
+		}
+	}
+	return messages
+}
+
+func (v ValidationErrors) Error() string {
+	return "invalid veda/config.yml: " + strings.Join(v.Messages(SeverityError), "; ")
+}
+
+type validator struct {
+	findings   ValidationErrors
+	pathExists func(string) bool
+	// collectionNames and references are compared once every collection has been read
+	collectionNames map[string]bool
+	references      []fieldReference
+}
+
+// fieldReference is a value naming something defined elsewhere in the config, the collection option of a
+// reference field or a state of a workflow
+type fieldReference struct {
+	node *yaml.Node
+	path string
+}
+
+func (v *validator) add(node *yaml.Node, path string, severity Severity, format string, args ...interface{}) {
+	finding := ValidationError{Path: path, Message: fmt.Sprintf(format, args...), Severity: severity}
+	if node != nil {
+		finding.Line = node.Line
+		finding.Column = node.Column
+	}
+	v.findings = append(v.findings, finding)
+}
+
+func (v *validator) errorf(node *yaml.Node, path string, format string, args ...interface{}) {
+	v.add(node, path, SeverityError, format, args...)
+}
+
+// ValidateVedaConfig validates the content of veda/config.yml and reports every finding with its position
+func ValidateVedaConfig(data []byte) ValidationErrors {
+	return ValidateVedaConfigWith(data, nil)
+}
+
+// ValidateVedaConfigWith validates like ValidateVedaConfig and warns about collection paths
+// for which pathExists returns false
+func ValidateVedaConfigWith(data []byte, pathExists func(string) bool) ValidationErrors {
+	v := &validator{pathExists: pathExists, collectionNames: map[string]bool{}}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		finding := ValidationError{Line: 1, Column: 1, Message: err.Error(), Severity: SeverityError}
+		// yaml.v3 reports "yaml: line N: ..." for syntax errors
+		var line int
+		if _, scanErr := fmt.Sscanf(err.Error(), "yaml: line %d:", &line); scanErr == nil {
+			finding.Line = line
+		}
+		return ValidationErrors{finding}
+	}
+	if len(doc.Content) == 0 {
+		v.errorf(&doc, "", "veda/config.yml is empty")
+		return v.findings
+	}
+
+	root := doc.Content[0]
+	if !v.expectKind(root, "", yaml.MappingNode, "a mapping") {
+		return v.findings
+	}
+
+	var collections *yaml.Node
+	v.eachKey(root, "", []string{"collections", "md_config"}, func(key *yaml.Node, value *yaml.Node, keyPath string) {
+		switch key.Value {
+		case "collections":
+			collections = value
+			v.validateCollections(value, keyPath)
+		case "md_config":
+			v.validateMDConfig(value, keyPath)
+		}
+	})
+	if collections == nil {
+		v.errorf(root, "", "missing required 'collections' field")
+	}
+	for _, reference := range v.references {
+		if collections != nil && !v.collectionNames[reference.node.Value] {
+			v.errorf(reference.node, reference.path, "reference to unknown collection %q", reference.node.Value)
+		}
+	}
+	return v.findings
+}
+
+func (v *validator) validateCollections(node *yaml.Node, p string) {
+	if !v.expectKind(node, p, yaml.SequenceNode, "a list") {
+		return
+	}
+	if len(node.Content) == 0 {
+		v.errorf(node, p, "must contain at least one collection")
+	}
+	names := map[string]*yaml.Node{}
+	for i, item := range node.Content {
+		itemPath := fmt.Sprintf("%s[%d]", p, i)
+		if !v.expectKind(item, itemPath, yaml.MappingNode, "a mapping") {
+			continue
+		}
+		var format, entries, root, schedule, workflow *yaml.Node
+		keys := v.eachKey(item, itemPath, []string{"name", "label", "path", "format", "file_name_generator", "fields", "exclude", "entries", "root", "schedule", "draft_key", "workflow"},
+			func(key *yaml.Node, value *yaml.Node, keyPath string) {
+				switch key.Value {
+				case "name":
+					if v.expectString(value, keyPath) {
+						if !nameRegex.MatchString(value.Value) {
+							v.errorf(value, keyPath, "collection name %q may only contain letters, digits, '-' and '_'", value.Value)
+						} else if first, ok := names[value.Value]; ok {
+							v.errorf(value, keyPath, "duplicate collection name %q, first defined at line %d", value.Value, first.Line)
+						} else {
+							names[value.Value] = value
+							v.collectionNames[value.Value] = true
+						}
+					}
+				case "label":
+					v.expectString(value, keyPath)
+				case "path":
+					if v.expectString(value, keyPath) {
+						v.validatePath(value, keyPath)
+					}
+				case "format":
+					if v.expectString(value, keyPath) && v.expectEnum(value, keyPath, ContentFormats) {
+						format = value
+					}
+				case "file_name_generator":
+					v.validateFileNameGenerator(value, keyPath)
+				case "fields":
+					v.validateFields(value, keyPath)
+				case "exclude":
+					if v.expectKind(value, keyPath, yaml.SequenceNode, "a list") {
+						for i, pattern := range value.Content {
+							v.expectString(pattern, fmt.Sprintf("%s[%d]", keyPath, i))
+						}
+					}
+				case "entries":
+					if v.expectString(value, keyPath) && v.expectEnum(value, keyPath, EntryLayouts) {
+						entries = value
+					}
+				case "root":
+					if v.expectString(value, keyPath) {
+						root = value
+					}
+				case "schedule":
+					schedule = value
+					v.validateSchedule(value, keyPath)
+				case "draft_key":
+					if v.expectString(value, keyPath) && slices.Contains(strings.Split(value.Value, "."), "") {
+						v.errorf(value, keyPath, "draft key %q must be a front matter key, dots separate the keys of nested objects", value.Value)
+					}
+				case "workflow":
+					workflow = value
+					v.validateWorkflow(value, keyPath)
+				}
+			})
+		v.requireKeys(item, itemPath, keys, "name", "label", "path", "format")
+		isList := entries != nil && entries.Value == "list"
+		if isList && format != nil && format.Value == "md" {
+			v.errorf(entries, itemPath+".entries", "entries of a markdown collection are files, 'list' requires the yaml or json format")
+		}
+		if root != nil && !isList {
+			v.add(root, itemPath+".root", SeverityWarning, "'root' is only used when entries is 'list'")
+		}
+		if schedule != nil && isList {
+			v.errorf(schedule, itemPath+".schedule", "entries of a list collection cannot be scheduled, 'schedule' requires entries to be files")
+		}
+		if workflow != nil && isList {
+			v.errorf(workflow, itemPath+".workflow", "entries of a list collection share a file, 'workflow' requires entries to be files")
+		}
+	}
+}
+
+func (v *validator) validatePath(node *yaml.Node, p string) {
+	value := node.Value
+	if value == "" {
+		v.errorf(node, p, "path must not be empty")
+		return
+	}
+	if strings.HasPrefix(value, "/") || strings.Contains(value, "\\") || (len(value) > 1 && value[1] == ':') {
+		v.errorf(node, p, "path %q must be relative to the repository root and use '/' separators", value)
+		return
+	}
+	clean := path.Clean(value)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		v.errorf(node, p, "path %q must not leave the repository", value)
+		return
+	}
+	if clean == ".git" || strings.HasPrefix(clean, ".git/") {
+		v.errorf(node, p, "path %q must not point into the .git directory", value)
+		return
+	}
+	if v.pathExists != nil && !v.pathExists(clean) {
+		v.add(node, p, SeverityWarning, "path %q does not exist in the repository", value)
+	}
+}
+
+func (v *validator) validateFileNameGenerator(node *yaml.Node, p string) {
+	if !v.expectKind(node, p, yaml.MappingNode, "a mapping") {
+		return
+	}
+	var generatorType, first *yaml.Node
+	keys := v.eachKey(node, p, []string{"type", "first"}, func(key *yaml.Node, value *yaml.Node, keyPath string) {
+		switch key.Value {
+		case "type":
+			if v.expectString(value, keyPath) && v.expectEnum(value, keyPath, FileNameGeneratorTypes) {
+				generatorType = value
+			}
+		case "first":
+			if v.expectString(value, keyPath) {
+				first = value
+			}
+		}
+	})
+	v.requireKeys(node, p, keys, "type")
+	if first != nil && generatorType != nil && generatorType.Value != filename.TypeSequence {
+		v.add(first, p+".first", SeverityWarning, "'first' is only used by the sequence generator")
+	}
+}
+
+func (v *validator) validateSchedule(node *yaml.Node, p string) {
+	if !v.expectKind(node, p, yaml.MappingNode, "a mapping") {
+		return
+	}
+	var mode, drafts *yaml.Node
+	v.eachKey(node, p, []string{"mode", "drafts", "timezone"}, func(key *yaml.Node, value *yaml.Node, keyPath string) {
+		switch key.Value {
+		case "mode":
+			if v.expectString(value, keyPath) && v.expectEnum(value, keyPath, ScheduleModes) {
+				mode = value
+			}
+		case "drafts":
+			if v.expectString(value, keyPath) {
+				drafts = value
+				clean := path.Clean(value.Value)
+				if value.Value == "" || clean == "." {
+					v.errorf(value, keyPath, "drafts folder must not be empty")
+				} else if strings.HasPrefix(value.Value, "/") || strings.Contains(value.Value, "\\") || clean == ".." || strings.HasPrefix(clean, "../") {
+					v.errorf(value, keyPath, "drafts folder %q must be relative to the collection path and stay inside it", value.Value)
+				}
+			}
+		case "timezone":
+			if v.expectString(value, keyPath) {
+				if _, err := time.LoadLocation(value.Value); err != nil {
+					v.errorf(value, keyPath, "unknown time zone %q", value.Value)
+				}
+			}
+		}
+	})
+	isFolder := mode != nil && mode.Value == "folder"
+	if isFolder && drafts == nil {
+		v.errorf(mode, p+".mode", "the folder mode requires a 'drafts' folder")
+	}
+	if drafts != nil && !isFolder {
+		v.add(drafts, p+".drafts", SeverityWarning, "'drafts' is only used when mode is 'folder'")
+	}
+}
+
+func (v *validator) validateWorkflow(node *yaml.Node, p string) {
+	if !v.expectKind(node, p, yaml.MappingNode, "a mapping") {
+		return
+	}
+	states := map[string]*yaml.Node{}
+	var stateReferences []fieldReference
+	keys := v.eachKey(node, p, []string{"states", "publish", "required_approvals", "transitions"}, func(key *yaml.Node, value *yaml.Node, keyPath string) {
+		switch key.Value {
+		case "states":
+			if !v.expectKind(value, keyPath, yaml.SequenceNode, "a list") {
+				return
+			}
+			if len(value.Content) == 0 {
+				v.errorf(value, keyPath, "must contain at least one state")
+			}
+			for i, state := range value.Content {
+				statePath := fmt.Sprintf("%s[%d]", keyPath, i)
+				if !v.expectString(state, statePath) {
+					continue
+				}
+				if !nameRegex.MatchString(state.Value) {
+					v.errorf(state, statePath, "state %q may only contain letters, digits, '-' and '_'", state.Value)
+				} else if first, ok := states[state.Value]; ok {
+					v.errorf(state, statePath, "duplicate state %q, first defined at line %d", state.Value, first.Line)
+				} else {
+					states[state.Value] = state
+				}
+			}
+		case "publish":
+			if v.expectString(value, keyPath) {
+				stateReferences = append(stateReferences, fieldReference{node: value, path: keyPath})
+			}
+		case "required_approvals":
+			if n, err := strconv.Atoi(value.Value); value.Kind != yaml.ScalarNode || value.Tag != "!!int" || err != nil || n < 0 {
+				v.errorf(value, keyPath, "must be a whole number, 0 or more")
+			}
+		case "transitions":
+			stateReferences = append(stateReferences, v.validateTransitions(value, keyPath)...)
+		}
+	})
+	v.requireKeys(node, p, keys, "states")
+	if len(states) == 0 {
+		return
+	}
+	for _, reference := range stateReferences {
+		if states[reference.node.Value] == nil {
+			v.errorf(reference.node, reference.path, "unknown state %q", reference.node.Value)
+		}
+	}
+}
+
+// validateTransitions returns the states named by workflow transitions, they are checked once the states are known
+func (v *validator) validateTransitions(node *yaml.Node, p string) []fieldReference {
+	if !v.expectKind(node, p, yaml.SequenceNode, "a list") {
+		return nil
+	}
+	var references []fieldReference
+	for i, item := range node.Content {
+		itemPath := fmt.Sprintf("%s[%d]", p, i)
+		if !v.expectKind(item, itemPath, yaml.MappingNode, "a mapping") {
+			continue
+		}
+		keys := v.eachKey(item, itemPath, []string{"from", "to", "roles"}, func(key *yaml.Node, value *yaml.Node, keyPath string) {
+			switch key.Value {
+			case "from", "to":
+				if v.expectString(value, keyPath) && !(key.Value == "from" && value.Value == WorkflowAnyState) {
+					references = append(references, fieldReference{node: value, path: keyPath})
+				}
+			case "roles":
+				if v.expectKind(value, keyPath, yaml.SequenceNode, "a list") {
+					for j, role := range value.Content {
+						v.expectString(role, fmt.Sprintf("%s[%d]", keyPath, j))
+					}
+				}
+			}
+		})
+		v.requireKeys(item, itemPath, keys, "from", "to")
+	}
+	return references
+}
+
+func (v *validator) validateFields(node *yaml.Node, p string) {
+	if !v.expectKind(node, p, yaml.SequenceNode, "a list") {
+		return
+	}
+	names := map[string]*yaml.Node{}
+	for i, item := range node.Content {
+		itemPath := fmt.Sprintf("%s[%d]", p, i)
+		if !v.expectKind(item, itemPath, yaml.MappingNode, "a mapping") {
+			continue
+		}
+		var fieldType string
+		var min, max *yaml.Node
+		options := map[string]*yaml.Node{}
+		keys := v.eachKey(item, itemPath, []string{"type", "name", "label", "required", "format", "list", "default",
+			"options", "collection", "path", "min", "max", "timezone", "fields"},
+			func(key *yaml.Node, value *yaml.Node, keyPath string) {
+				switch key.Value {
+				case "type":
+					if v.expectString(value, keyPath) && v.expectEnum(value, keyPath, FieldTypes) {
+						fieldType = value.Value
+					}
+				case "name":
+					if v.expectString(value, keyPath) {
+						if first, ok := names[value.Value]; ok {
+							v.errorf(value, keyPath, "duplicate field name %q, first defined at line %d", value.Value, first.Line)
+						} else {
+							names[value.Value] = value
+						}
+					}
+				case "label", "format":
+					v.expectString(value, keyPath)
+				case "required", "list":
+					v.expectBool(value, keyPath)
+				case "default":
+					v.expectKind(value, keyPath, yaml.ScalarNode, "a scalar")
+				case "options":
+					options[key.Value] = key
+					v.validateSelectOptions(value, keyPath)
+				case "collection":
+					options[key.Value] = key
+					if v.expectString(value, keyPath) {
+						v.references = append(v.references, fieldReference{node: value, path: keyPath})
+					}
+				case "path":
+					options[key.Value] = key
+					if v.expectString(value, keyPath) {
+						v.validatePath(value, keyPath)
+					}
+				case "min", "max":
+					options[key.Value] = key
+					if value.Kind != yaml.ScalarNode || (value.Tag != "!!int" && value.Tag != "!!float") {
+						v.errorf(value, keyPath, "must be a number")
+					} else if key.Value == "min" {
+						min = value
+					} else {
+						max = value
+					}
+				case "timezone":
+					options[key.Value] = key
+					if v.expectString(value, keyPath) {
+						if _, err := time.LoadLocation(value.Value); err != nil {
+							v.errorf(value, keyPath, "unknown time zone %q", value.Value)
+						}
+					}
+				case "fields":
+					options[key.Value] = key
+					v.validateFields(value, keyPath)
+				}
+			})
+		v.requireKeys(item, itemPath, keys, "type", "name", "label")
+		if fieldType == "" {
+			continue
+		}
+
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			option := item.Content[i].Value
+			key, ok := options[option]
+			if ok && !slices.Contains(fieldTypeOptions[option], fieldType) {
+				v.add(key, itemPath+"."+option, SeverityWarning, "'%s' is only used by %s fields",
+					option, strings.Join(fieldTypeOptions[option], ", "))
+			}
+		}
+		switch fieldType {
+		case "select":
+			v.requireKeys(item, itemPath, keys, "options")
+		case "reference":
+			v.requireKeys(item, itemPath, keys, "collection")
+		case "object":
+			v.requireKeys(item, itemPath, keys, "fields")
+		case "number":
+			if min != nil && max != nil {
+				minValue, _ := strconv.ParseFloat(min.Value, 64)
+				maxValue, _ := strconv.ParseFloat(max.Value, 64)
+				if minValue > maxValue {
+					v.errorf(min, itemPath+".min", "min %s is greater than max %s", min.Value, max.Value)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateSelectOptions(node *yaml.Node, p string) {
+	if !v.expectKind(node, p, yaml.SequenceNode, "a list") {
+		return
+	}
+	if len(node.Content) == 0 {
+		v.errorf(node, p, "must contain at least one option")
+	}
+	seen := map[string]*yaml.Node{}
+	for i, option := range node.Content {
+		optionPath := fmt.Sprintf("%s[%d]", p, i)
+		if !v.expectKind(option, optionPath, yaml.ScalarNode, "a scalar") {
+			continue
+		}
+		if first, ok := seen[option.Value]; ok {
+			v.errorf(option, optionPath, "duplicate option %q, first defined at line %d", option.Value, first.Line)
+		} else {
+			seen[option.Value] = option
+		}
+	}
+}
+
+func (v *validator) validateMDConfig(node *yaml.Node, p string) {
+	if !v.expectKind(node, p, yaml.MappingNode, "a mapping") {
+		return
+	}
+	v.eachKey(node, p, []string{"code_block_transforms"}, func(key *yaml.Node, value *yaml.Node, keyPath string) {
+		if !v.expectKind(value, keyPath, yaml.SequenceNode, "a list") {
+			return
+		}
+		for i, item := range value.Content {
+			itemPath := fmt.Sprintf("%s[%d]", keyPath, i)
+			if !v.expectKind(item, itemPath, yaml.MappingNode, "a mapping") {
+				continue
+			}
+			keys := v.eachKey(item, itemPath, []string{"from_lang", "to_lang", "direction", "enabled"},
+				func(key *yaml.Node, value *yaml.Node, keyPath string) {
+					switch key.Value {
+					case "from_lang", "to_lang":
+						if v.expectString(value, keyPath) && value.Value == "" {
+							v.errorf(value, keyPath, "%s must not be empty", key.Value)
+						}
+					case "direction":
+						if v.expectString(value, keyPath) {
+							v.expectEnum(value, keyPath, TransformDirections)
+						}
+					case "enabled":
+						v.expectBool(value, keyPath)
+					}
+				})
+			v.requireKeys(item, itemPath, keys, "from_lang", "to_lang")
+		}
+	})
+}
+
+// eachKey calls fn for every known key of a mapping, warns about unknown and reports duplicate keys
+func (v *validator) eachKey(node *yaml.Node, p string, known []string, fn func(key *yaml.Node, value *yaml.Node, keyPath string)) map[string]bool {
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		keyPath := key.Value
+		if p != "" {
+			keyPath = p + "." + key.Value
+		}
+		if seen[key.Value] {
+			v.errorf(key, keyPath, "duplicate key %q", key.Value)
+			continue
+		}
+		seen[key.Value] = true
+		if !slices.Contains(known, key.Value) {
+			v.add(key, keyPath, SeverityWarning, "unknown key %q, expected one of %s", key.Value, strings.Join(known, ", "))
+			continue
+		}
+		fn(key, value, keyPath)
+	}
+	return seen
+}
+
+func (v *validator) requireKeys(node *yaml.Node, p string, seen map[string]bool, keys ...string) {
+	for _, key := range keys {
+		if !seen[key] {
+			v.errorf(node, p, "missing required '%s' field", key)
+		}
+	}
+}
+
+func (v *validator) expectKind(node *yaml.Node, p string, kind yaml.Kind, description string) bool {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	if node.Kind != kind {
+		v.errorf(node, p, "must be %s", description)
+		return false
+	}
+	return true
+}
+
+func (v *validator) expectString(node *yaml.Node, p string) bool {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+		v.errorf(node, p, "must be a string")
+		return false
+	}
+	return true
+}
+
+func (v *validator) expectBool(node *yaml.Node, p string) bool {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+		v.errorf(node, p, "must be true or false")
+		return false
+	}
+	return true
+}
+
+func (v *validator) expectEnum(node *yaml.Node, p string, allowed []string) bool {
+	if !slices.Contains(allowed, node.Value) {
+		v.errorf(node, p, "unsupported value %q, expected one of %s", node.Value, strings.Join(allowed, ", "))
+		return false
+	}
+	return true
+}
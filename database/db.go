@@ -50,10 +50,25 @@ func Initialize(ctx *core.APPContext) {
 
 	// Auto migrate the schema
 	err = DB.AutoMigrate(&models.User{}, &models.UserGitRepo{}, &models.Event{}, &models.AsyncTask{}, &models.SiteSetting{},
-		&models.UserRoleQuota{}, &models.UserStorage{}, &models.UserStorageFile{}, &models.UserFileDraftStatus{})
+		&models.UserRoleQuota{}, &models.UserStorage{}, &models.UserStorageFile{}, &models.FileDraftStatus{},
+		&models.SearchFile{}, &models.SearchRepoState{}, &models.FileEditLease{},
+		&models.FileAutosave{}, &models.PublishSchedule{}, &models.PublishScheduleState{}, &models.FileWorkflow{}, &models.FileReviewer{},
+		&models.FileWorkflowHistory{}, &models.CommentThread{}, &models.FileComment{}, &models.CommentMention{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	// The draft status used to be kept per user from client headers, it is now read from the front matter
+	if err := DB.Migrator().DropTable("user_file_draft_statuses"); err != nil {
+		log.Fatalf("Failed to drop the user draft status table: %v", err)
+	}
+
+	// FTS4 ships with the default go-sqlite3 build, FTS5 would need the sqlite_fts5 build tag.
+	// The rowid of a search document is the ID of its SearchFile.
+	err = DB.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS " + models.SearchDocumentsTable +
+		" USING fts4(title, headings, front_matter, body, tokenize=unicode61)").Error
+	if err != nil {
+		log.Fatalf("Failed to create the search index: %v", err)
+	}
 
 	log.Info("Database migration completed")
 }
@@ -16,6 +16,7 @@ import (
 	"github.com/zhaojunlucky/mkdocs-cms/config"
 	"github.com/zhaojunlucky/mkdocs-cms/controllers"
 	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
 	"github.com/zhaojunlucky/mkdocs-cms/database"
 	"github.com/zhaojunlucky/mkdocs-cms/env"
 	"github.com/zhaojunlucky/mkdocs-cms/middleware"
@@ -127,6 +128,7 @@ func createContext(appConfig *config.Config) *core.APPContext {
 		CookieDomain:      cookieDomain,
 	}
 	ctx.GithubAppClient = utils.CreateGitHubAppClient(ctx)
+	ctx.Git = git.NewRunner(appConfig.Git)
 	return ctx
 }
 
@@ -115,6 +115,7 @@ func shouldSkipAuth(path string) bool {
 		regexp.MustCompile("^/api/auth/logout"),
 		regexp.MustCompile("^/api/github/webhook"),
 		regexp.MustCompile("^/api/site/version"),
+		regexp.MustCompile("^/api/site/schema/.+"),
 		regexp.MustCompile("^/api/v1/storage/.+"),
 		regexp.MustCompile("^/metrics"),
 	}
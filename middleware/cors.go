@@ -17,8 +17,8 @@ func CORSWithConfig(appConfig *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		origin := "*"
 		allowCredentials := "true"
-		allowMethods := "POST, OPTIONS, GET, PUT, DELETE"
-		allowHeaders := "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With"
+		allowMethods := "POST, OPTIONS, GET, PUT, PATCH, DELETE"
+		allowHeaders := "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, If-Match, If-None-Match, X-Edit-Lease"
 
 		// Use configuration if provided
 		if appConfig != nil && appConfig.Security.CORS.AllowedOrigins != nil && len(appConfig.Security.CORS.AllowedOrigins) > 0 {
@@ -37,6 +37,8 @@ func CORSWithConfig(appConfig *config.Config) gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", allowCredentials)
 		c.Writer.Header().Set("Access-Control-Allow-Headers", allowHeaders)
 		c.Writer.Header().Set("Access-Control-Allow-Methods", allowMethods)
+		// Clients read the version of a file from the ETag header to send it back as If-Match
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, X-Edit-Lease-Warning")
 
 		if c.Request.Method == "OPTIONS" {
 			log.Infof("OPTIONS request for %s", c.Request.URL.Path)
@@ -24,6 +24,12 @@ type TaskType string
 const (
 	// TaskTypeSync indicates a repository sync task
 	TaskTypeSync TaskType = "sync"
+	// TaskTypeRepair indicates a repository self-repair task
+	TaskTypeRepair TaskType = "repair"
+	// TaskTypePush indicates a task delivering pending local commits
+	TaskTypePush TaskType = "push"
+	// TaskTypeScaffold indicates a task populating a new site from a template
+	TaskTypeScaffold TaskType = "scaffold"
 	// Add more task types as needed
 )
 
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// FileAutosave is the work in progress of a user on a collection file. It is kept apart from the repository
+// and only committed when the user publishes it.
+type FileAutosave struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	UserID         string    `json:"-" gorm:"uniqueIndex:idx_file_autosave,not null"`
+	RepoID         uint      `json:"repo_id" gorm:"uniqueIndex:idx_file_autosave,not null"`
+	CollectionName string    `json:"collection" gorm:"uniqueIndex:idx_file_autosave,not null"`
+	FilePath       string    `json:"path" gorm:"uniqueIndex:idx_file_autosave,not null"`
+	// BaseETag is the version of the file the work started from, empty for a new file
+	BaseETag string `json:"base_etag"`
+	// NewFile is set when the file did not exist when the work started, publishing fails once it was created
+	NewFile bool   `json:"new_file"`
+	Content string `json:"content,omitempty" gorm:"type:text;not null"`
+	Size    int    `json:"size"`
+}
+
+// SaveAutosaveRequest stores the content of a file without committing it
+type SaveAutosaveRequest struct {
+	Path    string `json:"path" binding:"required"`
+	Content string `json:"content"`
+}
+
+// PublishAutosaveRequest commits the autosave of a file
+type PublishAutosaveRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// AutosaveDiff compares an autosave with the version of the file at HEAD
+type AutosaveDiff struct {
+	Path     string `json:"path"`
+	BaseETag string `json:"base_etag"`
+	HeadETag string `json:"head_etag"`
+	// Stale is set when the file was changed at HEAD after the work started
+	Stale bool   `json:"stale"`
+	Diff  string `json:"diff"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+)
+
+// CommentThread is a discussion on a collection file, anchored to a line range or to a heading. A thread with
+// neither is about the whole file.
+type CommentThread struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	RepoID         uint      `json:"repo_id" gorm:"index:idx_comment_thread,not null"`
+	CollectionName string    `json:"collection" gorm:"index:idx_comment_thread,not null"`
+	FilePath       string    `json:"path" gorm:"index:idx_comment_thread,not null"`
+	UserID         string    `json:"user_id" gorm:"not null"`
+	UserName       string    `json:"user_name" gorm:"-"`
+	// StartLine and EndLine are the 1-based, inclusive lines of the anchor, both 0 for a thread on the whole file.
+	// They are re-mapped when the file changes.
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Heading   string `json:"heading,omitempty"`
+	// Quote is the anchored text when the thread was started
+	Quote string `json:"quote,omitempty" gorm:"type:text"`
+	// Outdated is set once every anchored line was changed or removed, or the heading is gone
+	Outdated   bool          `json:"outdated"`
+	Resolved   bool          `json:"resolved" gorm:"index"`
+	ResolvedBy string        `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time    `json:"resolved_at,omitempty"`
+	Comments   []FileComment `json:"comments" gorm:"foreignKey:ThreadID"`
+}
+
+// FileComment is a comment of a thread, the first one starts it
+type FileComment struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ThreadID  uint      `json:"thread_id" gorm:"index;not null"`
+	UserID    string    `json:"user_id" gorm:"not null"`
+	UserName  string    `json:"user_name" gorm:"-"`
+	Body      string    `json:"body" gorm:"type:text;not null"`
+	// Mentions are the IDs of the users mentioned with @username
+	Mentions []string `json:"mentions,omitempty" gorm:"-"`
+}
+
+// CommentMention records a user mentioned in a comment
+type CommentMention struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	ThreadID  uint      `json:"thread_id" gorm:"index;not null"`
+	CommentID uint      `json:"comment_id" gorm:"index;not null"`
+	UserID    string    `json:"user_id" gorm:"index;not null"`
+}
+
+// CreateCommentThreadRequest starts a thread on a file. StartLine and EndLine anchor it to a line range, Heading
+// to the heading with that text, and without either the thread is about the whole file.
+type CreateCommentThreadRequest struct {
+	Path      string `json:"path" binding:"required"`
+	StartLine int    `json:"start_line" binding:"min=0"`
+	EndLine   int    `json:"end_line" binding:"min=0"`
+	Heading   string `json:"heading"`
+	Body      string `json:"body" binding:"required"`
+}
+
+// ReplyCommentRequest adds a comment to a thread
+type ReplyCommentRequest struct {
+	Body string `json:"body" binding:"required"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// FileDraftStatus records a draft file of a collection. It is derived from the draft key of the front matter,
+// so it is the same for every user of the repository.
+type FileDraftStatus struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	RepoID         uint   `gorm:"uniqueIndex:idx_file_draft_status,not null"`
+	CollectionName string `gorm:"uniqueIndex:idx_file_draft_status,not null"`
+	FilePath       string `gorm:"uniqueIndex:idx_file_draft_status,not null"`
+	Draft          bool   `gorm:"not null"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// FileEditLease records that a user is editing a collection file. It lapses at ExpiresAt unless the
+// editor renews it, so a closed browser does not block the file for long.
+type FileEditLease struct {
+	ID             string    `json:"id" gorm:"primaryKey;type:text"`
+	CreatedAt      time.Time `json:"since"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	RepoID         uint      `json:"repo_id" gorm:"uniqueIndex:idx_file_edit_lease,not null"`
+	CollectionName string    `json:"collection" gorm:"uniqueIndex:idx_file_edit_lease,not null"`
+	FilePath       string    `json:"path" gorm:"uniqueIndex:idx_file_edit_lease,not null"`
+	UserID         string    `json:"user_id" gorm:"index;not null"`
+	ExpiresAt      time.Time `json:"expires_at" gorm:"index;not null"`
+	// UserName is the display name of the editor, it is filled in when leases are returned to clients
+	UserName string `json:"user_name" gorm:"-"`
+}
+
+// Presence describes the lease for people, e.g. "being edited by alice since 2025-04-18 10:30 UTC"
+func (l *FileEditLease) Presence() string {
+	return fmt.Sprintf("being edited by %s since %s", l.UserName, l.CreatedAt.UTC().Format("2006-01-02 15:04 MST"))
+}
+
+// AcquireLeaseRequest asks for a lease on a collection file, the TTL is in seconds
+type AcquireLeaseRequest struct {
+	Path       string `json:"path" binding:"required"`
+	TTLSeconds int    `json:"ttl_seconds"`
+	// Force takes over a lease the same user holds in another session, e.g. a closed browser tab
+	Force bool `json:"force"`
+}
+
+// RenewLeaseRequest extends a lease, it is sent as a heartbeat while the editor is open
+type RenewLeaseRequest struct {
+	TTLSeconds int `json:"ttl_seconds"`
+}
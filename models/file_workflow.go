@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+)
+
+// FileWorkflow is the editorial state of an entry of a collection with a workflow. Entries without one are in
+// the initial state of the workflow.
+type FileWorkflow struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	RepoID         uint      `json:"repo_id" gorm:"uniqueIndex:idx_file_workflow,not null"`
+	CollectionName string    `json:"collection" gorm:"uniqueIndex:idx_file_workflow,not null"`
+	FilePath       string    `json:"path" gorm:"uniqueIndex:idx_file_workflow,not null"`
+	State          string    `json:"state" gorm:"not null"`
+	// RoundStartedAt is when the entry last entered the initial state, reviews given before it no longer count
+	RoundStartedAt time.Time `json:"round_started_at"`
+}
+
+// FileReviewer is a user assigned to review an entry
+type FileReviewer struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"created_at"`
+	WorkflowID uint      `json:"-" gorm:"uniqueIndex:idx_file_reviewer,not null"`
+	UserID     string    `json:"user_id" gorm:"uniqueIndex:idx_file_reviewer,not null"`
+	UserName   string    `json:"user_name" gorm:"-"`
+	AssignedBy string    `json:"assigned_by"`
+	// Decision is the latest review of the reviewer in the current round, empty when there is none
+	Decision WorkflowAction `json:"decision,omitempty" gorm:"-"`
+}
+
+// WorkflowAction is what happened to an entry in its workflow history
+type WorkflowAction string
+
+const (
+	// WorkflowActionTransition moved the entry to another state
+	WorkflowActionTransition WorkflowAction = "transition"
+	// WorkflowActionAssign assigned a reviewer
+	WorkflowActionAssign WorkflowAction = "assign"
+	// WorkflowActionUnassign removed a reviewer
+	WorkflowActionUnassign WorkflowAction = "unassign"
+	// WorkflowActionApprove approved the entry
+	WorkflowActionApprove WorkflowAction = "approve"
+	// WorkflowActionRequestChanges asked for changes to the entry
+	WorkflowActionRequestChanges WorkflowAction = "request_changes"
+)
+
+// FileWorkflowHistory records a transition, an assignment or a review of an entry
+type FileWorkflowHistory struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time      `json:"created_at"`
+	WorkflowID uint           `json:"-" gorm:"index;not null"`
+	UserID     string         `json:"user_id" gorm:"not null"`
+	UserName   string         `json:"user_name" gorm:"-"`
+	Action     WorkflowAction `json:"action" gorm:"type:string;not null"`
+	FromState  string         `json:"from,omitempty"`
+	ToState    string         `json:"to,omitempty"`
+	// Reviewer is the user assigned or unassigned
+	Reviewer string `json:"reviewer,omitempty"`
+	Comment  string `json:"comment,omitempty" gorm:"type:text"`
+}
+
+// WorkflowStatus is the workflow state of an entry as shown in file listings
+type WorkflowStatus struct {
+	State             string   `json:"state"`
+	Approvals         int      `json:"approvals"`
+	RequiredApprovals int      `json:"required_approvals"`
+	ChangesRequested  bool     `json:"changes_requested,omitempty"`
+	Reviewers         []string `json:"reviewers,omitempty"`
+}
+
+// FileWorkflowResponse is the workflow of an entry with its reviewers, the states the user may move it to and
+// its history, newest first
+type FileWorkflowResponse struct {
+	Path        string                `json:"path"`
+	Status      *WorkflowStatus       `json:"status"`
+	Publish     string                `json:"publish"`
+	Reviewers   []FileReviewer        `json:"reviewers"`
+	Transitions []string              `json:"transitions"`
+	History     []FileWorkflowHistory `json:"history"`
+}
+
+// WorkflowTransitionRequest moves an entry to another state
+type WorkflowTransitionRequest struct {
+	Path    string `json:"path" binding:"required"`
+	To      string `json:"to" binding:"required"`
+	Comment string `json:"comment"`
+}
+
+// AssignReviewersRequest replaces the reviewers of an entry with the given user IDs
+type AssignReviewersRequest struct {
+	Path      string   `json:"path" binding:"required"`
+	Reviewers []string `json:"reviewers"`
+}
+
+// WorkflowReviewRequest approves an entry or asks for changes
+type WorkflowReviewRequest struct {
+	Path     string         `json:"path" binding:"required"`
+	Decision WorkflowAction `json:"decision" binding:"required,oneof=approve request_changes"`
+	Comment  string         `json:"comment"`
+}
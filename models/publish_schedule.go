@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+)
+
+// PublishAction is what a scheduled publication does to an entry
+type PublishAction string
+
+const (
+	// PublishActionPublish makes an entry public at its publish_at time
+	PublishActionPublish PublishAction = "publish"
+	// PublishActionUnpublish takes an entry down at its unpublish_at time
+	PublishActionUnpublish PublishAction = "unpublish"
+)
+
+// PublishStatus is the state of a scheduled publication
+type PublishStatus string
+
+const (
+	// PublishStatusPending waits for its time, or for a retry after a failed attempt
+	PublishStatusPending PublishStatus = "pending"
+	// PublishStatusDone was committed and pushed
+	PublishStatusDone PublishStatus = "done"
+	// PublishStatusFailed gave up after too many failed attempts
+	PublishStatusFailed PublishStatus = "failed"
+	// PublishStatusSkipped was not carried out, the entry was already in the state or the time had passed
+	// before the previous scan of the repository
+	PublishStatusSkipped PublishStatus = "skipped"
+)
+
+// PublishSchedule is a publication or unpublication of a collection entry at a time taken from the publish_at or
+// unpublish_at front matter. Schedules are kept in the database so that they survive restarts.
+type PublishSchedule struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	RepoID         uint      `json:"repo_id" gorm:"index:idx_publish_schedule,not null"`
+	CollectionName string    `json:"collection" gorm:"index:idx_publish_schedule,not null"`
+	// EntryPath identifies the entry across the moves of the folder mode, it is the path below the drafts folder
+	EntryPath string        `json:"entry_path" gorm:"index:idx_publish_schedule,not null"`
+	FilePath  string        `json:"path" gorm:"not null"`
+	Action    PublishAction `json:"action" gorm:"type:string;not null"`
+	Mode      string        `json:"mode" gorm:"not null"`
+	// At is the scheduled time in UTC, LocalAt is the same time in the time zone of the collection schedule
+	At       time.Time     `json:"at" gorm:"not null;index"`
+	Timezone string        `json:"timezone,omitempty"`
+	LocalAt  string        `json:"local_at,omitempty" gorm:"-"`
+	Status   PublishStatus `json:"status" gorm:"type:string;not null;default:'pending';index"`
+	Attempts int           `json:"attempts"`
+	Error    string        `json:"error,omitempty"`
+	DoneAt   *time.Time    `json:"done_at,omitempty"`
+}
+
+// PublishScheduleState records when the schedules of a repository were last synced, a schedule first found
+// with a time before that sync was never going to be carried out and is skipped
+type PublishScheduleState struct {
+	RepoID    uint      `gorm:"primaryKey;autoIncrement:false"`
+	SyncedAt  time.Time `gorm:"not null"`
+	UpdatedAt time.Time
+}
@@ -2,6 +2,9 @@ package models
 
 import "gorm.io/gorm"
 
+// RoleAdmin is the role of users who administrate the site, e.g. release the edit leases of others
+const RoleAdmin = "admin"
+
 type Role struct {
 	gorm.Model         // Includes fields ID, CreatedAt, UpdatedAt, DeletedAt
 	Name        string `gorm:"uniqueIndex;not null;size:50"` // Role name (e.g., "admin", "editor")
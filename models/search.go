@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+// SearchDocumentsTable is the FTS4 table holding the text of every indexed collection file
+const SearchDocumentsTable = "search_documents"
+
+// SearchFile is a collection file in the search index, its ID is the rowid of the search document
+type SearchFile struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	RepoID     uint      `gorm:"index:idx_search_file,unique,not null"`
+	Collection string    `gorm:"index:idx_search_file,unique,not null"`
+	Path       string    `gorm:"index:idx_search_file,unique,not null"`
+	Title      string    `gorm:"not null"`
+	Size       int64     `gorm:"not null"`
+	ModTime    time.Time `gorm:"not null"`
+}
+
+// SearchRepoState records the HEAD a repository was last indexed at
+type SearchRepoState struct {
+	RepoID    uint `gorm:"primaryKey;autoIncrement:false"`
+	UpdatedAt time.Time
+	HeadSHA   string `gorm:"not null"`
+}
+
+// SearchResult is a ranked match of a search query
+type SearchResult struct {
+	RepoID     uint   `json:"repo_id"`
+	RepoName   string `json:"repo_name"`
+	Collection string `json:"collection"`
+	Path       string `json:"path"`
+	Title      string `json:"title"`
+	// Snippet is HTML: the document text is escaped and the matched terms are wrapped in <mark>
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// SearchResponse is a page of search results, Total counts every match
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+}
@@ -31,6 +31,16 @@ func (u *User) GetQuotaRole() *Role {
 	return nil
 }
 
+// HasRole reports whether the user has the role with the given name, the roles must be loaded
+func (u *User) HasRole(name string) bool {
+	for _, role := range u.Roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // UserResponse is the structure returned to clients
 type UserResponse struct {
 	ID        string    `json:"id"`
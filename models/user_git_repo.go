@@ -103,3 +103,26 @@ type UpdateUserGitRepoRequest struct {
 	ErrorMsg       string        `json:"error_msg"`
 	InstallationID int64         `json:"installation_id"`
 }
+
+// RepairUserGitRepoRequest is the structure for repository repair requests
+type RepairUserGitRepoRequest struct {
+	Action string `json:"action" binding:"required"`
+}
+
+// CreateSiteRequest is the structure for creating a new site from a template, the repository is
+// created through the GitHub App installation or pushed to an existing empty remote. An existing
+// GitHub remote is pushed with the token of the installation when installation_id is set as well.
+type CreateSiteRequest struct {
+	Name           string `json:"name" binding:"required"`
+	Description    string `json:"description"`
+	Template       string `json:"template"`
+	Branch         string `json:"branch"`
+	Private        bool   `json:"private"`
+	InstallationID int64  `json:"installation_id"`
+	RemoteURL      string `json:"remote_url"`
+}
+
+// UpdateVedaConfigRequest is the structure for validating or committing a new veda/config.yml
+type UpdateVedaConfigRequest struct {
+	Content string `json:"content" binding:"required"`
+}
@@ -1,12 +1,59 @@
 package models
 
 import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
 	"time"
 )
 
 // ContentFormat represents the format of content in a collection
 type ContentFormat string
 
+const (
+	FormatMarkdown ContentFormat = "md"
+	FormatYAML     ContentFormat = "yaml"
+	FormatJSON     ContentFormat = "json"
+)
+
+const (
+	// EntriesFile makes every file of a collection one entry, the default
+	EntriesFile = "file"
+	// EntriesList makes every item of the list, or every value of the mapping, in a data file one entry
+	EntriesList = "list"
+)
+
+const (
+	// ScheduleModeDraft publishes an entry by setting its draft front matter key to false, the default
+	ScheduleModeDraft = "draft"
+	// ScheduleModeFolder publishes an entry by moving it out of the drafts folder of the collection
+	ScheduleModeFolder = "folder"
+)
+
+// DefaultDraftKey is the front matter key marking an entry as a draft unless the collection names another one
+const DefaultDraftKey = "draft"
+
+// Extensions returns the file extensions of the format, the first one is used for new files
+func (f ContentFormat) Extensions() []string {
+	switch f {
+	case FormatYAML:
+		return []string{".yml", ".yaml"}
+	case FormatJSON:
+		return []string{".json"}
+	}
+	return []string{".md"}
+}
+
+// HasExtension reports whether a file name has one of the extensions of the format
+func (f ContentFormat) HasExtension(name string) bool {
+	return slices.Contains(f.Extensions(), filepath.Ext(name))
+}
+
+// IsData reports whether files of the format hold structured data instead of markdown with front matter
+func (f ContentFormat) IsData() bool {
+	return f == FormatYAML || f == FormatJSON
+}
+
 type Field struct {
 	Type     string `yaml:"type" json:"type"`
 	Name     string `yaml:"name" json:"name"`
@@ -15,6 +62,19 @@ type Field struct {
 	Format   string `yaml:"format,omitempty" json:"format"`
 	List     bool   `yaml:"list,omitempty" json:"list"`
 	Default  string `yaml:"default,omitempty" json:"default"`
+	// Options are the allowed values of a select field
+	Options []string `yaml:"options,omitempty" json:"options,omitempty"`
+	// Collection is the collection a reference field points to
+	Collection string `yaml:"collection,omitempty" json:"collection,omitempty"`
+	// Path is the directory, relative to the repository root, that holds the images of an image field
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Min and Max bound the value of a number field
+	Min *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+	// Timezone is applied to datetime values written without an offset
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	// Fields are the subfields of an object field
+	Fields []Field `yaml:"fields,omitempty" json:"fields,omitempty"`
 }
 
 type FileNameGenerator struct {
@@ -22,6 +82,81 @@ type FileNameGenerator struct {
 	First string `yaml:"first" json:"first"`
 }
 
+// Schedule configures how the publish_at and unpublish_at front matter of the entries of a collection is carried out
+type Schedule struct {
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Drafts is the folder, relative to the collection, holding unpublished entries in ScheduleModeFolder
+	Drafts string `yaml:"drafts,omitempty" json:"drafts,omitempty"`
+	// Timezone is applied to schedule times written without an offset
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+// Workflow configures the editorial states the entries of a collection go through before they are published
+type Workflow struct {
+	States []string `yaml:"states" json:"states"`
+	// Publish is the state in which entries are published, the last state when empty
+	Publish string `yaml:"publish,omitempty" json:"publish,omitempty"`
+	// RequiredApprovals is how many assigned reviewers must approve an entry before it can be published
+	RequiredApprovals int `yaml:"required_approvals,omitempty" json:"required_approvals"`
+	// Transitions are the allowed state changes. Without them an entry moves to the next state or back to any
+	// earlier one.
+	Transitions []WorkflowTransition `yaml:"transitions,omitempty" json:"transitions,omitempty"`
+}
+
+// WorkflowTransition allows a change from one state, or from any state with "*", to another
+type WorkflowTransition struct {
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+	// Roles may make the change: WorkflowRoleOwner, WorkflowRoleReviewer or the name of a user role, a user role
+	// only counts for the owner and the reviewers of the entry. Only the repository owner may make it when empty.
+	Roles []string `yaml:"roles,omitempty" json:"roles,omitempty"`
+}
+
+const (
+	// WorkflowRoleOwner is the owner of the repository
+	WorkflowRoleOwner = "owner"
+	// WorkflowRoleReviewer is a reviewer assigned to the entry
+	WorkflowRoleReviewer = "reviewer"
+	// WorkflowAnyState matches every state in the from of a transition
+	WorkflowAnyState = "*"
+)
+
+// InitialState is the state of entries that never changed state
+func (w *Workflow) InitialState() string {
+	return w.States[0]
+}
+
+// PublishState is the state in which entries are published
+func (w *Workflow) PublishState() string {
+	if w.Publish != "" {
+		return w.Publish
+	}
+	return w.States[len(w.States)-1]
+}
+
+// HasState reports whether the workflow has the named state
+func (w *Workflow) HasState(state string) bool {
+	return slices.Contains(w.States, state)
+}
+
+// Transition returns the transition allowing a change from one state to another
+func (w *Workflow) Transition(from string, to string) (WorkflowTransition, bool) {
+	if len(w.Transitions) == 0 {
+		index := slices.Index(w.States, from)
+		target := slices.Index(w.States, to)
+		if index < 0 || target < 0 || target == index || target > index+1 {
+			return WorkflowTransition{}, false
+		}
+		return WorkflowTransition{From: from, To: to}, true
+	}
+	for _, transition := range w.Transitions {
+		if (transition.From == from || transition.From == WorkflowAnyState) && transition.To == to && from != to {
+			return transition, true
+		}
+	}
+	return WorkflowTransition{}, false
+}
+
 // UserGitRepoCollection represents a collection of content within a git repository
 type UserGitRepoCollection struct {
 	ID                uint               `json:"id" gorm:"primaryKey"`
@@ -36,6 +171,22 @@ type UserGitRepoCollection struct {
 	UpdatedAt         time.Time          `json:"updated_at"`
 	Fields            []Field            `json:"fields" gorm:"foreignKey:CollectionID"`
 	FileNameGenerator *FileNameGenerator `json:"file_name_generator" gorm:"foreignKey:CollectionID"`
+	Exclude           []string           `json:"exclude,omitempty" gorm:"-"`
+	// Entries is EntriesFile or EntriesList, Root is the dotted key of the list within the files of a list collection
+	Entries  string    `json:"entries,omitempty" gorm:"-"`
+	Root     string    `json:"root,omitempty" gorm:"-"`
+	Schedule *Schedule `json:"schedule,omitempty" gorm:"-"`
+	// DraftKey is the dotted front matter key marking an entry as a draft, DefaultDraftKey when empty
+	DraftKey string    `json:"draft_key,omitempty" gorm:"-"`
+	Workflow *Workflow `json:"workflow,omitempty" gorm:"-"`
+}
+
+// DraftFrontMatterKey returns the front matter key marking an entry of the collection as a draft
+func (c *UserGitRepoCollection) DraftFrontMatterKey() string {
+	if c.DraftKey != "" {
+		return c.DraftKey
+	}
+	return DefaultDraftKey
 }
 
 // UserGitRepoCollectionResponse is the structure returned to clients
@@ -52,6 +203,12 @@ type UserGitRepoCollectionResponse struct {
 	UpdatedAt         time.Time           `json:"updated_at"`
 	Fields            []Field             `json:"fields,omitempty"`
 	FileNameGenerator *FileNameGenerator  `json:"file_name_generator,omitempty"`
+	Exclude           []string            `json:"exclude,omitempty"`
+	Entries           string              `json:"entries,omitempty"`
+	Root              string              `json:"root,omitempty"`
+	Schedule          *Schedule           `json:"schedule,omitempty"`
+	DraftKey          string              `json:"draft_key,omitempty"`
+	Workflow          *Workflow           `json:"workflow,omitempty"`
 }
 
 // ToResponse converts a UserGitRepoCollection to a UserGitRepoCollectionResponse
@@ -68,6 +225,12 @@ func (c *UserGitRepoCollection) ToResponse(includeRepo bool) UserGitRepoCollecti
 		UpdatedAt:         c.UpdatedAt,
 		Fields:            c.Fields,
 		FileNameGenerator: c.FileNameGenerator,
+		Exclude:           c.Exclude,
+		Entries:           c.Entries,
+		Root:              c.Root,
+		Schedule:          c.Schedule,
+		DraftKey:          c.DraftKey,
+		Workflow:          c.Workflow,
 	}
 
 	if includeRepo {
@@ -82,3 +245,37 @@ type FileUploadRequest struct {
 	Path    string `json:"path" binding:"required"`
 	Content string `json:"content"`
 }
+
+// UpdateDocumentRequest replaces the front matter and body of a markdown file, a missing body keeps the current one
+type UpdateDocumentRequest struct {
+	FrontMatter json.RawMessage `json:"front_matter" binding:"required"`
+	Body        *string         `json:"body"`
+}
+
+// CreateEntryRequest creates a markdown file in Path, a directory of the collection, the server chooses its name
+type CreateEntryRequest struct {
+	Path        string          `json:"path"`
+	FrontMatter json.RawMessage `json:"front_matter" binding:"required"`
+	Body        *string         `json:"body"`
+}
+
+// CopyFileRequest copies a file or directory, without NewPath the server names the copy
+type CopyFileRequest struct {
+	Path    string `json:"path" binding:"required"`
+	NewPath string `json:"new_path"`
+}
+
+// BulkDeleteRequest deletes many files and directories of a collection
+type BulkDeleteRequest struct {
+	Paths []string `json:"paths" binding:"required,min=1"`
+	// Versions are the ETags the paths were read with, keyed by path, as If-Match is for a single path
+	Versions map[string]string `json:"versions"`
+}
+
+// BulkMoveRequest moves many files and directories into Target, an empty target is the collection root
+type BulkMoveRequest struct {
+	Paths  []string `json:"paths" binding:"required,min=1"`
+	Target string   `json:"target"`
+	// Versions are the ETags the paths were read with, keyed by path, as If-Match is for a single path
+	Versions map[string]string `json:"versions"`
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
+	"github.com/zhaojunlucky/mkdocs-cms/core/md"
+	"github.com/zhaojunlucky/mkdocs-cms/database"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gorm.io/gorm"
+)
+
+// AutosaveService keeps the work in progress of editors in the database. Autosaves are never committed,
+// publishing one saves it through UpdateFileContent like any other change.
+type AutosaveService struct {
+	BaseService
+	collectionService *UserGitRepoCollectionService
+}
+
+func (s *AutosaveService) Init(ctx *core.APPContext) {
+	s.InitService("autosaveService", ctx, s)
+	s.collectionService = ctx.MustGetService("userGitRepoCollectionService").(*UserGitRepoCollectionService)
+}
+
+// collectionFile validates the collection and returns it with the cleaned path of a file
+func (s *AutosaveService) collectionFile(repo *models.UserGitRepo, collectionName string, filePath string) (models.UserGitRepoCollection, string, error) {
+	collection, err := s.collectionService.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return collection, "", err
+	}
+	cleanPath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return collection, "", err
+	}
+	if fi, err := os.Stat(filepath.Join(collection.Path, cleanPath)); err == nil && fi.IsDir() {
+		return collection, "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("%s is a directory", cleanPath))
+	}
+	return collection, cleanPath, nil
+}
+
+func (s *AutosaveService) find(userID string, repoID uint, collectionName string, filePath string) (*models.FileAutosave, error) {
+	var autosave models.FileAutosave
+	err := database.DB.Where("user_id = ? AND repo_id = ? AND collection_name = ? AND file_path = ?",
+		userID, repoID, collectionName, filePath).First(&autosave).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("there is no autosave of %s", filePath))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &autosave, nil
+}
+
+// Save stores the content of a file for the user. The first autosave of a file records the version it is
+// based on, baseETag or the current version when empty, or that the file did not exist yet.
+func (s *AutosaveService) Save(repo *models.UserGitRepo, collectionName string, userID string, filePath string,
+	content string, baseETag string) (*models.FileAutosave, error) {
+	_, cleanPath, err := s.collectionFile(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	autosave, err := s.find(userID, repo.ID, collectionName, cleanPath)
+	var httpErr *core.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		if baseETag == "" {
+			if baseETag, err = s.collectionService.FileETag(repo, collectionName, cleanPath); err != nil {
+				return nil, err
+			}
+		}
+		autosave = &models.FileAutosave{
+			UserID:         userID,
+			RepoID:         repo.ID,
+			CollectionName: collectionName,
+			FilePath:       cleanPath,
+			BaseETag:       baseETag,
+			NewFile:        baseETag == "",
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	autosave.Content = content
+	autosave.Size = len(content)
+	if err := database.DB.Save(autosave).Error; err != nil {
+		return nil, err
+	}
+	return autosave, nil
+}
+
+// List returns the autosaves of the user in a collection, without their content
+func (s *AutosaveService) List(repo *models.UserGitRepo, collectionName string, userID string) ([]models.FileAutosave, error) {
+	var autosaves []models.FileAutosave
+	err := database.DB.Omit("content").Where("user_id = ? AND repo_id = ? AND collection_name = ?", userID, repo.ID, collectionName).
+		Order("file_path").Find(&autosaves).Error
+	return autosaves, err
+}
+
+// Get returns the autosave of a file with its content
+func (s *AutosaveService) Get(repo *models.UserGitRepo, collectionName string, userID string, filePath string) (*models.FileAutosave, error) {
+	cleanPath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.find(userID, repo.ID, collectionName, cleanPath)
+}
+
+// Discard drops the autosave of a file
+func (s *AutosaveService) Discard(repo *models.UserGitRepo, collectionName string, userID string, filePath string) error {
+	autosave, err := s.Get(repo, collectionName, userID, filePath)
+	if err != nil {
+		return err
+	}
+	return database.DB.Delete(autosave).Error
+}
+
+// headFile returns the content and blob sha of a file at HEAD, both empty when HEAD has no such file.
+// Markdown is returned as the editor sees it, like GetFileContent does.
+func (s *AutosaveService) headFile(ctx context.Context, repo *models.UserGitRepo, collection models.UserGitRepoCollection,
+	filePath string) ([]byte, string, error) {
+	rel, err := filepath.Rel(repo.LocalPath, filepath.Join(collection.Path, filePath))
+	if err != nil {
+		return nil, "", err
+	}
+	rel = filepath.ToSlash(rel)
+	out, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "ls-tree", "HEAD", "--", rel)
+	if err != nil {
+		return nil, "", err
+	}
+	// <mode> blob <sha>\t<path>
+	fields := strings.Fields(strings.SplitN(string(out), "\t", 2)[0])
+	if len(fields) != 3 || fields[1] != "blob" {
+		return nil, "", nil
+	}
+	content, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "cat-file", "blob", fields[2])
+	if err != nil {
+		return nil, "", err
+	}
+	if filepath.Ext(filePath) == ".md" {
+		content = s.collectionService.handleMarkdown(repo, content, md.DirectionRead)
+	}
+	return content, fields[2], nil
+}
+
+// Diff compares the autosave of a file with the file at HEAD as a unified diff
+func (s *AutosaveService) Diff(ctx context.Context, repo *models.UserGitRepo, collectionName string, userID string,
+	filePath string) (*models.AutosaveDiff, error) {
+	collection, cleanPath, err := s.collectionFile(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	autosave, err := s.find(userID, repo.ID, collectionName, cleanPath)
+	if err != nil {
+		return nil, err
+	}
+	head, headETag, err := s.headFile(ctx, repo, collection, cleanPath)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := s.unifiedDiff(ctx, filepath.Base(cleanPath), head, []byte(autosave.Content))
+	if err != nil {
+		return nil, err
+	}
+	return &models.AutosaveDiff{
+		Path:     autosave.FilePath,
+		BaseETag: autosave.BaseETag,
+		HeadETag: headETag,
+		Stale:    autosave.BaseETag != headETag,
+		Diff:     diff,
+	}, nil
+}
+
+// unifiedDiff lets git compare two contents outside of any repository, the sides are named a/<name> and b/<name>
+func (s *AutosaveService) unifiedDiff(ctx context.Context, name string, before []byte, after []byte) (string, error) {
+	dir, err := os.MkdirTemp("", "autosave-diff-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	for side, content := range map[string][]byte{"a": before, "b": after} {
+		if err := os.Mkdir(filepath.Join(dir, side), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, side, name), content, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	out, err := s.ctx.Git.Output(ctx, git.OpLocal, dir, "diff", "--no-index", "--no-prefix", "--no-color",
+		"--", filepath.Join("a", name), filepath.Join("b", name))
+	// git diff --no-index exits with 1 when the files differ
+	var cmdErr *git.CommandError
+	var exitErr *exec.ExitError
+	if errors.As(err, &cmdErr) && errors.As(cmdErr.Err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Publish commits the autosave of a file and drops it. Unless the change names a version itself, it is based on
+// the version the work started from, so publishing over a change made in the meantime, or over a file created
+// in the meantime, fails with 412.
+func (s *AutosaveService) Publish(ctx context.Context, repo *models.UserGitRepo, collectionName string, userID string,
+	filePath string, options UpdateFileOptions) (*models.FileAutosave, error) {
+	autosave, err := s.Get(repo, collectionName, userID, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if options.IfMatch == "" && !options.MustNotExist {
+		options.IfMatch = autosave.BaseETag
+		options.MustNotExist = autosave.NewFile
+	}
+	if err := s.collectionService.UpdateFileContent(ctx, repo, collectionName, autosave.FilePath, []byte(autosave.Content), options); err != nil {
+		return nil, err
+	}
+	if err := database.DB.Delete(autosave).Error; err != nil {
+		log.Errorf("Failed to drop the published autosave of %s: %v", autosave.FilePath, err)
+	}
+	return autosave, nil
+}
+
+// MovePath moves the autosaves of a moved file, or of the files below a moved directory. An autosave the user already
+// had at the new path is replaced, it was based on a file that did not exist.
+func (s *AutosaveService) MovePath(repoID uint, collectionName string, oldPath string, newPath string) error {
+	oldPath, newPath = filepath.ToSlash(oldPath), filepath.ToSlash(newPath)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var autosaves []models.FileAutosave
+		err := tx.Omit("content").Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+			repoID, collectionName, oldPath, likePrefix(oldPath+"/")).Find(&autosaves).Error
+		if err != nil {
+			return err
+		}
+		for _, autosave := range autosaves {
+			movedPath := newPath + strings.TrimPrefix(autosave.FilePath, oldPath)
+			err := tx.Where("user_id = ? AND repo_id = ? AND collection_name = ? AND file_path = ?",
+				autosave.UserID, repoID, collectionName, movedPath).Delete(&models.FileAutosave{}).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.FileAutosave{}).Where("id = ?", autosave.ID).Update("file_path", movedPath).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePath drops the autosaves of a deleted file, or of the files below a deleted directory
+func (s *AutosaveService) DeletePath(repoID uint, collectionName string, filePath string) error {
+	filePath = filepath.ToSlash(filePath)
+	return database.DB.Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+		repoID, collectionName, filePath, likePrefix(filePath+"/")).Delete(&models.FileAutosave{}).Error
+}
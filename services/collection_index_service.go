@@ -0,0 +1,211 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/schema"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+)
+
+// IndexEntry is the metadata of one collection file, FrontMatter holds the values typed by the collection fields.
+// In yaml and json collections whose entries are list items there is one entry per item, named by Item.
+type IndexEntry struct {
+	Path        string
+	Item        string
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	FrontMatter map[string]interface{}
+}
+
+// Get resolves a front matter key, dotted names address object fields. The file metadata is
+// available as _path, _item, _name, _size and _mod_time.
+func (e *IndexEntry) Get(field string) (interface{}, bool) {
+	switch field {
+	case "_path":
+		return e.Path, true
+	case "_item":
+		return e.Item, e.Item != ""
+	case "_name":
+		return e.Name, true
+	case "_size":
+		return float64(e.Size), true
+	case "_mod_time":
+		return e.ModTime.UTC().Format(time.RFC3339), true
+	}
+
+	var current interface{} = e.FrontMatter
+	for _, part := range strings.Split(field, ".") {
+		values, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = values[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// IsDraft reports whether the front matter key marking drafts is true
+func (e *IndexEntry) IsDraft(draftKey string) bool {
+	value, ok := e.Get(draftKey)
+	if !ok {
+		return false
+	}
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(strings.TrimSpace(v), "true")
+	}
+	return false
+}
+
+func (e *IndexEntry) Key() string {
+	if e.Item != "" {
+		return e.Path + "#" + e.Item
+	}
+	return e.Path
+}
+
+// collectionIndex holds the entries of one collection, key is the HEAD sha and collection path and
+// fields the field definitions it was built for
+type collectionIndex struct {
+	key     string
+	fields  string
+	entries []*IndexEntry
+}
+
+// CollectionIndexService keeps the parsed front matter of every collection file so that entries can be
+// queried without reading each file per request. An index is rebuilt when HEAD or the collection fields
+// change, files whose mtime and size did not change are not parsed again.
+type CollectionIndexService struct {
+	BaseService
+	mutex   sync.Mutex
+	indexes map[uint]map[string]*collectionIndex
+}
+
+func (s *CollectionIndexService) Init(ctx *core.APPContext) {
+	s.InitService("collectionIndexService", ctx, s)
+	s.indexes = make(map[uint]map[string]*collectionIndex)
+}
+
+// Entries returns the indexed entries of a collection, the caller must hold a lock of the repository
+func (s *CollectionIndexService) Entries(repo *models.UserGitRepo, collection models.UserGitRepoCollection) ([]*IndexEntry, error) {
+	fields, err := json.Marshal(collection.Fields)
+	if err != nil {
+		return nil, err
+	}
+	head := readHeadSHA(repo.LocalPath)
+	key := fmt.Sprintf("%s:%s", head, collection.Path)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	repoIndexes, ok := s.indexes[repo.ID]
+	if !ok {
+		repoIndexes = make(map[string]*collectionIndex)
+		s.indexes[repo.ID] = repoIndexes
+	}
+	index := repoIndexes[collection.Name]
+	// Without a resolvable HEAD the index cannot tell whether it is current, the walk still reuses unchanged files
+	if index != nil && head != "" && index.key == key && index.fields == string(fields) {
+		return index.entries, nil
+	}
+
+	entries, err := s.build(collection, index, string(fields))
+	if err != nil {
+		return nil, err
+	}
+	repoIndexes[collection.Name] = &collectionIndex{key: key, fields: string(fields), entries: entries}
+	return entries, nil
+}
+
+// build walks the collection directory, entries of the previous index are reused for unchanged files
+// as long as the field definitions are the same
+func (s *CollectionIndexService) build(collection models.UserGitRepoCollection, previous *collectionIndex, fields string) ([]*IndexEntry, error) {
+	// A list file yields several entries, they are reused together
+	reusable := map[string][]*IndexEntry{}
+	if previous != nil && previous.fields == fields {
+		for _, entry := range previous.entries {
+			reusable[entry.Path] = append(reusable[entry.Path], entry)
+		}
+	}
+
+	entries := []*IndexEntry{}
+	parsed := 0
+	err := walkVisibleFiles(collection.Path, func(path string, rel string) {
+		if !collection.Format.HasExtension(path) {
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if old, ok := reusable[rel]; ok && old[0].Size == info.Size() && old[0].ModTime.Equal(info.ModTime()) {
+			entries = append(entries, old...)
+			return
+		}
+
+		newEntry := func(item string) *IndexEntry {
+			return &IndexEntry{Path: rel, Item: item, Name: filepath.Base(path), Size: info.Size(), ModTime: info.ModTime(),
+				FrontMatter: map[string]interface{}{}}
+		}
+		parsed++
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Warnf("Failed to read %s for the collection index: %v", path, err)
+			entries = append(entries, newEntry(""))
+			return
+		}
+		if !collection.Format.IsData() {
+			entry := newEntry("")
+			doc, err := schema.ParseDocument(collection.Fields, content)
+			if err == nil {
+				err = json.Unmarshal(doc.FrontMatter, &entry.FrontMatter)
+			}
+			if err != nil {
+				log.Warnf("Failed to parse front matter of %s for the collection index: %v", path, err)
+			}
+			entries = append(entries, entry)
+			return
+		}
+
+		items, err := schema.ParseDataItems(collection.Fields, schema.LayoutOf(collection), content)
+		if err != nil {
+			log.Warnf("Failed to parse data file %s for the collection index: %v", path, err)
+			if collection.Entries != models.EntriesList {
+				entries = append(entries, newEntry(""))
+			}
+			return
+		}
+		for _, item := range items {
+			entry := newEntry(item.Item)
+			if err := json.Unmarshal(item.FrontMatter, &entry.FrontMatter); err != nil {
+				log.Warnf("Failed to decode entry %s of %s for the collection index: %v", item.Item, path, err)
+			}
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Indexed collection %s: %d entries, %d parsed", collection.Name, len(entries), parsed)
+	return entries, nil
+}
+
+// Invalidate drops the indexes of a repository
+func (s *CollectionIndexService) Invalidate(repoID uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.indexes, repoID)
+}
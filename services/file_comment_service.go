@@ -0,0 +1,625 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/diff"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
+	"github.com/zhaojunlucky/mkdocs-cms/core/md"
+	"github.com/zhaojunlucky/mkdocs-cms/database"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gorm.io/gorm"
+)
+
+// maxQuoteLength caps the anchored text kept with a thread
+const maxQuoteLength = 2000
+
+var mentionRegex = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9][A-Za-z0-9_.-]*)`)
+
+// FileCommentService keeps the comment threads of collection files. Threads follow their file across renames
+// and their anchors are re-mapped through the diffs of later commits.
+type FileCommentService struct {
+	BaseService
+	userService         *UserService
+	eventService        *EventService
+	fileWorkflowService *FileWorkflowService
+}
+
+func (s *FileCommentService) Init(ctx *core.APPContext) {
+	s.InitService("fileCommentService", ctx, s)
+	s.userService = ctx.MustGetService("userService").(*UserService)
+	s.eventService = ctx.MustGetService("eventService").(*EventService)
+	s.fileWorkflowService = ctx.MustGetService("fileWorkflowService").(*FileWorkflowService)
+}
+
+// collectionService is looked up when used, it depends on this service
+func (s *FileCommentService) collectionService() *UserGitRepoCollectionService {
+	return s.ctx.MustGetService("userGitRepoCollectionService").(*UserGitRepoCollectionService)
+}
+
+// mayComment tells whether the user may read and write the threads of a file, the repository owner and the
+// reviewers assigned to the file may
+func (s *FileCommentService) mayComment(repo *models.UserGitRepo, collectionName string, filePath string, userID string) bool {
+	return userID == repo.UserID || s.fileWorkflowService.IsReviewer(repo.ID, collectionName, filePath, userID)
+}
+
+func (s *FileCommentService) checkAccess(repo *models.UserGitRepo, collectionName string, filePath string, userID string) error {
+	if !s.mayComment(repo, collectionName, filePath, userID) {
+		return core.NewHTTPErrorStr(http.StatusForbidden, fmt.Sprintf("you may not comment on %s", filePath))
+	}
+	return nil
+}
+
+// file returns the content of a collection file with its cleaned path, in the form threads store it
+func (s *FileCommentService) file(repo *models.UserGitRepo, collectionName string, filePath string) ([]byte, string, error) {
+	collection, err := s.collectionService().GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, "", err
+	}
+	cleanPath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	fullPath := filepath.Join(collection.Path, cleanPath)
+	fi, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return nil, "", core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("%s does not exist", cleanPath))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if fi.IsDir() {
+		return nil, "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("%s is a directory", cleanPath))
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, filepath.ToSlash(cleanPath), nil
+}
+
+// VerifyAccess returns the repository of a file to the users who may comment on it, before a lock of the
+// repository is taken. It does not tell whether the file exists.
+func (s *FileCommentService) VerifyAccess(userID string, repoID uint, collectionName string, filePath string) (*models.UserGitRepo, error) {
+	return s.fileWorkflowService.VerifyEntryAccess(userID, repoID, collectionName, filePath)
+}
+
+// VerifyThreadAccess returns the repository of a thread to the users who may comment on its file, before a lock of
+// the repository is taken. Others get the same error whether or not the thread exists.
+func (s *FileCommentService) VerifyThreadAccess(userID string, repoID uint, threadID uint) (*models.UserGitRepo, error) {
+	repo, err := s.collectionService().GetRepo(repoID)
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, err.Error())
+	}
+	if repo.UserID == userID {
+		return &repo, nil
+	}
+	var thread models.CommentThread
+	err = database.DB.Where("id = ? AND repo_id = ?", threadID, repo.ID).First(&thread).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err != nil || !s.mayComment(&repo, thread.CollectionName, thread.FilePath, userID) {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, fmt.Sprintf("you may not access comment thread %d", threadID))
+	}
+	return &repo, nil
+}
+
+// thread returns a thread of a repository with its comments, to a user who may comment on its file
+func (s *FileCommentService) thread(repo *models.UserGitRepo, threadID uint, userID string) (*models.CommentThread, error) {
+	var thread models.CommentThread
+	err := database.DB.Preload("Comments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("id = ? AND repo_id = ?", threadID, repo.ID).First(&thread).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("comment thread %d not found", threadID))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAccess(repo, thread.CollectionName, thread.FilePath, userID); err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+
+// withDetails fills in the display names of the users and the mentions of the comments of threads
+func (s *FileCommentService) withDetails(threads ...*models.CommentThread) error {
+	var ids []uint
+	for _, thread := range threads {
+		ids = append(ids, thread.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var mentions []models.CommentMention
+	if err := database.DB.Where("thread_id IN ?", ids).Order("id").Find(&mentions).Error; err != nil {
+		return err
+	}
+	byComment := map[uint][]string{}
+	for _, mention := range mentions {
+		byComment[mention.CommentID] = append(byComment[mention.CommentID], mention.UserID)
+	}
+
+	names := map[string]string{}
+	name := func(userID string) string {
+		if name, ok := names[userID]; ok {
+			return name
+		}
+		name := userID
+		if user, err := s.userService.GetUserByID(userID); err == nil {
+			name = user.Username
+			if user.Name != "" {
+				name = user.Name
+			}
+		}
+		names[userID] = name
+		return name
+	}
+	for _, thread := range threads {
+		thread.UserName = name(thread.UserID)
+		if thread.Comments == nil {
+			thread.Comments = []models.FileComment{}
+		}
+		for i := range thread.Comments {
+			thread.Comments[i].UserName = name(thread.Comments[i].UserID)
+			thread.Comments[i].Mentions = byComment[thread.Comments[i].ID]
+		}
+	}
+	return nil
+}
+
+// List returns the threads of a file, oldest first. Resolved threads are left out unless asked for.
+func (s *FileCommentService) List(repo *models.UserGitRepo, collectionName string, filePath string, userID string,
+	includeResolved bool) ([]*models.CommentThread, error) {
+	_, cleanPath, err := s.file(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAccess(repo, collectionName, cleanPath, userID); err != nil {
+		return nil, err
+	}
+	query := database.DB.Preload("Comments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("repo_id = ? AND collection_name = ? AND file_path = ?", repo.ID, collectionName, cleanPath)
+	if !includeResolved {
+		query = query.Where("resolved = ?", false)
+	}
+	var threads []*models.CommentThread
+	if err := query.Order("id").Find(&threads).Error; err != nil {
+		return nil, err
+	}
+	return threads, s.withDetails(threads...)
+}
+
+// anchor sets the anchor of a new thread from a line range or a heading of the file and quotes the anchored text
+func anchor(thread *models.CommentThread, content []byte, request models.CreateCommentThreadRequest) error {
+	lines := strings.Split(string(content), "\n")
+	switch {
+	case request.Heading != "":
+		if request.StartLine != 0 || request.EndLine != 0 {
+			return core.NewHTTPErrorStr(http.StatusBadRequest, "a thread is anchored to a line range or a heading, not both")
+		}
+		line := findHeading(content, request.Heading, 1)
+		if line == 0 {
+			return core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("%s has no heading %q", thread.FilePath, request.Heading))
+		}
+		thread.Heading = request.Heading
+		thread.StartLine, thread.EndLine = line, line
+	case request.StartLine != 0 || request.EndLine != 0:
+		start, end := request.StartLine, request.EndLine
+		if end == 0 {
+			end = start
+		}
+		if start < 1 || end < start || end > len(lines) {
+			return core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("lines %d to %d are not in %s, it has %d lines",
+				start, end, thread.FilePath, len(lines)))
+		}
+		thread.StartLine, thread.EndLine = start, end
+	default:
+		return nil
+	}
+	quote := strings.Join(lines[thread.StartLine-1:thread.EndLine], "\n")
+	if len(quote) > maxQuoteLength {
+		// cut on a rune boundary, a split multi-byte character is not valid UTF-8
+		cut := maxQuoteLength
+		for cut > 0 && !utf8.RuneStart(quote[cut]) {
+			cut--
+		}
+		quote = quote[:cut]
+	}
+	thread.Quote = quote
+	return nil
+}
+
+// findHeading returns the line of the heading with the given text nearest to a line, 0 when there is none
+func findHeading(content []byte, text string, near int) int {
+	found := 0
+	for _, heading := range md.Headings(content) {
+		if heading.Text != text {
+			continue
+		}
+		if found == 0 || abs(heading.Line-near) < abs(found-near) {
+			found = heading.Line
+		}
+	}
+	return found
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Create starts a thread on a file with its first comment
+func (s *FileCommentService) Create(repo *models.UserGitRepo, collectionName string, userID string,
+	request models.CreateCommentThreadRequest) (*models.CommentThread, error) {
+	content, cleanPath, err := s.file(repo, collectionName, request.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAccess(repo, collectionName, cleanPath, userID); err != nil {
+		return nil, err
+	}
+	thread := &models.CommentThread{
+		RepoID:         repo.ID,
+		CollectionName: collectionName,
+		FilePath:       cleanPath,
+		UserID:         userID,
+	}
+	if err := anchor(thread, content, request); err != nil {
+		return nil, err
+	}
+
+	var comment *models.FileComment
+	var mentioned []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(thread).Error; err != nil {
+			return err
+		}
+		comment, mentioned, err = s.addComment(tx, repo, thread, userID, request.Body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	thread.Comments = []models.FileComment{*comment}
+	s.notifyMentions(repo, thread, userID, mentioned)
+	return thread, s.withDetails(thread)
+}
+
+// Get returns a thread with its comments
+func (s *FileCommentService) Get(repo *models.UserGitRepo, threadID uint, userID string) (*models.CommentThread, error) {
+	thread, err := s.thread(repo, threadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return thread, s.withDetails(thread)
+}
+
+// Reply adds a comment to a thread, replying reopens a resolved thread
+func (s *FileCommentService) Reply(repo *models.UserGitRepo, threadID uint, userID string, body string) (*models.FileComment, error) {
+	thread, err := s.thread(repo, threadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var comment *models.FileComment
+	var mentioned []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if thread.Resolved {
+			err := tx.Model(thread).Updates(map[string]interface{}{"resolved": false, "resolved_by": "", "resolved_at": nil}).Error
+			if err != nil {
+				return err
+			}
+		}
+		comment, mentioned, err = s.addComment(tx, repo, thread, userID, body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notifyMentions(repo, thread, userID, mentioned)
+	thread.Comments = []models.FileComment{*comment}
+	if err := s.withDetails(thread); err != nil {
+		return nil, err
+	}
+	return &thread.Comments[0], nil
+}
+
+// addComment stores a comment and its mentions. Mentions name users by username, only those who may comment on
+// the file are recorded.
+func (s *FileCommentService) addComment(tx *gorm.DB, repo *models.UserGitRepo, thread *models.CommentThread, userID string,
+	body string) (*models.FileComment, []string, error) {
+	if strings.TrimSpace(body) == "" {
+		return nil, nil, core.NewHTTPErrorStr(http.StatusBadRequest, "comment must not be empty")
+	}
+	comment := &models.FileComment{ThreadID: thread.ID, UserID: userID, Body: body}
+	if err := tx.Create(comment).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var usernames []string
+	for _, match := range mentionRegex.FindAllStringSubmatch(body, -1) {
+		username := strings.TrimRight(match[1], ".")
+		if !slices.Contains(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+	if len(usernames) == 0 {
+		return comment, nil, nil
+	}
+	var users []models.User
+	if err := tx.Where("username IN ?", usernames).Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+	var mentioned []string
+	for _, user := range users {
+		if user.ID == userID || !s.mayComment(repo, thread.CollectionName, thread.FilePath, user.ID) {
+			continue
+		}
+		mention := models.CommentMention{ThreadID: thread.ID, CommentID: comment.ID, UserID: user.ID}
+		if err := tx.Create(&mention).Error; err != nil {
+			return nil, nil, err
+		}
+		mentioned = append(mentioned, user.ID)
+	}
+	return comment, mentioned, nil
+}
+
+// notifyMentions records an event for each mentioned user
+func (s *FileCommentService) notifyMentions(repo *models.UserGitRepo, thread *models.CommentThread, userID string, mentioned []string) {
+	author := userID
+	if user, err := s.userService.GetUserByID(userID); err == nil {
+		author = user.Username
+	}
+	for _, mentionedID := range mentioned {
+		_, err := s.eventService.CreateEvent(models.CreateEventRequest{
+			Level:        models.EventLevelInfo,
+			Source:       models.EventSourceUser,
+			Message:      "Mentioned in a comment",
+			Details:      fmt.Sprintf("%s mentioned you on %s in collection %s", author, thread.FilePath, thread.CollectionName),
+			UserID:       &mentionedID,
+			ResourceID:   &repo.ID,
+			ResourceType: "repository",
+		})
+		if err != nil {
+			log.Errorf("Failed to record the mention of %s: %v", mentionedID, err)
+		}
+	}
+}
+
+// SetResolved resolves or reopens a thread
+func (s *FileCommentService) SetResolved(repo *models.UserGitRepo, threadID uint, userID string, resolved bool) (*models.CommentThread, error) {
+	thread, err := s.thread(repo, threadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if thread.Resolved != resolved {
+		thread.Resolved = resolved
+		thread.ResolvedBy = ""
+		thread.ResolvedAt = nil
+		if resolved {
+			now := time.Now()
+			thread.ResolvedBy = userID
+			thread.ResolvedAt = &now
+		}
+		if err := database.DB.Omit("Comments").Save(thread).Error; err != nil {
+			return nil, err
+		}
+	}
+	return thread, s.withDetails(thread)
+}
+
+// Mentions returns the open threads in which the user was mentioned, most recently changed first. Threads of
+// files the user may no longer comment on are left out.
+func (s *FileCommentService) Mentions(userID string) ([]*models.CommentThread, error) {
+	var mentioned []*models.CommentThread
+	err := database.DB.Preload("Comments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("resolved = ? AND id IN (?)", false,
+		database.DB.Model(&models.CommentMention{}).Select("thread_id").Where("user_id = ?", userID)).
+		Order("updated_at DESC").Find(&mentioned).Error
+	if err != nil {
+		return nil, err
+	}
+
+	repos := map[uint]*models.UserGitRepo{}
+	threads := []*models.CommentThread{}
+	for _, thread := range mentioned {
+		repo, ok := repos[thread.RepoID]
+		if !ok {
+			if found, err := s.collectionService().GetRepo(thread.RepoID); err == nil {
+				repo = &found
+			}
+			repos[thread.RepoID] = repo
+		}
+		if repo != nil && s.mayComment(repo, thread.CollectionName, thread.FilePath, userID) {
+			threads = append(threads, thread)
+		}
+	}
+	return threads, s.withDetails(threads...)
+}
+
+// MovePath moves the threads of a moved file, or of the files below a moved directory
+func (s *FileCommentService) MovePath(repoID uint, collectionName string, oldPath string, newPath string) error {
+	oldPath, newPath = filepath.ToSlash(oldPath), filepath.ToSlash(newPath)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var threads []models.CommentThread
+		err := tx.Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+			repoID, collectionName, oldPath, likePrefix(oldPath+"/")).Find(&threads).Error
+		if err != nil {
+			return err
+		}
+		for _, thread := range threads {
+			thread.FilePath = newPath + strings.TrimPrefix(thread.FilePath, oldPath)
+			if err := tx.Save(&thread).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePath drops the threads of a deleted file, or of the files below a deleted directory
+func (s *FileCommentService) DeletePath(repoID uint, collectionName string, filePath string) error {
+	filePath = filepath.ToSlash(filePath)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		err := tx.Model(&models.CommentThread{}).Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+			repoID, collectionName, filePath, likePrefix(filePath+"/")).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		if err := tx.Where("thread_id IN ?", ids).Delete(&models.CommentMention{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("thread_id IN ?", ids).Delete(&models.FileComment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.CommentThread{}, ids).Error
+	})
+}
+
+// collectionFile finds the collection of a path relative to the repository root and returns the path relative to
+// it, the innermost collection wins
+func collectionFile(repo *models.UserGitRepo, collections []models.UserGitRepoCollection, repoPath string) (string, string, bool) {
+	fullPath := filepath.Join(repo.LocalPath, filepath.FromSlash(repoPath))
+	name, rel := "", ""
+	depth := -1
+	for _, collection := range collections {
+		r, err := filepath.Rel(collection.Path, fullPath)
+		if err != nil || r == "." || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if d := len(strings.Split(filepath.Clean(collection.Path), string(filepath.Separator))); d > depth {
+			name, rel, depth = collection.Name, filepath.ToSlash(r), d
+		}
+	}
+	return name, rel, depth >= 0
+}
+
+// Head returns the commit a repository is at, to re-map threads from after it changed. It is empty when the
+// repository has no threads, Remap then has nothing to do.
+func (s *FileCommentService) Head(ctx context.Context, repo *models.UserGitRepo) string {
+	var count int64
+	if err := database.DB.Model(&models.CommentThread{}).Where("repo_id = ?", repo.ID).Count(&count).Error; err != nil || count == 0 {
+		return ""
+	}
+	out, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Remap applies the changes between two revisions to the threads of a repository: threads follow renamed files,
+// are dropped with deleted ones, and their anchors are re-mapped through the changed lines. The caller must hold
+// a lock of the repository.
+func (s *FileCommentService) Remap(ctx context.Context, repo *models.UserGitRepo, from string, to string) error {
+	if from == "" {
+		return nil
+	}
+	out, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "diff", "-U0", "-M", "--no-color", "--no-ext-diff", from, to)
+	if err != nil {
+		return err
+	}
+	files := diff.Parse(out)
+	if len(files) == 0 {
+		return nil
+	}
+	collections, err := s.collectionService().GetCollectionsByRepo(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.OldPath == "" {
+			continue
+		}
+		collectionName, oldPath, ok := collectionFile(repo, collections, file.OldPath)
+		if !ok {
+			continue
+		}
+		if file.NewPath == "" {
+			if err := s.DeletePath(repo.ID, collectionName, oldPath); err != nil {
+				return err
+			}
+			continue
+		}
+		var threads []models.CommentThread
+		err := database.DB.Where("repo_id = ? AND collection_name = ? AND file_path = ?", repo.ID, collectionName, oldPath).
+			Find(&threads).Error
+		if err != nil {
+			return err
+		}
+		if len(threads) == 0 {
+			continue
+		}
+
+		newCollection, newPath, inCollection := collectionFile(repo, collections, file.NewPath)
+		var content []byte
+		if len(file.Hunks) > 0 && slices.ContainsFunc(threads, func(thread models.CommentThread) bool { return thread.Heading != "" }) {
+			if content, err = s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "show", to+":"+file.NewPath); err != nil {
+				return err
+			}
+		}
+		for _, thread := range threads {
+			if inCollection {
+				thread.CollectionName, thread.FilePath = newCollection, newPath
+			}
+			remapAnchor(&thread, file.Hunks, content)
+			if err := database.DB.Omit("Comments").Save(&thread).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remapAnchor moves the anchor of a thread through the changed lines of its file. The anchor covers the lines
+// that are left of the range, a heading is looked up again in the new content. A thread is outdated once none of
+// its lines is left unchanged or its heading is gone.
+func remapAnchor(thread *models.CommentThread, hunks []diff.Hunk, content []byte) {
+	if thread.StartLine == 0 || len(hunks) == 0 {
+		return
+	}
+	first, last, unchanged := 0, 0, false
+	for line := thread.StartLine; line <= thread.EndLine; line++ {
+		mapped, exact := diff.MapLine(hunks, line)
+		if mapped == 0 {
+			continue
+		}
+		if first == 0 {
+			first = mapped
+		}
+		last = max(last, mapped)
+		unchanged = unchanged || exact
+	}
+	if first != 0 {
+		thread.StartLine, thread.EndLine = first, last
+	}
+	if thread.Heading != "" {
+		line := findHeading(content, thread.Heading, thread.StartLine)
+		unchanged = line != 0
+		if line != 0 {
+			thread.StartLine, thread.EndLine = line, line
+		}
+	}
+	if !unchanged {
+		thread.Outdated = true
+	}
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/database"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gorm.io/gorm"
+)
+
+// FileDraftStatusService keeps the draft files of each collection, as read from their front matter, so that
+// listings do not parse every file
+type FileDraftStatusService struct {
+	BaseService
+}
+
+func (s *FileDraftStatusService) Init(ctx *core.APPContext) {
+	s.InitService("fileDraftStatusService", ctx, s)
+}
+
+// GetDraftStatus returns the draft files of a collection by path
+func (s *FileDraftStatusService) GetDraftStatus(repoId uint, collectionName string) (map[string]bool, error) {
+	m := make(map[string]bool)
+
+	var status []models.FileDraftStatus
+	err := database.DB.Where("repo_id = ? AND collection_name = ?", repoId, collectionName).Find(&status).Error
+	if err != nil {
+		log.Errorf("Failed to get draft status: %v", err)
+		return m, nil
+	}
+	for _, v := range status {
+		m[v.FilePath] = v.Draft
+	}
+	return m, nil
+}
+
+// Reconcile replaces the draft files recorded for a collection with the given ones
+func (s *FileDraftStatusService) Reconcile(repoId uint, collectionName string, drafts map[string]bool) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var status []models.FileDraftStatus
+		if err := tx.Where("repo_id = ? AND collection_name = ?", repoId, collectionName).Find(&status).Error; err != nil {
+			return err
+		}
+		recorded := make(map[string]bool, len(status))
+		for _, v := range status {
+			if drafts[v.FilePath] {
+				recorded[v.FilePath] = true
+				continue
+			}
+			if err := tx.Delete(&v).Error; err != nil {
+				return err
+			}
+		}
+		for filePath, draft := range drafts {
+			if !draft || recorded[filePath] {
+				continue
+			}
+			row := models.FileDraftStatus{RepoID: repoId, CollectionName: collectionName, FilePath: filePath, Draft: true}
+			if err := tx.Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// likePrefix escapes the LIKE wildcards of a path prefix
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
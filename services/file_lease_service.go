@@ -0,0 +1,270 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/database"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLeaseTTL    = 2 * time.Minute
+	defaultMaxLeaseTTL = 15 * time.Minute
+)
+
+// FileLeaseService keeps the edit leases editors hold on collection files, so that others see who is
+// editing a file and do not overwrite it
+type FileLeaseService struct {
+	BaseService
+	userService *UserService
+	defaultTTL  time.Duration
+	maxTTL      time.Duration
+	warnOnly    bool
+}
+
+func (s *FileLeaseService) Init(ctx *core.APPContext) {
+	s.InitService("fileLeaseService", ctx, s)
+	s.userService = ctx.MustGetService("userService").(*UserService)
+	s.defaultTTL = defaultLeaseTTL
+	s.maxTTL = defaultMaxLeaseTTL
+	if ctx.Config.Lease.DefaultTTLSeconds > 0 {
+		s.defaultTTL = time.Duration(ctx.Config.Lease.DefaultTTLSeconds) * time.Second
+	}
+	if ctx.Config.Lease.MaxTTLSeconds > 0 {
+		s.maxTTL = time.Duration(ctx.Config.Lease.MaxTTLSeconds) * time.Second
+	}
+	s.warnOnly = ctx.Config.Lease.WarnOnly
+}
+
+// ttl returns the lease duration for a requested number of seconds, zero means the default
+func (s *FileLeaseService) ttl(seconds int) time.Duration {
+	if seconds <= 0 {
+		return s.defaultTTL
+	}
+	return min(time.Duration(seconds)*time.Second, s.maxTTL)
+}
+
+// withUserNames fills in the display names of the editors
+func (s *FileLeaseService) withUserNames(leases ...*models.FileEditLease) {
+	names := map[string]string{}
+	for _, lease := range leases {
+		name, ok := names[lease.UserID]
+		if !ok {
+			name = lease.UserID
+			if user, err := s.userService.GetUserByID(lease.UserID); err == nil {
+				name = user.Username
+				if user.Name != "" {
+					name = user.Name
+				}
+			}
+			names[lease.UserID] = name
+		}
+		lease.UserName = name
+	}
+}
+
+func leaseHeldError(lease *models.FileEditLease) error {
+	return core.NewHTTPErrorStr(http.StatusLocked, fmt.Sprintf("%s is %s", lease.FilePath, lease.Presence()))
+}
+
+// Acquire takes a lease on a file for the user. A lease of someone else is never taken over, one the user holds
+// in another session only with force. Expired leases are replaced.
+func (s *FileLeaseService) Acquire(repoID uint, collectionName string, filePath string, userID string, ttlSeconds int, force bool) (*models.FileEditLease, error) {
+	cleanPath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	filePath = filepath.ToSlash(cleanPath)
+	now := time.Now()
+	lease := &models.FileEditLease{
+		ID:             uuid.New().String(),
+		RepoID:         repoID,
+		CollectionName: collectionName,
+		FilePath:       filePath,
+		UserID:         userID,
+		ExpiresAt:      now.Add(s.ttl(ttlSeconds)),
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var existing models.FileEditLease
+		err := tx.Where("repo_id = ? AND collection_name = ? AND file_path = ?", repoID, collectionName, filePath).First(&existing).Error
+		if err == nil {
+			if existing.ExpiresAt.After(now) && (existing.UserID != userID || !force) {
+				s.withUserNames(&existing)
+				if existing.UserID == userID {
+					return core.NewHTTPErrorStr(http.StatusLocked,
+						fmt.Sprintf("you are editing %s in another session since %s, take the lease over with force",
+							filePath, existing.CreatedAt.UTC().Format("2006-01-02 15:04 MST")))
+				}
+				return leaseHeldError(&existing)
+			}
+			if err := tx.Delete(&existing).Error; err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return tx.Create(lease).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.withUserNames(lease)
+	return lease, nil
+}
+
+// Renew extends a lease of the user, a lapsed lease can be renewed as long as nobody else took the file
+func (s *FileLeaseService) Renew(leaseID string, userID string, ttlSeconds int) (*models.FileEditLease, error) {
+	var lease models.FileEditLease
+	if err := database.DB.First(&lease, "id = ?", leaseID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, core.NewHTTPErrorStr(http.StatusNotFound, "the lease was released, acquire a new one")
+		}
+		return nil, err
+	}
+	if lease.UserID != userID {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, "the lease is held by another user")
+	}
+	lease.ExpiresAt = time.Now().Add(s.ttl(ttlSeconds))
+	if err := database.DB.Save(&lease).Error; err != nil {
+		return nil, err
+	}
+	s.withUserNames(&lease)
+	return &lease, nil
+}
+
+// Release gives a lease up. Leases of others can only be released by admins, with force.
+func (s *FileLeaseService) Release(leaseID string, userID string, force bool) error {
+	var lease models.FileEditLease
+	if err := database.DB.First(&lease, "id = ?", leaseID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if lease.UserID != userID {
+		if !force {
+			return core.NewHTTPErrorStr(http.StatusForbidden, "the lease is held by another user, admins can release it with force")
+		}
+		user, err := s.userService.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		if !user.HasRole(models.RoleAdmin) {
+			return core.NewHTTPErrorStr(http.StatusForbidden, "only admins can release the leases of other users")
+		}
+		log.Infof("user %s force-released the lease of %s on %s", userID, lease.UserID, lease.FilePath)
+	}
+	return database.DB.Delete(&lease).Error
+}
+
+// List returns the active leases of a collection
+func (s *FileLeaseService) List(repoID uint, collectionName string) ([]*models.FileEditLease, error) {
+	var leases []*models.FileEditLease
+	err := database.DB.Where("repo_id = ? AND collection_name = ? AND expires_at > ?", repoID, collectionName, time.Now()).
+		Order("file_path").Find(&leases).Error
+	if err != nil {
+		return nil, err
+	}
+	s.withUserNames(leases...)
+	return leases, nil
+}
+
+// ActiveLeases returns the active leases of a collection by file path, for file listings
+func (s *FileLeaseService) ActiveLeases(repoID uint, collectionName string) map[string]*models.FileEditLease {
+	m := map[string]*models.FileEditLease{}
+	leases, err := s.List(repoID, collectionName)
+	if err != nil {
+		log.Errorf("Failed to get edit leases: %v", err)
+		return m
+	}
+	for _, lease := range leases {
+		m[filepath.FromSlash(lease.FilePath)] = lease
+	}
+	return m
+}
+
+// Conflict returns an active lease of someone else on a file, or on a file below a directory. A lease of the
+// user conflicts too when the change names another of the user's leases.
+func (s *FileLeaseService) Conflict(repoID uint, collectionName string, filePath string, userID string, leaseID string) (*models.FileEditLease, error) {
+	filePath = filepath.ToSlash(filePath)
+	var leases []*models.FileEditLease
+	err := database.DB.Where("repo_id = ? AND collection_name = ? AND expires_at > ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+		repoID, collectionName, time.Now(), filePath, likePrefix(filePath+"/")).Find(&leases).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, lease := range leases {
+		if lease.UserID != userID || (leaseID != "" && lease.ID != leaseID) {
+			s.withUserNames(lease)
+			return lease, nil
+		}
+	}
+	return nil, nil
+}
+
+// CheckWrite refuses a change of a file while someone else holds a lease on it, unless leases only warn
+func (s *FileLeaseService) CheckWrite(repoID uint, collectionName string, filePath string, userID string, leaseID string) error {
+	if s.warnOnly {
+		return nil
+	}
+	lease, err := s.Conflict(repoID, collectionName, filePath, userID, leaseID)
+	if err != nil {
+		return err
+	}
+	if lease != nil {
+		return leaseHeldError(lease)
+	}
+	return nil
+}
+
+// Warning describes the lease of someone else on a file when leases only warn, it is empty otherwise
+func (s *FileLeaseService) Warning(repoID uint, collectionName string, filePath string, userID string, leaseID string) string {
+	if !s.warnOnly {
+		return ""
+	}
+	lease, err := s.Conflict(repoID, collectionName, filePath, userID, leaseID)
+	if err != nil {
+		log.Errorf("Failed to check edit leases: %v", err)
+		return ""
+	}
+	if lease == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s is %s", lease.FilePath, lease.Presence())
+}
+
+// MovePath moves the leases on a moved file, or on the files below a moved directory
+func (s *FileLeaseService) MovePath(repoID uint, collectionName string, oldPath string, newPath string) error {
+	oldPath, newPath = filepath.ToSlash(oldPath), filepath.ToSlash(newPath)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var leases []models.FileEditLease
+		err := tx.Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+			repoID, collectionName, oldPath, likePrefix(oldPath+"/")).Find(&leases).Error
+		if err != nil {
+			return err
+		}
+		for _, lease := range leases {
+			lease.FilePath = newPath + strings.TrimPrefix(lease.FilePath, oldPath)
+			if err := tx.Save(&lease).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePath drops the leases on a deleted file, or on the files below a deleted directory
+func (s *FileLeaseService) DeletePath(repoID uint, collectionName string, filePath string) error {
+	filePath = filepath.ToSlash(filePath)
+	return database.DB.Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+		repoID, collectionName, filePath, likePrefix(filePath+"/")).Delete(&models.FileEditLease{}).Error
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
+)
+
+// fileVersion is the version of a file as long as its size and modification time are unchanged
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// fileVersionCache remembers the blob sha of files so that listings do not read every file
+type fileVersionCache struct {
+	mutex    sync.Mutex
+	versions map[string]fileVersion
+}
+
+func newFileVersionCache() *fileVersionCache {
+	return &fileVersionCache{versions: map[string]fileVersion{}}
+}
+
+// fileETag returns the git blob sha of a file with the size and modification time stat reported
+func (c *fileVersionCache) fileETag(fullPath string, size int64, modTime time.Time) (string, error) {
+	c.mutex.Lock()
+	version, ok := c.versions[fullPath]
+	c.mutex.Unlock()
+	if ok && version.size == size && version.modTime.Equal(modTime) {
+		return version.etag, nil
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", err
+	}
+	etag := git.BlobSHA(content)
+	c.mutex.Lock()
+	c.versions[fullPath] = fileVersion{size: size, modTime: modTime, etag: etag}
+	c.mutex.Unlock()
+	return etag, nil
+}
+
+// forget drops the cached versions of a path and everything below it, files written within the
+// resolution of the modification time would otherwise keep their old version
+func (c *fileVersionCache) forget(fullPath string) {
+	prefix := fullPath + string(filepath.Separator)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for p := range c.versions {
+		if p == fullPath || strings.HasPrefix(p, prefix) {
+			delete(c.versions, p)
+		}
+	}
+}
+
+// pathETag returns the version of a file or directory, empty when it does not exist. The version of a
+// directory is derived from the paths and versions of all files below it.
+func (c *fileVersionCache) pathETag(fullPath string) (string, error) {
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return c.fileETag(fullPath, info.Size(), info.ModTime())
+	}
+
+	h := sha1.New()
+	err = filepath.WalkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		etag, err := c.fileETag(p, info.Size(), info.ModTime())
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(fullPath, p)
+		fmt.Fprintf(h, "%s %s\n", filepath.ToSlash(rel), etag)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkVersion verifies that a write is based on the current version of a path. An empty ifMatch skips
+// the check unless required is set and the path exists, core.AnyVersion only requires the path to exist.
+// mustNotExist, from If-None-Match: *, requires the path not to exist.
+func (c *fileVersionCache) checkVersion(fullPath string, displayPath string, ifMatch string, mustNotExist bool, required bool) error {
+	current, err := c.pathETag(fullPath)
+	if err != nil {
+		return err
+	}
+	switch {
+	case mustNotExist:
+		if current != "" {
+			return &core.PreconditionFailedError{Path: displayPath, ETag: current}
+		}
+		return nil
+	case ifMatch == "":
+		if required && current != "" {
+			return core.NewHTTPErrorStr(http.StatusPreconditionRequired,
+				fmt.Sprintf("If-Match is required to change %s, send the ETag it was read with", displayPath))
+		}
+		return nil
+	case ifMatch == core.AnyVersion:
+		if current == "" {
+			return &core.PreconditionFailedError{Path: displayPath}
+		}
+		return nil
+	case ifMatch != current:
+		return &core.PreconditionFailedError{Path: displayPath, ETag: current}
+	}
+	return nil
+}
@@ -0,0 +1,669 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/database"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gorm.io/gorm"
+)
+
+// FileWorkflowService keeps the editorial state of the entries of collections with a workflow, the reviewers
+// assigned to them and the history of their transitions and reviews
+type FileWorkflowService struct {
+	BaseService
+	userService  *UserService
+	eventService *EventService
+}
+
+func (s *FileWorkflowService) Init(ctx *core.APPContext) {
+	s.InitService("fileWorkflowService", ctx, s)
+	s.userService = ctx.MustGetService("userService").(*UserService)
+	s.eventService = ctx.MustGetService("eventService").(*EventService)
+}
+
+// collectionService is looked up when used, it depends on this service
+func (s *FileWorkflowService) collectionService() *UserGitRepoCollectionService {
+	return s.ctx.MustGetService("userGitRepoCollectionService").(*UserGitRepoCollectionService)
+}
+
+// workflowOf returns the workflow of a collection, nil when it has none. The items of list collections share a
+// file and have no workflow.
+func workflowOf(collection models.UserGitRepoCollection) *models.Workflow {
+	if collection.Workflow == nil || len(collection.Workflow.States) == 0 || collection.Entries == models.EntriesList {
+		return nil
+	}
+	return collection.Workflow
+}
+
+// approvalsMissingError tells that an entry lacks the approvals its workflow requires before it is published
+type approvalsMissingError struct {
+	path      string
+	approvals int
+	required  int
+}
+
+func (e *approvalsMissingError) Error() string {
+	return fmt.Sprintf("%s has %d of the %d approvals required before it is published", e.path, e.approvals, e.required)
+}
+
+func (e *approvalsMissingError) Unwrap() error {
+	return core.NewHTTPErrorStr(http.StatusConflict, e.Error())
+}
+
+func checkApprovals(filePath string, status *models.WorkflowStatus) error {
+	if status.Approvals >= status.RequiredApprovals {
+		return nil
+	}
+	return &approvalsMissingError{path: filePath, approvals: status.Approvals, required: status.RequiredApprovals}
+}
+
+// WorkflowStatuses are the workflow states of the entries of a collection by file path, for file listings
+type WorkflowStatuses struct {
+	workflow *models.Workflow
+	statuses map[string]*models.WorkflowStatus
+}
+
+// Of returns the state of a file, entries without a recorded state are in the initial one. Directories and the
+// files of collections without a workflow have none.
+func (w WorkflowStatuses) Of(filePath string, isDir bool) *models.WorkflowStatus {
+	if w.workflow == nil || isDir {
+		return nil
+	}
+	if status, ok := w.statuses[filepath.FromSlash(filePath)]; ok {
+		return status
+	}
+	return &models.WorkflowStatus{State: w.workflow.InitialState(), RequiredApprovals: w.workflow.RequiredApprovals}
+}
+
+// Statuses returns the workflow states of the entries of a collection
+func (s *FileWorkflowService) Statuses(repoID uint, collection models.UserGitRepoCollection) WorkflowStatuses {
+	statuses := WorkflowStatuses{workflow: workflowOf(collection), statuses: map[string]*models.WorkflowStatus{}}
+	if statuses.workflow == nil {
+		return statuses
+	}
+	var workflows []models.FileWorkflow
+	if err := database.DB.Where("repo_id = ? AND collection_name = ?", repoID, collection.Name).Find(&workflows).Error; err != nil {
+		log.Errorf("Failed to get the workflow states of collection %s: %v", collection.Name, err)
+		return statuses
+	}
+	reviewers, reviews, err := s.reviewsOf(workflows...)
+	if err != nil {
+		log.Errorf("Failed to get the reviews of collection %s: %v", collection.Name, err)
+		return statuses
+	}
+	for i := range workflows {
+		wf := &workflows[i]
+		normalizeState(statuses.workflow, wf)
+		statuses.statuses[filepath.FromSlash(wf.FilePath)] = workflowStatus(statuses.workflow, wf, reviewers[wf.ID], reviews[wf.ID])
+	}
+	return statuses
+}
+
+// normalizeState moves an entry whose state was removed from the workflow to the initial state
+func normalizeState(workflow *models.Workflow, wf *models.FileWorkflow) {
+	if !workflow.HasState(wf.State) {
+		wf.State = workflow.InitialState()
+	}
+}
+
+// reviewsOf loads the reviewers of workflows and their reviews in order, by workflow ID
+func (s *FileWorkflowService) reviewsOf(workflows ...models.FileWorkflow) (map[uint][]models.FileReviewer, map[uint][]models.FileWorkflowHistory, error) {
+	reviewers := map[uint][]models.FileReviewer{}
+	reviews := map[uint][]models.FileWorkflowHistory{}
+	var ids []uint
+	for _, wf := range workflows {
+		if wf.ID != 0 {
+			ids = append(ids, wf.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return reviewers, reviews, nil
+	}
+
+	var assigned []models.FileReviewer
+	if err := database.DB.Where("workflow_id IN ?", ids).Order("id").Find(&assigned).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, reviewer := range assigned {
+		reviewers[reviewer.WorkflowID] = append(reviewers[reviewer.WorkflowID], reviewer)
+	}
+	var history []models.FileWorkflowHistory
+	err := database.DB.Where("workflow_id IN ? AND action IN ?", ids,
+		[]models.WorkflowAction{models.WorkflowActionApprove, models.WorkflowActionRequestChanges}).Order("id").Find(&history).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, review := range history {
+		reviews[review.WorkflowID] = append(reviews[review.WorkflowID], review)
+	}
+	return reviewers, reviews, nil
+}
+
+// workflowStatus counts the approvals of the assigned reviewers in the current round, only the latest review of
+// each reviewer counts. The decisions of the reviewers are filled in.
+func workflowStatus(workflow *models.Workflow, wf *models.FileWorkflow, reviewers []models.FileReviewer,
+	reviews []models.FileWorkflowHistory) *models.WorkflowStatus {
+	decisions := map[string]models.WorkflowAction{}
+	for _, review := range reviews {
+		if !review.CreatedAt.Before(wf.RoundStartedAt) {
+			decisions[review.UserID] = review.Action
+		}
+	}
+	status := &models.WorkflowStatus{State: wf.State, RequiredApprovals: workflow.RequiredApprovals}
+	for i := range reviewers {
+		reviewers[i].Decision = decisions[reviewers[i].UserID]
+		status.Reviewers = append(status.Reviewers, reviewers[i].UserID)
+		switch reviewers[i].Decision {
+		case models.WorkflowActionApprove:
+			status.Approvals++
+		case models.WorkflowActionRequestChanges:
+			status.ChangesRequested = true
+		}
+	}
+	return status
+}
+
+// file returns the collection and workflow of an entry with its cleaned path, in the form it is stored with
+func (s *FileWorkflowService) file(repo *models.UserGitRepo, collectionName string, filePath string) (models.UserGitRepoCollection, *models.Workflow, string, error) {
+	collection, err := s.collectionService().GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return collection, nil, "", err
+	}
+	workflow := workflowOf(collection)
+	if workflow == nil {
+		return collection, nil, "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("collection %s has no workflow", collectionName))
+	}
+	cleanPath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return collection, nil, "", err
+	}
+	fi, err := os.Stat(filepath.Join(collection.Path, cleanPath))
+	if os.IsNotExist(err) {
+		return collection, nil, "", core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("%s does not exist", cleanPath))
+	}
+	if err != nil {
+		return collection, nil, "", err
+	}
+	if fi.IsDir() || !collection.Format.HasExtension(cleanPath) {
+		return collection, nil, "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("%s is not an entry of collection %s", cleanPath, collectionName))
+	}
+	return collection, workflow, filepath.ToSlash(cleanPath), nil
+}
+
+// entry returns the workflow of a file. A file without one gets a new, unsaved one in the initial state.
+func (s *FileWorkflowService) entry(repoID uint, collectionName string, workflow *models.Workflow, filePath string) (*models.FileWorkflow, error) {
+	var wf models.FileWorkflow
+	err := database.DB.Where("repo_id = ? AND collection_name = ? AND file_path = ?", repoID, collectionName, filePath).First(&wf).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.FileWorkflow{
+			RepoID:         repoID,
+			CollectionName: collectionName,
+			FilePath:       filePath,
+			State:          workflow.InitialState(),
+			RoundStartedAt: time.Now(),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	normalizeState(workflow, &wf)
+	return &wf, nil
+}
+
+func isReviewer(reviewers []models.FileReviewer, userID string) bool {
+	return slices.ContainsFunc(reviewers, func(reviewer models.FileReviewer) bool {
+		return reviewer.UserID == userID
+	})
+}
+
+// mayMake tells whether the user may make a transition. Without roles only the repository owner may.
+func (s *FileWorkflowService) mayMake(repo *models.UserGitRepo, transition models.WorkflowTransition, reviewers []models.FileReviewer, userID string) bool {
+	if len(transition.Roles) == 0 {
+		return userID == repo.UserID
+	}
+	var user *models.User
+	for _, role := range transition.Roles {
+		switch role {
+		case models.WorkflowRoleOwner:
+			if userID == repo.UserID {
+				return true
+			}
+		case models.WorkflowRoleReviewer:
+			if isReviewer(reviewers, userID) {
+				return true
+			}
+		default:
+			if user == nil {
+				var err error
+				if user, err = s.userService.GetUserByID(userID); err != nil {
+					return false
+				}
+			}
+			if user.HasRole(role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withUserNames fills in the display names of reviewers and of the users in a history
+func (s *FileWorkflowService) withUserNames(reviewers []models.FileReviewer, history []models.FileWorkflowHistory) {
+	names := map[string]string{}
+	name := func(userID string) string {
+		if name, ok := names[userID]; ok {
+			return name
+		}
+		name := userID
+		if user, err := s.userService.GetUserByID(userID); err == nil {
+			name = user.Username
+			if user.Name != "" {
+				name = user.Name
+			}
+		}
+		names[userID] = name
+		return name
+	}
+	for i := range reviewers {
+		reviewers[i].UserName = name(reviewers[i].UserID)
+	}
+	for i := range history {
+		history[i].UserName = name(history[i].UserID)
+	}
+}
+
+func (s *FileWorkflowService) recordEvent(repo *models.UserGitRepo, userID string, message string, details string, comment string) {
+	if comment != "" {
+		details += ": " + comment
+	}
+	_, err := s.eventService.CreateEvent(models.CreateEventRequest{
+		Level:        models.EventLevelInfo,
+		Source:       models.EventSourceUser,
+		Message:      message,
+		Details:      details,
+		UserID:       &userID,
+		ResourceID:   &repo.ID,
+		ResourceType: "repository",
+	})
+	if err != nil {
+		log.Errorf("Failed to record workflow event %q: %v", message, err)
+	}
+}
+
+// Get returns the workflow of an entry to the repository owner or one of its reviewers, with the states the user
+// may move it to and its history
+func (s *FileWorkflowService) Get(repo *models.UserGitRepo, collectionName string, filePath string, userID string) (*models.FileWorkflowResponse, error) {
+	_, workflow, cleanPath, err := s.file(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := s.entry(repo.ID, collectionName, workflow, cleanPath)
+	if err != nil {
+		return nil, err
+	}
+	reviewers, reviews, err := s.reviewsOf(*wf)
+	if err != nil {
+		return nil, err
+	}
+	if userID != repo.UserID && !isReviewer(reviewers[wf.ID], userID) {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, fmt.Sprintf("you are not a reviewer of %s", cleanPath))
+	}
+
+	response := &models.FileWorkflowResponse{
+		Path:        cleanPath,
+		Status:      workflowStatus(workflow, wf, reviewers[wf.ID], reviews[wf.ID]),
+		Publish:     workflow.PublishState(),
+		Reviewers:   core.EnsureNonNilArr(reviewers[wf.ID]),
+		Transitions: []string{},
+		History:     []models.FileWorkflowHistory{},
+	}
+	for _, state := range workflow.States {
+		if transition, ok := workflow.Transition(wf.State, state); ok && s.mayMake(repo, transition, reviewers[wf.ID], userID) {
+			response.Transitions = append(response.Transitions, state)
+		}
+	}
+	if wf.ID != 0 {
+		if err := database.DB.Where("workflow_id = ?", wf.ID).Order("id desc").Find(&response.History).Error; err != nil {
+			return nil, err
+		}
+	}
+	s.withUserNames(response.Reviewers, response.History)
+	return response, nil
+}
+
+// Transition moves an entry to another state. Entering the publish state needs the required approvals and
+// clears the draft key of the entry, leaving it sets the key again. In the folder mode of a schedule the entry is
+// moved out of the drafts folder and back into it instead. Entering the initial state starts a new
+// round of reviews. ifMatch is the version of the entry the client saw, without one the draft key is changed on
+// any version. The caller must hold the write lock of the repository.
+func (s *FileWorkflowService) Transition(ctx context.Context, repo *models.UserGitRepo, collectionName string, filePath string,
+	userID string, to string, comment string, ifMatch string) (*models.WorkflowStatus, error) {
+	collection, workflow, cleanPath, err := s.file(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !workflow.HasState(to) {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("%s is not a state of the workflow of collection %s", to, collectionName))
+	}
+	wf, err := s.entry(repo.ID, collectionName, workflow, cleanPath)
+	if err != nil {
+		return nil, err
+	}
+	reviewers, reviews, err := s.reviewsOf(*wf)
+	if err != nil {
+		return nil, err
+	}
+	from := wf.State
+	transition, ok := workflow.Transition(from, to)
+	if !ok {
+		return nil, core.NewHTTPErrorStr(http.StatusConflict, fmt.Sprintf("%s cannot move from %s to %s", cleanPath, from, to))
+	}
+	if !s.mayMake(repo, transition, reviewers[wf.ID], userID) {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, fmt.Sprintf("you may not move %s from %s to %s", cleanPath, from, to))
+	}
+
+	if ifMatch == "" {
+		ifMatch = core.AnyVersion
+	}
+	condition := WriteCondition{IfMatch: ifMatch, UserID: userID}
+	settings := scheduleOf(collection)
+	if to == workflow.PublishState() {
+		if err := checkApprovals(cleanPath, workflowStatus(workflow, wf, reviewers[wf.ID], reviews[wf.ID])); err != nil {
+			return nil, err
+		}
+		if settings.Mode == models.ScheduleModeFolder {
+			err = s.moveEntry(ctx, repo, collectionName, wf, entryPathOf(settings, cleanPath), condition)
+		} else {
+			_, err = s.collectionService().SetDraft(ctx, repo, collection, cleanPath, false, condition)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if from == workflow.PublishState() {
+		if settings.Mode == models.ScheduleModeFolder {
+			if !inDrafts(settings, cleanPath) {
+				err = s.moveEntry(ctx, repo, collectionName, wf, path.Join(settings.Drafts, cleanPath), condition)
+			}
+		} else {
+			_, err = s.collectionService().SetDraft(ctx, repo, collection, cleanPath, true, condition)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wf.State = to
+	if to == workflow.InitialState() {
+		wf.RoundStartedAt = time.Now()
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(wf).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.FileWorkflowHistory{
+			WorkflowID: wf.ID,
+			UserID:     userID,
+			Action:     models.WorkflowActionTransition,
+			FromState:  from,
+			ToState:    to,
+			Comment:    comment,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.recordEvent(repo, userID, fmt.Sprintf("Workflow moved to %s", to),
+		fmt.Sprintf("%s in collection %s moved from %s to %s", wf.FilePath, collectionName, from, to), comment)
+	return workflowStatus(workflow, wf, reviewers[wf.ID], reviews[wf.ID]), nil
+}
+
+// moveEntry moves the file of an entry in a folder mode collection, the workflow follows it
+func (s *FileWorkflowService) moveEntry(ctx context.Context, repo *models.UserGitRepo, collectionName string,
+	wf *models.FileWorkflow, newPath string, condition WriteCondition) error {
+	if newPath == wf.FilePath {
+		return nil
+	}
+	if err := s.collectionService().RenameFile(ctx, repo, collectionName, wf.FilePath, newPath, condition); err != nil {
+		return err
+	}
+	wf.FilePath = newPath
+	return nil
+}
+
+// AssignReviewers replaces the reviewers of an entry with the given users, only the repository owner assigns
+// them. The reviews of removed reviewers no longer count.
+func (s *FileWorkflowService) AssignReviewers(repo *models.UserGitRepo, collectionName string, filePath string,
+	userID string, reviewerIDs []string) ([]models.FileReviewer, error) {
+	_, workflow, cleanPath, err := s.file(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if userID != repo.UserID {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, "only the owner of the repository assigns reviewers")
+	}
+	var wanted []string
+	for _, id := range reviewerIDs {
+		if id == "" || slices.Contains(wanted, id) {
+			continue
+		}
+		if _, err := s.userService.GetUserByID(id); err != nil {
+			return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("user %s does not exist", id))
+		}
+		wanted = append(wanted, id)
+	}
+	wf, err := s.entry(repo.ID, collectionName, workflow, cleanPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var assigned, removed []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(wf).Error; err != nil {
+			return err
+		}
+		var current []models.FileReviewer
+		if err := tx.Where("workflow_id = ?", wf.ID).Find(&current).Error; err != nil {
+			return err
+		}
+		for _, reviewer := range current {
+			if slices.Contains(wanted, reviewer.UserID) {
+				continue
+			}
+			if err := tx.Delete(&reviewer).Error; err != nil {
+				return err
+			}
+			removed = append(removed, reviewer.UserID)
+		}
+		for _, id := range wanted {
+			if isReviewer(current, id) {
+				continue
+			}
+			if err := tx.Create(&models.FileReviewer{WorkflowID: wf.ID, UserID: id, AssignedBy: userID}).Error; err != nil {
+				return err
+			}
+			assigned = append(assigned, id)
+		}
+		for _, change := range []struct {
+			action models.WorkflowAction
+			users  []string
+		}{{models.WorkflowActionUnassign, removed}, {models.WorkflowActionAssign, assigned}} {
+			for _, id := range change.users {
+				history := models.FileWorkflowHistory{WorkflowID: wf.ID, UserID: userID, Action: change.action, Reviewer: id}
+				if err := tx.Create(&history).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reviewers, reviews, err := s.reviewsOf(*wf)
+	if err != nil {
+		return nil, err
+	}
+	// Fills in the decisions of the reviewers
+	workflowStatus(workflow, wf, reviewers[wf.ID], reviews[wf.ID])
+	s.withUserNames(reviewers[wf.ID], nil)
+	if len(assigned) > 0 || len(removed) > 0 {
+		var names []string
+		for _, reviewer := range reviewers[wf.ID] {
+			names = append(names, reviewer.UserName)
+		}
+		s.recordEvent(repo, userID, "Workflow reviewers assigned",
+			fmt.Sprintf("%s in collection %s is reviewed by %s", cleanPath, collectionName, strings.Join(names, ", ")), "")
+	}
+	return core.EnsureNonNilArr(reviewers[wf.ID]), nil
+}
+
+// Review records the approval of an entry, or a request for changes, by one of its assigned reviewers
+func (s *FileWorkflowService) Review(repo *models.UserGitRepo, collectionName string, filePath string, userID string,
+	decision models.WorkflowAction, comment string) (*models.WorkflowStatus, error) {
+	if decision != models.WorkflowActionApprove && decision != models.WorkflowActionRequestChanges {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("invalid decision %s", decision))
+	}
+	_, workflow, cleanPath, err := s.file(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := s.entry(repo.ID, collectionName, workflow, cleanPath)
+	if err != nil {
+		return nil, err
+	}
+	reviewers, reviews, err := s.reviewsOf(*wf)
+	if err != nil {
+		return nil, err
+	}
+	if !isReviewer(reviewers[wf.ID], userID) {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, fmt.Sprintf("you are not a reviewer of %s", cleanPath))
+	}
+	if wf.State == workflow.PublishState() {
+		return nil, core.NewHTTPErrorStr(http.StatusConflict, fmt.Sprintf("%s is already published", cleanPath))
+	}
+
+	review := models.FileWorkflowHistory{WorkflowID: wf.ID, UserID: userID, Action: decision, Comment: comment}
+	if err := database.DB.Create(&review).Error; err != nil {
+		return nil, err
+	}
+	message := "Workflow entry approved"
+	if decision == models.WorkflowActionRequestChanges {
+		message = "Workflow changes requested"
+	}
+	s.recordEvent(repo, userID, message, fmt.Sprintf("%s in collection %s", cleanPath, collectionName), comment)
+	return workflowStatus(workflow, wf, reviewers[wf.ID], append(reviews[wf.ID], review)), nil
+}
+
+// CheckPublish fails while an entry lacks the approvals its workflow requires before it is published
+func (s *FileWorkflowService) CheckPublish(repoID uint, collection models.UserGitRepoCollection, filePath string) error {
+	workflow := workflowOf(collection)
+	if workflow == nil || workflow.RequiredApprovals <= 0 {
+		return nil
+	}
+	filePath = filepath.ToSlash(filePath)
+	wf, err := s.entry(repoID, collection.Name, workflow, filePath)
+	if err != nil {
+		return err
+	}
+	reviewers, reviews, err := s.reviewsOf(*wf)
+	if err != nil {
+		return err
+	}
+	return checkApprovals(filePath, workflowStatus(workflow, wf, reviewers[wf.ID], reviews[wf.ID]))
+}
+
+// IsReviewer tells whether the user is assigned to review a file
+func (s *FileWorkflowService) IsReviewer(repoID uint, collectionName string, filePath string, userID string) bool {
+	var count int64
+	err := database.DB.Model(&models.FileReviewer{}).
+		Joins("JOIN file_workflows ON file_workflows.id = file_reviewers.workflow_id").
+		Where("file_workflows.repo_id = ? AND file_workflows.collection_name = ? AND file_workflows.file_path = ? AND file_reviewers.user_id = ?",
+			repoID, collectionName, filepath.ToSlash(filePath), userID).Count(&count).Error
+	if err != nil {
+		log.Errorf("Failed to check the reviewers of %s: %v", filePath, err)
+	}
+	return count > 0
+}
+
+// VerifyEntryAccess returns the repository of an entry to its owner or to a reviewer assigned to the entry. It only
+// reads the database, so it is checked before a lock of the repository is taken, and it fails the same way for
+// entries that do not exist.
+func (s *FileWorkflowService) VerifyEntryAccess(userID string, repoID uint, collectionName string, filePath string) (*models.UserGitRepo, error) {
+	repo, err := s.collectionService().GetRepo(repoID)
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, err.Error())
+	}
+	if repo.UserID == userID {
+		return &repo, nil
+	}
+	cleanPath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !s.IsReviewer(repo.ID, collectionName, cleanPath, userID) {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, fmt.Sprintf("you do not have permission to access %s", cleanPath))
+	}
+	return &repo, nil
+}
+
+// Assigned returns the entries the user is assigned to review, most recently changed first
+func (s *FileWorkflowService) Assigned(userID string) ([]models.FileWorkflow, error) {
+	var workflows []models.FileWorkflow
+	err := database.DB.Joins("JOIN file_reviewers ON file_reviewers.workflow_id = file_workflows.id").
+		Where("file_reviewers.user_id = ?", userID).Order("file_workflows.updated_at DESC").Find(&workflows).Error
+	return workflows, err
+}
+
+// MovePath moves the workflows of a moved file, or of the files below a moved directory
+func (s *FileWorkflowService) MovePath(repoID uint, collectionName string, oldPath string, newPath string) error {
+	oldPath, newPath = filepath.ToSlash(oldPath), filepath.ToSlash(newPath)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var workflows []models.FileWorkflow
+		err := tx.Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+			repoID, collectionName, oldPath, likePrefix(oldPath+"/")).Find(&workflows).Error
+		if err != nil {
+			return err
+		}
+		for _, wf := range workflows {
+			wf.FilePath = newPath + strings.TrimPrefix(wf.FilePath, oldPath)
+			if err := tx.Save(&wf).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePath drops the workflows, reviewers and history of a deleted file, or of the files below a deleted
+// directory
+func (s *FileWorkflowService) DeletePath(repoID uint, collectionName string, filePath string) error {
+	filePath = filepath.ToSlash(filePath)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		err := tx.Model(&models.FileWorkflow{}).Where("repo_id = ? AND collection_name = ? AND (file_path = ? OR file_path LIKE ? ESCAPE '\\')",
+			repoID, collectionName, filePath, likePrefix(filePath+"/")).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		if err := tx.Where("workflow_id IN ?", ids).Delete(&models.FileReviewer{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workflow_id IN ?", ids).Delete(&models.FileWorkflowHistory{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.FileWorkflow{}, ids).Error
+	})
+}
@@ -15,6 +15,9 @@ type MetricsService struct {
 	HTTPRequestsTotal    *prometheus.CounterVec
 	DatabaseQueriesTotal *prometheus.CounterVec
 	ErrorsTotal          *prometheus.CounterVec
+	GitRetriesTotal      *prometheus.CounterVec
+	GitFailuresTotal     *prometheus.CounterVec
+	ConfigCacheTotal     *prometheus.CounterVec
 
 	// Gauge metrics
 	ActiveConnections prometheus.Gauge
@@ -61,6 +64,30 @@ func (m *MetricsService) initializeMetrics() {
 		[]string{"type", "component"},
 	)
 
+	m.GitRetriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mkdocs_cms_git_retries_total",
+			Help: "The total number of retried git network operations",
+		},
+		[]string{"operation", "reason"},
+	)
+
+	m.GitFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mkdocs_cms_git_failures_total",
+			Help: "The total number of git network operations that failed after retries",
+		},
+		[]string{"operation", "reason"},
+	)
+
+	m.ConfigCacheTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mkdocs_cms_config_cache_total",
+			Help: "The total number of repository config cache lookups",
+		},
+		[]string{"result"},
+	)
+
 	// Initialize Gauge metrics
 	m.ActiveConnections = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -135,6 +162,18 @@ func (m *MetricsService) IncrementErrors(errorType, component string) {
 	m.ErrorsTotal.WithLabelValues(errorType, component).Inc()
 }
 
+func (m *MetricsService) IncrementGitRetries(operation, reason string) {
+	m.GitRetriesTotal.WithLabelValues(operation, reason).Inc()
+}
+
+func (m *MetricsService) IncrementGitFailures(operation, reason string) {
+	m.GitFailuresTotal.WithLabelValues(operation, reason).Inc()
+}
+
+func (m *MetricsService) IncrementConfigCache(result string) {
+	m.ConfigCacheTotal.WithLabelValues(result).Inc()
+}
+
 // Gauge methods
 func (m *MetricsService) SetActiveConnections(count float64) {
 	m.ActiveConnections.Set(count)
@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/schema"
+	"github.com/zhaojunlucky/mkdocs-cms/database"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultScheduleInterval = time.Minute
+	// maxPublishAttempts is how often a scheduled publication is tried before it is given up
+	maxPublishAttempts = 5
+)
+
+// scheduleKeys are the front matter keys holding the schedule of an entry
+var scheduleKeys = map[models.PublishAction]string{
+	models.PublishActionPublish:   "publish_at",
+	models.PublishActionUnpublish: "unpublish_at",
+}
+
+// PublishScheduleService carries out the publish_at and unpublish_at front matter of collection entries. The
+// schedules of a repository are synced from the collection index whenever its HEAD moves and stored in the
+// database, a ticker commits and pushes the ones that are due.
+type PublishScheduleService struct {
+	BaseService
+	userGitRepoService           *UserGitRepoService
+	userGitRepoLockService       *UserGitRepoLockService
+	collectionIndexService       *CollectionIndexService
+	userGitRepoCollectionService *UserGitRepoCollectionService
+	eventService                 *EventService
+	fileWorkflowService          *FileWorkflowService
+	mutex                        sync.Mutex
+	// heads are the HEAD shas the schedules of each repository were last synced at
+	heads map[uint]string
+}
+
+func (s *PublishScheduleService) Init(ctx *core.APPContext) {
+	s.InitService("publishScheduleService", ctx, s)
+	s.userGitRepoService = ctx.MustGetService("userGitRepoService").(*UserGitRepoService)
+	s.userGitRepoLockService = ctx.MustGetService("userGitRepoLockService").(*UserGitRepoLockService)
+	s.collectionIndexService = ctx.MustGetService("collectionIndexService").(*CollectionIndexService)
+	s.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*UserGitRepoCollectionService)
+	s.eventService = ctx.MustGetService("eventService").(*EventService)
+	s.fileWorkflowService = ctx.MustGetService("fileWorkflowService").(*FileWorkflowService)
+	s.heads = make(map[uint]string)
+
+	if ctx.Config.Schedule.Disabled {
+		log.Infof("Scheduled publishing is disabled")
+		return
+	}
+	interval := defaultScheduleInterval
+	if ctx.Config.Schedule.IntervalSeconds > 0 {
+		interval = time.Duration(ctx.Config.Schedule.IntervalSeconds) * time.Second
+	}
+	go s.run(interval)
+}
+
+func (s *PublishScheduleService) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.Tick(context.Background())
+	}
+}
+
+// Tick syncs the schedules of every repository and carries out the ones that are due
+func (s *PublishScheduleService) Tick(ctx context.Context) {
+	repos, err := s.userGitRepoService.GetAllRepos()
+	if err != nil {
+		log.Errorf("Failed to get repositories for scheduled publishing: %v", err)
+		return
+	}
+	for i := range repos {
+		repo := &repos[i]
+		if repo.Status == models.StatusPending || repo.Status == models.StatusSyncing {
+			continue
+		}
+		if err := s.processRepo(ctx, repo); err != nil {
+			log.Errorf("Failed to process the publication schedule of repo %d: %v", repo.ID, err)
+		}
+	}
+}
+
+func (s *PublishScheduleService) processRepo(ctx context.Context, repo *models.UserGitRepo) error {
+	repoID := fmt.Sprint(repo.ID)
+	lock, err := s.userGitRepoLockService.RLock(ctx, repoID, repo.UserID, "sync publication schedule")
+	if err != nil {
+		return err
+	}
+	err = s.Sync(repo)
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var due []models.PublishSchedule
+	err = database.DB.Where("repo_id = ? AND status = ? AND at <= ?", repo.ID, models.PublishStatusPending, time.Now().UTC()).
+		Order("at").Find(&due).Error
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	lock, err = s.userGitRepoLockService.Lock(ctx, repoID, repo.UserID, "scheduled publishing")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	for i := range due {
+		s.carryOut(ctx, repo, &due[i])
+	}
+	return nil
+}
+
+// scheduleOf returns the schedule settings of a collection with the defaults filled in. Without a time zone of
+// its own the schedule uses the one of a publish_at datetime field.
+func scheduleOf(collection models.UserGitRepoCollection) models.Schedule {
+	var schedule models.Schedule
+	if collection.Schedule != nil {
+		schedule = *collection.Schedule
+	}
+	if schedule.Mode == "" {
+		schedule.Mode = models.ScheduleModeDraft
+	}
+	if schedule.Mode == models.ScheduleModeFolder {
+		schedule.Drafts = path.Clean(schedule.Drafts)
+	}
+	if schedule.Timezone == "" {
+		if field, ok := findField(collection.Fields, scheduleKeys[models.PublishActionPublish]); ok {
+			schedule.Timezone = field.Timezone
+		}
+	}
+	return schedule
+}
+
+// inDrafts tells whether a path of a folder mode collection is the drafts folder or below it
+func inDrafts(schedule models.Schedule, filePath string) bool {
+	return schedule.Mode == models.ScheduleModeFolder && (filePath == schedule.Drafts || strings.HasPrefix(filePath, schedule.Drafts+"/"))
+}
+
+// entryPathOf strips the drafts folder from the path of an entry in the folder mode
+func entryPathOf(schedule models.Schedule, filePath string) string {
+	if schedule.Mode == models.ScheduleModeFolder {
+		if rest, ok := strings.CutPrefix(filePath, schedule.Drafts+"/"); ok {
+			return rest
+		}
+	}
+	return filePath
+}
+
+func scheduleKey(p *models.PublishSchedule) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%d", p.CollectionName, p.EntryPath, p.Action, p.At.Unix())
+}
+
+// Sync updates the stored schedules of a repository from the front matter of its entries unless HEAD did not
+// move since the last sync, the caller must hold a lock of the repository. A new schedule whose time passed
+// after the previous sync is still due and carried out with the next tick, one whose time passed before it, or
+// before the first sync of the repository, is recorded as skipped. Pending schedules whose front matter is gone
+// are dropped.
+func (s *PublishScheduleService) Sync(repo *models.UserGitRepo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now().UTC()
+	var state models.PublishScheduleState
+	if err := database.DB.Where("repo_id = ?", repo.ID).Limit(1).Find(&state).Error; err != nil {
+		return err
+	}
+
+	head := readHeadSHA(repo.LocalPath)
+	if head != "" && s.heads[repo.ID] == head {
+		// nothing new can be found until HEAD moves, the front matter was seen up to now
+		return database.DB.Save(&models.PublishScheduleState{RepoID: repo.ID, SyncedAt: now}).Error
+	}
+	desired, err := s.scan(repo)
+	if err != nil {
+		return err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var existing []models.PublishSchedule
+		if err := tx.Where("repo_id = ?", repo.ID).Find(&existing).Error; err != nil {
+			return err
+		}
+		byKey := make(map[string]*models.PublishSchedule, len(existing))
+		for i := range existing {
+			byKey[scheduleKey(&existing[i])] = &existing[i]
+		}
+
+		matched := map[uint]bool{}
+		for _, schedule := range desired {
+			if current, ok := byKey[scheduleKey(&schedule)]; ok {
+				matched[current.ID] = true
+				if current.FilePath != schedule.FilePath || current.Mode != schedule.Mode || current.Timezone != schedule.Timezone {
+					current.FilePath, current.Mode, current.Timezone = schedule.FilePath, schedule.Mode, schedule.Timezone
+					if err := tx.Save(current).Error; err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			schedule.Status = models.PublishStatusPending
+			if !schedule.At.After(now) && (state.SyncedAt.IsZero() || schedule.At.Before(state.SyncedAt)) {
+				schedule.Status = models.PublishStatusSkipped
+				schedule.Error = "the time had already passed before the previous scan of the repository"
+			}
+			if err := tx.Create(&schedule).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, schedule := range existing {
+			if !matched[schedule.ID] && schedule.Status == models.PublishStatusPending {
+				if err := tx.Delete(&schedule).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Save(&models.PublishScheduleState{RepoID: repo.ID, SyncedAt: now}).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.heads[repo.ID] = head
+	return nil
+}
+
+// scan reads the schedules from the front matter of the entries of every collection of a repository
+func (s *PublishScheduleService) scan(repo *models.UserGitRepo) ([]models.PublishSchedule, error) {
+	collections, err := s.userGitRepoCollectionService.GetCollectionsByRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules []models.PublishSchedule
+	for _, collection := range collections {
+		if collection.Entries == models.EntriesList {
+			continue
+		}
+		settings := scheduleOf(collection)
+		entries, err := s.collectionIndexService.Entries(repo, collection)
+		if err != nil {
+			log.Errorf("Failed to index collection %s for the publication schedule: %v", collection.Name, err)
+			continue
+		}
+		for _, entry := range entries {
+			filePath := filepath.ToSlash(entry.Path)
+			for action, key := range scheduleKeys {
+				value, ok := entry.Get(key)
+				text, isText := value.(string)
+				if !ok || !isText || strings.TrimSpace(text) == "" {
+					continue
+				}
+				at, err := schema.ParseDatetime(text, settings.Timezone)
+				if err != nil {
+					log.Warnf("Ignoring %s of %s in collection %s: %v", key, filePath, collection.Name, err)
+					continue
+				}
+				schedules = append(schedules, models.PublishSchedule{
+					RepoID:         repo.ID,
+					CollectionName: collection.Name,
+					EntryPath:      entryPathOf(settings, filePath),
+					FilePath:       filePath,
+					Action:         action,
+					Mode:           settings.Mode,
+					At:             at.UTC(),
+					Timezone:       settings.Timezone,
+				})
+			}
+		}
+	}
+	return schedules, nil
+}
+
+// Upcoming returns the pending schedules of a repository, optionally of one collection, soonest first.
+// The caller must hold a lock of the repository.
+func (s *PublishScheduleService) Upcoming(repo *models.UserGitRepo, collectionName string) ([]models.PublishSchedule, error) {
+	if err := s.Sync(repo); err != nil {
+		return nil, err
+	}
+	query := database.DB.Where("repo_id = ? AND status = ?", repo.ID, models.PublishStatusPending)
+	if collectionName != "" {
+		query = query.Where("collection_name = ?", collectionName)
+	}
+	var schedules []models.PublishSchedule
+	if err := query.Order("at").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	for i := range schedules {
+		schedules[i].LocalAt = localTime(schedules[i].At, schedules[i].Timezone)
+	}
+	return schedules, nil
+}
+
+// localTime formats a time in a time zone, in UTC when the zone is empty or unknown
+func localTime(t time.Time, timezone string) string {
+	location := time.UTC
+	if timezone != "" {
+		if loaded, err := time.LoadLocation(timezone); err == nil {
+			location = loaded
+		}
+	}
+	return t.In(location).Format(time.RFC3339)
+}
+
+// carryOut publishes or unpublishes an entry and records the outcome. A failed attempt is retried with the next
+// tick until maxPublishAttempts, an edit lease of someone else or missing workflow approvals only postpone it.
+func (s *PublishScheduleService) carryOut(ctx context.Context, repo *models.UserGitRepo, schedule *models.PublishSchedule) {
+	newPath, err := s.apply(ctx, repo, schedule)
+	now := time.Now()
+
+	var skipped *skipError
+	var httpErr *core.HTTPError
+	var missing *approvalsMissingError
+	switch {
+	case err == nil:
+		schedule.Status = models.PublishStatusDone
+		schedule.FilePath = newPath
+		schedule.Error = ""
+		schedule.DoneAt = &now
+	case errors.As(err, &skipped):
+		schedule.Status = models.PublishStatusSkipped
+		schedule.Error = skipped.reason
+		schedule.DoneAt = &now
+	case errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusLocked, errors.As(err, &missing):
+		// Retried on the next tick, once the editor is done or the approvals exist
+		schedule.Error = err.Error()
+		log.Infof("Scheduled %s of %s postponed: %v", schedule.Action, schedule.FilePath, err)
+	default:
+		schedule.Attempts++
+		schedule.Error = err.Error()
+		if schedule.Attempts >= maxPublishAttempts {
+			schedule.Status = models.PublishStatusFailed
+		}
+		log.Errorf("Scheduled %s of %s failed (attempt %d): %v", schedule.Action, schedule.FilePath, schedule.Attempts, err)
+	}
+	if err := database.DB.Save(schedule).Error; err != nil {
+		log.Errorf("Failed to save publication schedule %d: %v", schedule.ID, err)
+	}
+
+	if schedule.Status == models.PublishStatusPending {
+		return
+	}
+	event := models.CreateEventRequest{
+		Level:        models.EventLevelInfo,
+		Source:       models.EventSourceGitRepo,
+		Message:      fmt.Sprintf("Scheduled %s %s", schedule.Action, schedule.Status),
+		UserID:       &repo.UserID,
+		ResourceID:   &repo.ID,
+		ResourceType: "repository",
+		Details: fmt.Sprintf("%s in collection %s, scheduled for %s", schedule.FilePath, schedule.CollectionName,
+			localTime(schedule.At, schedule.Timezone)),
+	}
+	if schedule.Error != "" {
+		event.Details += ": " + schedule.Error
+	}
+	if schedule.Status == models.PublishStatusFailed {
+		event.Level = models.EventLevelError
+	}
+	if _, err := s.eventService.CreateEvent(event); err != nil {
+		log.Errorf("Failed to record the scheduled %s of %s: %v", schedule.Action, schedule.FilePath, err)
+	}
+}
+
+// skipError tells that a scheduled publication has nothing to do
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+// apply changes the entry according to the current schedule settings of its collection and returns its new path.
+// In the draft mode the draft key of the collection is flipped.
+func (s *PublishScheduleService) apply(ctx context.Context, repo *models.UserGitRepo, schedule *models.PublishSchedule) (string, error) {
+	collection, err := s.userGitRepoCollectionService.GetCollectionByName(repo, schedule.CollectionName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(collection.Path, filepath.FromSlash(schedule.FilePath))); os.IsNotExist(err) {
+		return "", &skipError{reason: fmt.Sprintf("%s no longer exists", schedule.FilePath)}
+	}
+	settings := scheduleOf(collection)
+	publish := schedule.Action == models.PublishActionPublish
+	// The scheduler holds the write lock and writes the version it just read, it is not based on a client's ETag
+	condition := WriteCondition{IfMatch: core.AnyVersion, UserID: repo.UserID}
+
+	if settings.Mode == models.ScheduleModeFolder {
+		inDrafts := schedule.FilePath != entryPathOf(settings, schedule.FilePath)
+		if publish != inDrafts {
+			return "", &skipError{reason: fmt.Sprintf("%s is already %sed", schedule.FilePath, schedule.Action)}
+		}
+		newPath := path.Join(settings.Drafts, schedule.FilePath)
+		if publish {
+			// the move out of the drafts folder checks the approvals of a workflow
+			newPath = entryPathOf(settings, schedule.FilePath)
+		}
+		return newPath, s.userGitRepoCollectionService.RenameFile(ctx, repo, schedule.CollectionName, schedule.FilePath, newPath, condition)
+	}
+
+	changed, err := s.userGitRepoCollectionService.SetDraft(ctx, repo, collection, schedule.FilePath, !publish, condition)
+	if err == nil && !changed {
+		return "", &skipError{reason: fmt.Sprintf("%s is already %sed", schedule.FilePath, schedule.Action)}
+	}
+	return schedule.FilePath, err
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+)
+
+// cachedRepoConfig is a parsed veda/config.yml together with the collections derived from it
+type cachedRepoConfig struct {
+	key         string
+	config      *VedaConfig
+	collections []models.UserGitRepoCollection
+}
+
+// RepoConfigCacheService keeps the parsed veda/config.yml of each repository. An entry is keyed by the
+// HEAD sha and the mtime and size of the file, so edits outside of the CMS are picked up without invalidation.
+type RepoConfigCacheService struct {
+	BaseService
+	mutex          sync.RWMutex
+	entries        map[uint]*cachedRepoConfig
+	metricsService *MetricsService
+}
+
+func (s *RepoConfigCacheService) Init(ctx *core.APPContext) {
+	s.InitService("repoConfigCacheService", ctx, s)
+	s.entries = make(map[uint]*cachedRepoConfig)
+	s.metricsService = ctx.MustGetService("metrics").(*MetricsService)
+}
+
+// get returns the cached entry of a repository when it was stored under the given key
+func (s *RepoConfigCacheService) get(repoID uint, key string) (*cachedRepoConfig, bool) {
+	s.mutex.RLock()
+	entry, ok := s.entries[repoID]
+	s.mutex.RUnlock()
+
+	if ok && entry.key == key {
+		s.metricsService.IncrementConfigCache("hit")
+		return entry, true
+	}
+	s.metricsService.IncrementConfigCache("miss")
+	return nil, false
+}
+
+func (s *RepoConfigCacheService) put(repoID uint, entry *cachedRepoConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[repoID] = entry
+}
+
+// Invalidate drops the cached config of a repository, it is called after syncs and commits
+func (s *RepoConfigCacheService) Invalidate(repoID uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, repoID)
+}
+
+// configCacheKey identifies the current version of veda/config.yml in the working tree
+func configCacheKey(repo *models.UserGitRepo) (string, error) {
+	fi, err := os.Stat(filepath.Join(repo.LocalPath, "veda", "config.yml"))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%d", readHeadSHA(repo.LocalPath), fi.ModTime().UnixNano(), fi.Size()), nil
+}
+
+// readHeadSHA resolves HEAD from the files in .git without starting a git process,
+// an empty string is returned when it cannot be resolved
+func readHeadSHA(repoPath string) string {
+	gitDir := filepath.Join(repoPath, ".git")
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	ref, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !ok {
+		return strings.TrimSpace(string(head))
+	}
+
+	if sha, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(sha))
+	}
+	packed, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(packed))
+	for scanner.Scan() {
+		sha, name, found := strings.Cut(scanner.Text(), " ")
+		if found && name == ref {
+			return sha
+		}
+	}
+	return ""
+}
@@ -0,0 +1,453 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/md"
+	"github.com/zhaojunlucky/mkdocs-cms/database"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// SearchFields are the columns of the search index, in table order
+var SearchFields = []string{"title", "headings", "front_matter", "body"}
+
+// searchFieldWeights rank a hit in the title above one in the body
+var searchFieldWeights = []float64{10, 4, 2, 1}
+
+// SearchQuery is a full-text query over the collections of some repositories
+type SearchQuery struct {
+	Text       string
+	RepoID     uint
+	Collection string
+	// Fields restricts matching to these columns of SearchFields, all columns are searched when empty
+	Fields []string
+	Limit  int
+	Offset int
+}
+
+// SearchService maintains a SQLite FTS4 index of the markdown files of all collections. The index of a
+// repository is refreshed when its HEAD moves, which covers saves, renames and deletes made through the
+// CMS as well as syncs; only files whose size or mtime changed are read again.
+type SearchService struct {
+	BaseService
+	userGitRepoCollectionService *UserGitRepoCollectionService
+	// mutex serializes refreshes, two requests must not index the same repository concurrently
+	mutex sync.Mutex
+}
+
+func (s *SearchService) Init(ctx *core.APPContext) {
+	s.InitService("searchService", ctx, s)
+	s.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*UserGitRepoCollectionService)
+
+	// Drop documents of repositories that were deleted
+	if err := s.removeOrphans(); err != nil {
+		log.Errorf("Failed to remove search documents of deleted repositories: %v", err)
+	}
+}
+
+func (s *SearchService) removeOrphans() error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		orphans := "SELECT id FROM search_files WHERE repo_id NOT IN (SELECT id FROM user_git_repos)"
+		if err := tx.Exec("DELETE FROM " + models.SearchDocumentsTable + " WHERE rowid IN (" + orphans + ")").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM search_files WHERE id IN (" + orphans + ")").Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM search_repo_states WHERE repo_id NOT IN (SELECT id FROM user_git_repos)").Error
+	})
+}
+
+// Refresh brings the index of a repository up to date with its working tree, the caller must hold a lock of the repository
+func (s *SearchService) Refresh(repo *models.UserGitRepo) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	head := readHeadSHA(repo.LocalPath)
+	var state models.SearchRepoState
+	err := database.DB.Where("repo_id = ?", repo.ID).First(&state).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if head != "" && state.HeadSHA == head {
+		return nil
+	}
+
+	collections, err := s.userGitRepoCollectionService.GetCollectionsByRepo(repo)
+	if err != nil {
+		return err
+	}
+	var files []models.SearchFile
+	if err := database.DB.Where("repo_id = ?", repo.ID).Find(&files).Error; err != nil {
+		return err
+	}
+	existing := make(map[string]*models.SearchFile, len(files))
+	for i := range files {
+		existing[files[i].Collection+"/"+files[i].Path] = &files[i]
+	}
+
+	indexed := 0
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		seen := map[string]bool{}
+		for _, collection := range collections {
+			var walkErr error
+			err := walkVisibleFiles(collection.Path, func(path string, rel string) {
+				if walkErr != nil || !collection.Format.HasExtension(path) {
+					return
+				}
+				key := collection.Name + "/" + rel
+				seen[key] = true
+				info, err := os.Stat(path)
+				if err != nil {
+					return
+				}
+				file := existing[key]
+				if file != nil && file.Size == info.Size() && file.ModTime.Equal(info.ModTime()) {
+					return
+				}
+				if file == nil {
+					file = &models.SearchFile{RepoID: repo.ID, Collection: collection.Name, Path: rel}
+				}
+				walkErr = s.indexFile(tx, file, path, info)
+				indexed++
+			})
+			if err == nil {
+				err = walkErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		for key, file := range existing {
+			if !seen[key] {
+				if err := deleteSearchFile(tx, file); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Save(&models.SearchRepoState{RepoID: repo.ID, HeadSHA: head}).Error
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Refreshed search index of repository %d: %d files indexed", repo.ID, indexed)
+	return nil
+}
+
+// indexFile stores the text of a file as the search document of file
+func (s *SearchService) indexFile(tx *gorm.DB, file *models.SearchFile, path string, info os.FileInfo) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var title, headings, frontMatter, body string
+	if filepath.Ext(path) == ".md" {
+		title, headings, frontMatter, body = extractSearchText(filepath.Base(path), content)
+	} else {
+		title, frontMatter = extractDataSearchText(filepath.Base(path), content)
+	}
+
+	file.Title = title
+	file.Size = info.Size()
+	file.ModTime = info.ModTime()
+	if err := tx.Save(file).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM "+models.SearchDocumentsTable+" WHERE rowid = ?", file.ID).Error; err != nil {
+		return err
+	}
+	return tx.Exec("INSERT INTO "+models.SearchDocumentsTable+"(rowid, title, headings, front_matter, body) VALUES (?, ?, ?, ?, ?)",
+		file.ID, title, headings, frontMatter, body).Error
+}
+
+func deleteSearchFile(tx *gorm.DB, file *models.SearchFile) error {
+	if err := tx.Exec("DELETE FROM "+models.SearchDocumentsTable+" WHERE rowid = ?", file.ID).Error; err != nil {
+		return err
+	}
+	return tx.Delete(file).Error
+}
+
+// RemoveRepo drops the index of a repository
+func (s *SearchService) RemoveRepo(repoID uint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM "+models.SearchDocumentsTable+" WHERE rowid IN (SELECT id FROM search_files WHERE repo_id = ?)", repoID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("repo_id = ?", repoID).Delete(&models.SearchFile{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("repo_id = ?", repoID).Delete(&models.SearchRepoState{}).Error
+	})
+}
+
+// searchRow is a match read from the index
+type searchRow struct {
+	RepoID     uint
+	Collection string
+	Path       string
+	Title      string
+	Snippet    string
+	Info       []byte
+}
+
+// Search runs a query over the given repositories and returns ranked results with highlighted snippets
+func (s *SearchService) Search(repos []models.UserGitRepo, q SearchQuery) (*models.SearchResponse, error) {
+	match, err := buildMatchExpression(q.Text, q.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	repoNames := map[uint]string{}
+	var repoIDs []uint
+	for _, repo := range repos {
+		if q.RepoID == 0 || repo.ID == q.RepoID {
+			repoIDs = append(repoIDs, repo.ID)
+			repoNames[repo.ID] = repo.Name
+		}
+	}
+	response := &models.SearchResponse{Results: []models.SearchResult{}}
+	if len(repoIDs) == 0 {
+		return response, nil
+	}
+
+	sql := "SELECT f.repo_id, f.collection, f.path, f.title, " +
+		"snippet(" + models.SearchDocumentsTable + ", '" + snippetStart + "', '" + snippetEnd + "', '…', -1, 24) AS snippet, " +
+		"matchinfo(" + models.SearchDocumentsTable + ", 'pcnx') AS info " +
+		"FROM " + models.SearchDocumentsTable + " JOIN search_files f ON f.id = " + models.SearchDocumentsTable + ".rowid " +
+		"WHERE " + models.SearchDocumentsTable + " MATCH ? AND f.repo_id IN ?"
+	args := []interface{}{match, repoIDs}
+	if q.Collection != "" {
+		sql += " AND f.collection = ?"
+		args = append(args, q.Collection)
+	}
+
+	var rows []searchRow
+	if err := database.DB.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		response.Results = append(response.Results, models.SearchResult{
+			RepoID:     row.RepoID,
+			RepoName:   repoNames[row.RepoID],
+			Collection: row.Collection,
+			Path:       row.Path,
+			Title:      row.Title,
+			Snippet:    highlight(row.Snippet),
+			Score:      rankMatch(row.Info),
+		})
+	}
+	sort.SliceStable(response.Results, func(i, j int) bool {
+		return response.Results[i].Score > response.Results[j].Score
+	})
+
+	response.Total = len(response.Results)
+	start := min(q.Offset, len(response.Results))
+	end := min(start+q.Limit, len(response.Results))
+	response.Results = response.Results[start:end]
+	return response, nil
+}
+
+// snippetStart and snippetEnd mark the matched terms in a raw snippet, they are not HTML so the text around
+// them can be escaped
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// highlight escapes the document text of a snippet and turns the match markers into <mark> elements
+func highlight(snippet string) string {
+	return strings.NewReplacer(snippetStart, "<mark>", snippetEnd, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// buildMatchExpression turns user input into an FTS4 query: every word must match as a prefix,
+// "quoted phrases" must match exactly. With fields, each term may match in any of these columns.
+func buildMatchExpression(text string, fields []string) (string, error) {
+	for _, field := range fields {
+		if !slices.Contains(SearchFields, field) {
+			return "", core.NewHTTPErrorStr(http.StatusBadRequest,
+				fmt.Sprintf("unknown search field %q, expected one of %s", field, strings.Join(SearchFields, ", ")))
+		}
+	}
+
+	var terms []string
+	for i, part := range strings.Split(text, `"`) {
+		// Odd parts were inside quotes
+		if i%2 == 1 {
+			if phrase := strings.Join(searchWords(part), " "); phrase != "" {
+				terms = append(terms, `"`+phrase+`"`)
+			}
+			continue
+		}
+		for _, word := range searchWords(part) {
+			terms = append(terms, word+"*")
+		}
+	}
+	if len(terms) == 0 {
+		return "", core.NewHTTPErrorStr(http.StatusBadRequest, "search text must contain at least one word")
+	}
+
+	if len(fields) == 0 {
+		return strings.Join(terms, " "), nil
+	}
+	var expression []string
+	for _, term := range terms {
+		var alternatives []string
+		for _, field := range fields {
+			alternatives = append(alternatives, field+":"+term)
+		}
+		// OR binds tighter than the implicit AND between terms
+		expression = append(expression, strings.Join(alternatives, " OR "))
+	}
+	return strings.Join(expression, " "), nil
+}
+
+// searchWords splits text into words of letters and digits, FTS operators are dropped
+func searchWords(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		switch word {
+		case "AND", "OR", "NOT", "NEAR":
+			word = strings.ToLower(word)
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// rankMatch scores a match from its FTS4 matchinfo 'pcnx' blob with a BM25 like weighting of the
+// term frequency per column and the inverse document frequency of each phrase
+func rankMatch(info []byte) float64 {
+	values := make([]uint32, len(info)/4)
+	for i := range values {
+		values[i] = binary.NativeEndian.Uint32(info[i*4:])
+	}
+	if len(values) < 3 {
+		return 0
+	}
+	phrases, columns, documents := int(values[0]), int(values[1]), float64(values[2])
+
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns && c < len(searchFieldWeights); c++ {
+			offset := 3 + (p*columns+c)*3
+			if offset+2 >= len(values) {
+				return score
+			}
+			hits, docsWithHits := float64(values[offset]), float64(values[offset+2])
+			if hits == 0 {
+				continue
+			}
+			idf := math.Log(1 + (documents-docsWithHits+0.5)/(docsWithHits+0.5))
+			score += searchFieldWeights[c] * idf * hits / (hits + 1.2)
+		}
+	}
+	return score
+}
+
+// extractDataSearchText indexes the values of a yaml or json data file, JSON is read as YAML
+func extractDataSearchText(name string, content []byte) (title string, frontMatter string) {
+	var value interface{}
+	if err := yaml.Unmarshal(content, &value); err != nil {
+		return strings.TrimSuffix(name, filepath.Ext(name)), string(content)
+	}
+
+	var lines []string
+	flattenFrontMatter(value, "", &lines)
+	title = strings.TrimSuffix(name, filepath.Ext(name))
+	if values, ok := value.(map[string]interface{}); ok {
+		if t, ok := values["title"].(string); ok && t != "" {
+			title = t
+		}
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// extractSearchText splits a markdown file into the columns of the search index
+func extractSearchText(name string, content []byte) (title string, headings string, frontMatter string, body string) {
+	values, bodyBytes, err := md.ParseFrontMatter(content)
+	if err != nil {
+		values, bodyBytes = map[string]interface{}{}, content
+	}
+	body = string(bodyBytes)
+
+	var lines []string
+	flattenFrontMatter(values, "", &lines)
+	frontMatter = strings.Join(lines, "\n")
+
+	var headingLines []string
+	inFence := false
+	scanner := bufio.NewScanner(bytes.NewReader(bodyBytes))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence && strings.HasPrefix(line, "#") {
+			if heading := strings.TrimSpace(strings.TrimLeft(line, "#")); heading != "" {
+				headingLines = append(headingLines, heading)
+			}
+		}
+	}
+	headings = strings.Join(headingLines, "\n")
+
+	if t, ok := values["title"].(string); ok && t != "" {
+		title = t
+	} else if len(headingLines) > 0 {
+		title = headingLines[0]
+	} else {
+		title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return title, headings, frontMatter, body
+}
+
+// flattenFrontMatter writes "key: value" lines for every scalar of the front matter, keys sorted
+func flattenFrontMatter(value interface{}, prefix string, lines *[]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flattenFrontMatter(v[key], name, lines)
+		}
+	case []interface{}:
+		for _, item := range v {
+			flattenFrontMatter(item, prefix, lines)
+		}
+	case nil:
+	default:
+		*lines = append(*lines, fmt.Sprintf("%s: %v", prefix, v))
+	}
+}
@@ -21,14 +21,25 @@ var service = []Service{
 	&MetricsService{},
 	&MinIOService{},
 	&SiteService{},
-	&UserFileDraftStatusService{},
+	&FileDraftStatusService{},
 	&UserService{},
+	&FileLeaseService{},
+	&EventService{},
+	&FileWorkflowService{},
+	&FileCommentService{},
 	&StorageService{},
 	&UserGitRepoLockService{},
 	&AsyncTaskService{},
+	&RepoConfigCacheService{},
+	&CollectionIndexService{},
 	&UserGitRepoService{},
+	&UserGitRepoHealthService{},
 	&UserGitRepoCollectionService{},
-	&EventService{},
+	&AutosaveService{},
+	&SearchService{},
+	&VedaConfigService{},
+	&PublishScheduleService{},
+	&SiteScaffoldService{},
 }
 
 func InitServices(ctx *core.APPContext) {
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v45/github"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
+	"github.com/zhaojunlucky/mkdocs-cms/core/scaffold"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"golang.org/x/oauth2"
+)
+
+// siteNameRegex rejects names starting with a dot, "." and ".." would put the clone outside its directory
+var siteNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// SiteScaffoldService creates new MkDocs repositories from the server-side site templates
+type SiteScaffoldService struct {
+	BaseService
+	userGitRepoService *UserGitRepoService
+	eventService       *EventService
+}
+
+func (s *SiteScaffoldService) Init(ctx *core.APPContext) {
+	s.InitService("siteScaffoldService", ctx, s)
+	s.userGitRepoService = ctx.MustGetService("userGitRepoService").(*UserGitRepoService)
+	s.eventService = ctx.MustGetService("eventService").(*EventService)
+}
+
+// ListTemplates returns the names of the available site templates
+func (s *SiteScaffoldService) ListTemplates() []string {
+	return scaffold.Templates()
+}
+
+// CreateSite validates the request, creates the remote repository and registers it as a UserGitRepo.
+// The returned repository is still empty, PopulateSite pushes the template and syncs the clone.
+func (s *SiteScaffoldService) CreateSite(ctx context.Context, user *models.User, request *models.CreateSiteRequest) (*models.UserGitRepo, error) {
+	if !siteNameRegex.MatchString(request.Name) {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "site name may only contain letters, digits, '.', '-' and '_' and must not start with '.'")
+	}
+	if request.Template == "" {
+		request.Template = scaffold.DefaultTemplate
+	}
+	if !scaffold.HasTemplate(request.Template) {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("site template %s not found", request.Template))
+	}
+	if request.Branch == "" {
+		request.Branch = "main"
+	}
+	if request.InstallationID == 0 && request.RemoteURL == "" {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "installation_id or remote_url is required")
+	}
+	if request.RemoteURL != "" {
+		if err := validateRemoteURL(request.RemoteURL); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.userGitRepoService.CheckRepoQuota(user); err != nil {
+		return nil, err
+	}
+
+	newRepo := &models.UserGitRepo{
+		UserID:      user.ID,
+		Name:        request.Name,
+		Description: request.Description,
+		Branch:      request.Branch,
+	}
+
+	if request.InstallationID != 0 {
+		var githubRepo *github.Repository
+		var err error
+		if request.RemoteURL != "" {
+			githubRepo, err = s.getGitHubRepo(ctx, user, request)
+		} else {
+			githubRepo, err = s.createGitHubRepo(ctx, user, request)
+		}
+		if err != nil {
+			return nil, err
+		}
+		newRepo.RemoteURL = githubRepo.GetCloneURL()
+		newRepo.Provider = "github"
+		newRepo.AuthType = "github_app"
+		newRepo.InstallationID = request.InstallationID
+		newRepo.GitRepoID = githubRepo.GetID()
+		newRepo.AuthData = fmt.Sprintf(`{"installation_id": %d}`, request.InstallationID)
+	} else {
+		newRepo.RemoteURL = request.RemoteURL
+		newRepo.Provider = "git"
+		newRepo.AuthType = "none"
+	}
+
+	if err := s.userGitRepoService.CreateRepo(newRepo); err != nil {
+		log.Errorf("Failed to create repository: %v", err)
+		// a retry with the same name must not fail because the name is taken on GitHub
+		s.removeRemote(ctx, newRepo, request)
+		return nil, err
+	}
+
+	s.eventService.CreateEvent(models.CreateEventRequest{
+		Level:        models.EventLevelInfo,
+		Source:       models.EventSourceGitRepo,
+		Message:      "Site created",
+		ResourceID:   &newRepo.ID,
+		ResourceType: "repository",
+		Details:      fmt.Sprintf("Site %s created from template %s", newRepo.Name, request.Template),
+	})
+	return newRepo, nil
+}
+
+// DiscardSite removes a site that could not be populated, the registration and the clone are deleted and so
+// is the GitHub repository when CreateSite created it, so that the site can be created again with the same name
+func (s *SiteScaffoldService) DiscardSite(ctx context.Context, repo *models.UserGitRepo, request *models.CreateSiteRequest) {
+	if err := s.userGitRepoService.DeleteRepo(repo); err != nil {
+		log.Errorf("Failed to delete repository %d of a site that was not populated: %v", repo.ID, err)
+	}
+	s.removeRemote(ctx, repo, request)
+}
+
+// removeRemote deletes the GitHub repository of a site when CreateSite created it, an existing repository
+// passed as remote_url is left alone
+func (s *SiteScaffoldService) removeRemote(ctx context.Context, repo *models.UserGitRepo, request *models.CreateSiteRequest) {
+	if request.InstallationID == 0 || request.RemoteURL != "" || repo.RemoteURL == "" {
+		return
+	}
+	owner, name, err := parseGitHubURL(repo.RemoteURL)
+	if err != nil {
+		log.Errorf("Failed to delete GitHub repository of a site that was not created: %v", err)
+		return
+	}
+	client, err := s.installationClient(ctx, request.InstallationID)
+	if err != nil {
+		log.Errorf("Failed to delete GitHub repository %s/%s of a site that was not created: %v", owner, name, err)
+		return
+	}
+	if _, err := client.Repositories.Delete(ctx, owner, name); err != nil {
+		log.Errorf("Failed to delete GitHub repository %s/%s of a site that was not created: %v", owner, name, err)
+	}
+}
+
+// validateRemoteURL only accepts https remotes, other transports and local paths could read or overwrite the
+// clones on this server
+func validateRemoteURL(remoteURL string) error {
+	u, err := url.Parse(remoteURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return core.NewHTTPErrorStr(http.StatusBadRequest, "remote_url must be an https URL without credentials")
+	}
+	return nil
+}
+
+// parseGitHubURL returns the owner and name of a repository from its https clone URL
+func parseGitHubURL(remoteURL string) (string, string, error) {
+	u, err := url.Parse(remoteURL)
+	if err != nil || !strings.EqualFold(u.Host, "github.com") {
+		return "", "", core.NewHTTPErrorStr(http.StatusBadRequest, "remote_url must be a github.com repository when installation_id is set")
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("remote_url %s is not a GitHub repository URL", remoteURL))
+	}
+	return parts[0], parts[1], nil
+}
+
+// checkInstallation returns the installation when its account is the authenticated user
+func (s *SiteScaffoldService) checkInstallation(ctx context.Context, user *models.User, installationID int64) (*github.Installation, error) {
+	installation, _, err := s.ctx.GithubAppClient.Apps.GetInstallation(ctx, installationID)
+	if err != nil {
+		log.Errorf("Failed to get installation: %v", err)
+		return nil, fmt.Errorf("failed to get installation: %v", err)
+	}
+	if user.Provider != "github" || !strings.EqualFold(installation.GetAccount().GetLogin(), user.Username) {
+		return nil, core.NewHTTPErrorStr(http.StatusForbidden, "Installation does not belong to the authenticated user")
+	}
+	return installation, nil
+}
+
+// installationClient returns a GitHub client authenticated with a new token of the installation
+func (s *SiteScaffoldService) installationClient(ctx context.Context, installationID int64) (*github.Client, error) {
+	installationToken, _, err := s.ctx.GithubAppClient.Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		log.Errorf("Failed to get installation token: %v", err)
+		return nil, fmt.Errorf("failed to get installation token: %v", err)
+	}
+	httpClient := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(
+				&oauth2.Token{AccessToken: installationToken.GetToken()},
+			),
+		},
+	}
+	return github.NewClient(httpClient), nil
+}
+
+// getGitHubRepo looks up the existing repository passed as remote_url, the installation must have access to it
+// so that the template can be pushed with its token
+func (s *SiteScaffoldService) getGitHubRepo(ctx context.Context, user *models.User, request *models.CreateSiteRequest) (*github.Repository, error) {
+	owner, name, err := parseGitHubURL(request.RemoteURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.checkInstallation(ctx, user, request.InstallationID); err != nil {
+		return nil, err
+	}
+	client, err := s.installationClient(ctx, request.InstallationID)
+	if err != nil {
+		return nil, err
+	}
+	githubRepo, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		log.Errorf("Failed to get GitHub repository %s/%s: %v", owner, name, err)
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf(
+			"GitHub repository %s/%s is not accessible to the installation: %v", owner, name, err))
+	}
+	return githubRepo, nil
+}
+
+// createGitHubRepo creates an empty repository owned by the installation account
+func (s *SiteScaffoldService) createGitHubRepo(ctx context.Context, user *models.User, request *models.CreateSiteRequest) (*github.Repository, error) {
+	installation, err := s.checkInstallation(ctx, user, request.InstallationID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.installationClient(ctx, request.InstallationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty org creates the repository for the authenticated account
+	org := ""
+	if installation.GetAccount().GetType() == "Organization" {
+		org = installation.GetAccount().GetLogin()
+	}
+	githubRepo, _, err := client.Repositories.Create(ctx, org, &github.Repository{
+		Name:        github.String(request.Name),
+		Description: github.String(request.Description),
+		Private:     github.Bool(request.Private),
+		AutoInit:    github.Bool(false),
+	})
+	if err != nil {
+		log.Errorf("Failed to create GitHub repository: %v", err)
+		if org == "" {
+			return nil, core.NewHTTPErrorStr(http.StatusBadGateway, fmt.Sprintf(
+				"failed to create GitHub repository: %v. GitHub may not allow apps to create repositories for personal accounts, "+
+					"create an empty repository on GitHub, grant the installation access to it and pass its clone URL as "+
+					"remote_url together with installation_id instead", err))
+		}
+		return nil, core.NewHTTPErrorStr(http.StatusBadGateway, fmt.Sprintf("failed to create GitHub repository: %v", err))
+	}
+	return githubRepo, nil
+}
+
+// ErrSiteNotPushed is wrapped by the errors of PopulateSite that happen before the template reached the remote,
+// only then the site may be discarded. Once it was pushed, the site is kept and can be synced again.
+var ErrSiteNotPushed = errors.New("site template was not pushed")
+
+// PopulateSite renders the template into a staging directory, pushes it as the first commit of the
+// remote branch and then syncs the repository so the clone is created the same way as for an import.
+// The caller must hold the write lock of the repository.
+func (s *SiteScaffoldService) PopulateSite(ctx context.Context, repo *models.UserGitRepo, templateName string) error {
+	if err := s.pushTemplate(ctx, repo, templateName); err != nil {
+		return fmt.Errorf("%w: %w", ErrSiteNotPushed, err)
+	}
+
+	if err := s.userGitRepoService.SyncRepo(ctx, repo, ""); err != nil {
+		return err
+	}
+	if repo.AuthType == "github_app" {
+		if err := s.userGitRepoService.CheckWebHooks(ctx, repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushTemplate renders the template and pushes it as the first commit of the remote branch
+func (s *SiteScaffoldService) pushTemplate(ctx context.Context, repo *models.UserGitRepo, templateName string) error {
+	if err := os.MkdirAll(s.ctx.RepoBasePath, 0755); err != nil {
+		return err
+	}
+	stagingPath, err := os.MkdirTemp(s.ctx.RepoBasePath, ".scaffold-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(stagingPath)
+
+	files, err := scaffold.Render(templateName, scaffold.TemplateData{
+		SiteName:    repo.Name,
+		Description: repo.Description,
+		RepoURL:     strings.TrimSuffix(repo.RemoteURL, ".git"),
+		Branch:      repo.Branch,
+		Date:        time.Now().Format("2006-01-02"),
+	}, stagingPath)
+	if err != nil {
+		return err
+	}
+	log.Infof("Rendered %d file(s) from template %s for repository %d", len(files), templateName, repo.ID)
+
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, stagingPath, "init", "-b", repo.Branch); err != nil {
+		log.Errorf("Failed to initialize repository: %s", string(output))
+		return fmt.Errorf("failed to initialize repository: %w", err)
+	}
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, stagingPath, "add", "."); err != nil {
+		log.Errorf("Failed to stage changes: %s", string(output))
+		return fmt.Errorf("failed to stage changes: %w", err)
+	}
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, stagingPath, "commit", "-m",
+		fmt.Sprintf("Initialize site from %s template", templateName)); err != nil {
+		log.Errorf("Failed to commit changes: %s", string(output))
+		return fmt.Errorf("failed to commit changes: %w", err)
+	}
+
+	err = s.ctx.Git.Retry(ctx, "git push", func(attempt int, lastFailure git.FailureClass) error {
+		remoteURL := repo.RemoteURL
+		if repo.AuthType == "github_app" {
+			var err error
+			if remoteURL, err = s.userGitRepoService.tokenRemoteURL(ctx, repo); err != nil {
+				return err
+			}
+		}
+		output, err := s.ctx.Git.CombinedOutput(ctx, git.OpPush, stagingPath, "push", remoteURL,
+			fmt.Sprintf("HEAD:refs/heads/%s", repo.Branch))
+		if err != nil {
+			log.Errorf("Failed to push changes: %s", string(output))
+		}
+		return err
+	}, s.userGitRepoService.onGitRetry("push"))
+	if err != nil {
+		s.userGitRepoService.metricsService.IncrementGitFailures("push", string(git.Classify(err)))
+		return fmt.Errorf("failed to push site template: %w", err)
+	}
+	return nil
+}
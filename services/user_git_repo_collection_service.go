@@ -2,20 +2,28 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/v45/github"
 	log "github.com/sirupsen/logrus"
 	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/filename"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
+	"github.com/zhaojunlucky/mkdocs-cms/core/ignore"
 	"github.com/zhaojunlucky/mkdocs-cms/core/md"
+	"github.com/zhaojunlucky/mkdocs-cms/core/query"
+	"github.com/zhaojunlucky/mkdocs-cms/core/schema"
 
 	"github.com/zhaojunlucky/mkdocs-cms/database"
 	"github.com/zhaojunlucky/mkdocs-cms/models"
@@ -25,37 +33,84 @@ import (
 // UserGitRepoCollectionService handles business logic for git repository collections
 type UserGitRepoCollectionService struct {
 	BaseService
-	userGitRepoService         *UserGitRepoService
-	userFileDraftStatusService *UserFileDraftStatusService
-	mdHandler                  *md.MDHandler
+	userGitRepoService     *UserGitRepoService
+	fileDraftStatusService *FileDraftStatusService
+	metricsService         *MetricsService
+	repoConfigCacheService *RepoConfigCacheService
+	collectionIndexService *CollectionIndexService
+	fileLeaseService       *FileLeaseService
+	fileWorkflowService    *FileWorkflowService
+	fileCommentService     *FileCommentService
+	mdHandler              *md.MDHandler
+	versions               *fileVersionCache
+	// reconciledDrafts holds the repository ID and name of the collections whose draft status was read
+	// from the front matter since the server started
+	reconciledDrafts sync.Map
 }
 
 func (s *UserGitRepoCollectionService) Init(ctx *core.APPContext) {
 	s.InitService("userGitRepoCollectionService", ctx, s)
 	s.userGitRepoService = ctx.MustGetService("userGitRepoService").(*UserGitRepoService)
-	s.userFileDraftStatusService = ctx.MustGetService("userFileDraftStatusService").(*UserFileDraftStatusService)
+	s.fileDraftStatusService = ctx.MustGetService("fileDraftStatusService").(*FileDraftStatusService)
+	s.metricsService = ctx.MustGetService("metrics").(*MetricsService)
+	s.repoConfigCacheService = ctx.MustGetService("repoConfigCacheService").(*RepoConfigCacheService)
+	s.collectionIndexService = ctx.MustGetService("collectionIndexService").(*CollectionIndexService)
+	s.fileLeaseService = ctx.MustGetService("fileLeaseService").(*FileLeaseService)
+	s.fileWorkflowService = ctx.MustGetService("fileWorkflowService").(*FileWorkflowService)
+	s.fileCommentService = ctx.MustGetService("fileCommentService").(*FileCommentService)
 	s.mdHandler = md.NewMDHandler()
+	s.versions = newFileVersionCache()
 }
 
 // VedaConfig represents the structure of veda/config.yml
 type VedaConfig struct {
-	Collections []Collection `yaml:"collections"`
-	MDConfig    *md.MDConfig `yaml:"md_config"`
+	Collections []Collection `yaml:"collections" json:"collections"`
+	MDConfig    *md.MDConfig `yaml:"md_config,omitempty" json:"md_config,omitempty"`
 }
 
 // Collection represents a collection in veda/config.yml
 type Collection struct {
-	Name              string             `yaml:"name"`
-	Label             string             `yaml:"label"`
-	Path              string             `yaml:"path"`
-	Format            string             `yaml:"format"`
-	FileNameGenerator *FileNameGenerator `yaml:"file_name_generator"`
-	Fields            []Field            `yaml:"fields,omitempty"`
+	Name              string             `yaml:"name" json:"name"`
+	Label             string             `yaml:"label" json:"label"`
+	Path              string             `yaml:"path" json:"path"`
+	Format            string             `yaml:"format" json:"format"`
+	FileNameGenerator *FileNameGenerator `yaml:"file_name_generator,omitempty" json:"file_name_generator,omitempty"`
+	Fields            []Field            `yaml:"fields,omitempty" json:"fields,omitempty"`
+	// Exclude lists gitignore style patterns, relative to the collection path, of files hidden from the editor
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	// Entries is "list" when every item of a yaml or json file is an entry, Root is the dotted key holding the items
+	Entries string `yaml:"entries,omitempty" json:"entries,omitempty"`
+	Root    string `yaml:"root,omitempty" json:"root,omitempty"`
+	// Schedule configures how publish_at and unpublish_at of the entries are carried out
+	Schedule *Schedule `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+	// DraftKey is the dotted front matter key marking an entry as a draft, "draft" when empty
+	DraftKey string `yaml:"draft_key,omitempty" json:"draft_key,omitempty"`
+	// Workflow configures the editorial states the entries go through before they are published
+	Workflow *Workflow `yaml:"workflow,omitempty" json:"workflow,omitempty"`
+}
+
+type Schedule struct {
+	Mode     string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Drafts   string `yaml:"drafts,omitempty" json:"drafts,omitempty"`
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+type Workflow struct {
+	States            []string             `yaml:"states" json:"states"`
+	Publish           string               `yaml:"publish,omitempty" json:"publish,omitempty"`
+	RequiredApprovals int                  `yaml:"required_approvals,omitempty" json:"required_approvals"`
+	Transitions       []WorkflowTransition `yaml:"transitions,omitempty" json:"transitions,omitempty"`
+}
+
+type WorkflowTransition struct {
+	From  string   `yaml:"from" json:"from"`
+	To    string   `yaml:"to" json:"to"`
+	Roles []string `yaml:"roles,omitempty" json:"roles,omitempty"`
 }
 
 type FileNameGenerator struct {
 	Type  string `yaml:"type" json:"type"`
-	First string `yaml:"first" json:"first"`
+	First string `yaml:"first,omitempty" json:"first"`
 }
 
 // Field represents a field in a collection
@@ -67,6 +122,19 @@ type Field struct {
 	Format   string `yaml:"format,omitempty" json:"format"`
 	List     bool   `yaml:"list,omitempty" json:"list"`
 	Default  string `yaml:"default,omitempty" json:"default"`
+	// Options are the allowed values of a select field
+	Options []string `yaml:"options,omitempty" json:"options,omitempty"`
+	// Collection is the collection a reference field points to
+	Collection string `yaml:"collection,omitempty" json:"collection,omitempty"`
+	// Path is the directory, relative to the repository root, that holds the images of an image field
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Min and Max bound the value of a number field
+	Min *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+	// Timezone is applied to datetime values written without an offset
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	// Fields are the subfields of an object field
+	Fields []Field `yaml:"fields,omitempty" json:"fields,omitempty"`
 }
 
 // GetAllCollections returns all collections
@@ -108,13 +176,28 @@ func (s *UserGitRepoCollectionService) GetCollectionsByRepo(repo *models.UserGit
 }
 
 func (s *UserGitRepoCollectionService) readRepoConfig(repo models.UserGitRepo) (*VedaConfig, error) {
+	entry, err := s.loadRepoConfig(repo)
+	if err != nil {
+		return nil, err
+	}
+	return entry.config, nil
+}
+
+// loadRepoConfig returns the parsed veda/config.yml and its collections, parsing the file only when it changed
+func (s *UserGitRepoCollectionService) loadRepoConfig(repo models.UserGitRepo) (*cachedRepoConfig, error) {
 	configPath := filepath.Join(repo.LocalPath, "veda", "config.yml")
 
 	// Check if the config file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+	key, err := configCacheKey(&repo)
+	if os.IsNotExist(err) {
 		log.Errorf("veda/config.yml not found in repository %s", repo.Name)
 		return nil, fmt.Errorf("veda/config.yml not found in repository %s", repo.Name)
 	}
+	if err == nil {
+		if entry, ok := s.repoConfigCacheService.get(repo.ID, key); ok {
+			return entry, nil
+		}
+	}
 
 	// Read the config file
 	configData, err := os.ReadFile(configPath)
@@ -129,34 +212,40 @@ func (s *UserGitRepoCollectionService) readRepoConfig(repo models.UserGitRepo) (
 		log.Errorf("Invalid YAML format in veda/config.yml: %v", err)
 		return nil, fmt.Errorf("invalid YAML format in veda/config.yml: %v", err)
 	}
-	return config, nil
+
+	entry := &cachedRepoConfig{
+		key:         key,
+		config:      config,
+		collections: collectionsFromConfig(repo, config),
+	}
+	if key != "" {
+		s.repoConfigCacheService.put(repo.ID, entry)
+	}
+	return entry, nil
 }
 
 // readCollectionsFromConfig reads collections from veda/config.yml
 func (s *UserGitRepoCollectionService) readCollectionsFromConfig(repo models.UserGitRepo) ([]models.UserGitRepoCollection, error) {
 	var collections []models.UserGitRepoCollection
 
-	config, err := s.readRepoConfig(repo)
+	entry, err := s.loadRepoConfig(repo)
 	if err != nil {
 		return collections, nil
 	}
+	// callers get their own slice so the cached one cannot be modified
+	return append(collections, entry.collections...), nil
+}
+
+// collectionsFromConfig converts the collections of veda/config.yml to UserGitRepoCollection models
+func collectionsFromConfig(repo models.UserGitRepo, config *VedaConfig) []models.UserGitRepoCollection {
+	var collections []models.UserGitRepoCollection
+
 	// Convert to UserGitRepoCollection models
 	for _, col := range config.Collections {
 		// Resolve the path relative to the repository
 		fullPath := filepath.Join(repo.LocalPath, col.Path)
 
-		var modelFields []models.Field
-		for _, f := range col.Fields {
-			modelFields = append(modelFields, models.Field{
-				Type:     f.Type,
-				Name:     f.Name,
-				Label:    f.Label,
-				Required: f.Required,
-				Format:   f.Format,
-				List:     f.List,
-				Default:  f.Default,
-			})
-		}
+		modelFields := toModelFields(col.Fields)
 
 		collection := models.UserGitRepoCollection{
 			Name:        col.Name,
@@ -166,6 +255,10 @@ func (s *UserGitRepoCollectionService) readCollectionsFromConfig(repo models.Use
 			Description: "", // No description in veda/config.yml
 			RepoID:      repo.ID,
 			Fields:      modelFields,
+			Exclude:     col.Exclude,
+			Entries:     col.Entries,
+			Root:        col.Root,
+			DraftKey:    col.DraftKey,
 		}
 
 		if col.FileNameGenerator != nil {
@@ -174,10 +267,52 @@ func (s *UserGitRepoCollectionService) readCollectionsFromConfig(repo models.Use
 				First: col.FileNameGenerator.First,
 			}
 		}
+		if col.Schedule != nil {
+			collection.Schedule = &models.Schedule{
+				Mode:     col.Schedule.Mode,
+				Drafts:   col.Schedule.Drafts,
+				Timezone: col.Schedule.Timezone,
+			}
+		}
+		if col.Workflow != nil && len(col.Workflow.States) > 0 {
+			collection.Workflow = &models.Workflow{
+				States:            col.Workflow.States,
+				Publish:           col.Workflow.Publish,
+				RequiredApprovals: col.Workflow.RequiredApprovals,
+			}
+			for _, t := range col.Workflow.Transitions {
+				collection.Workflow.Transitions = append(collection.Workflow.Transitions,
+					models.WorkflowTransition{From: t.From, To: t.To, Roles: t.Roles})
+			}
+		}
 		collections = append(collections, collection)
 	}
 
-	return collections, nil
+	return collections
+}
+
+// toModelFields converts the fields of veda/config.yml, including the subfields of object fields
+func toModelFields(fields []Field) []models.Field {
+	var modelFields []models.Field
+	for _, f := range fields {
+		modelFields = append(modelFields, models.Field{
+			Type:       f.Type,
+			Name:       f.Name,
+			Label:      f.Label,
+			Required:   f.Required,
+			Format:     f.Format,
+			List:       f.List,
+			Default:    f.Default,
+			Options:    f.Options,
+			Collection: f.Collection,
+			Path:       f.Path,
+			Min:        f.Min,
+			Max:        f.Max,
+			Timezone:   f.Timezone,
+			Fields:     toModelFields(f.Fields),
+		})
+	}
+	return modelFields
 }
 
 // GetCollectionByID returns a specific collection by ID
@@ -249,6 +384,12 @@ type FileInfo struct {
 	Size      int64     `json:"size"`
 	ModTime   time.Time `json:"mod_time"`
 	Extension string    `json:"extension,omitempty"`
+	// ETag is the git blob sha of a file, send it as If-Match to change the file
+	ETag string `json:"etag,omitempty"`
+	// Lease is set while someone is editing the file
+	Lease *models.FileEditLease `json:"lease,omitempty"`
+	// Workflow is the editorial state of the file in a collection with a workflow
+	Workflow *models.WorkflowStatus `json:"workflow,omitempty"`
 }
 
 // ListFilesInCollection lists all files under a collection path
@@ -270,10 +411,9 @@ func (s *UserGitRepoCollectionService) ListFilesInCollection(repo *models.UserGi
 		return nil, err
 	}
 
-	statusMap, err := s.userFileDraftStatusService.GetDraftStatus(repo.UserID, repo.ID, collectionName)
-	if err != nil {
-		log.Errorf("failed to get draft status: %v", err)
-	}
+	statusMap := s.draftStatus(repo, collection)
+	leases := s.fileLeaseService.ActiveLeases(repo.ID, collectionName)
+	workflows := s.fileWorkflowService.Statuses(repo.ID, collection)
 
 	// Convert to FileInfo
 	var files []FileInfo
@@ -287,22 +427,25 @@ func (s *UserGitRepoCollectionService) ListFilesInCollection(repo *models.UserGi
 			continue
 		}
 
-		if !entry.IsDir() && filepath.Ext(entry.Name()) != fmt.Sprintf(".%s", collection.Format) {
+		if !entry.IsDir() && !collection.Format.HasExtension(entry.Name()) {
 			continue
 		}
 
 		fileInfo := FileInfo{
-			Name:    entry.Name(),
-			Path:    entry.Name(),
-			IsDraft: statusMap[entry.Name()],
-			IsDir:   entry.IsDir(),
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
+			Name:     entry.Name(),
+			Path:     entry.Name(),
+			IsDraft:  statusMap[entry.Name()],
+			IsDir:    entry.IsDir(),
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Lease:    leases[entry.Name()],
+			Workflow: workflows.Of(entry.Name(), entry.IsDir()),
 		}
 
-		// Add extension for files
+		// Add extension and version for files
 		if !entry.IsDir() {
 			fileInfo.Extension = filepath.Ext(entry.Name())
+			fileInfo.ETag, _ = s.versions.fileETag(filepath.Join(collection.Path, entry.Name()), info.Size(), info.ModTime())
 		}
 
 		files = append(files, fileInfo)
@@ -343,10 +486,9 @@ func (s *UserGitRepoCollectionService) ListFilesInPath(repo *models.UserGitRepo,
 		return nil, err
 	}
 
-	statusMap, err := s.userFileDraftStatusService.GetDraftStatus(repo.UserID, repo.ID, collectionName)
-	if err != nil {
-		log.Errorf("failed to get draft status: %v", err)
-	}
+	statusMap := s.draftStatus(repo, collection)
+	leases := s.fileLeaseService.ActiveLeases(repo.ID, collectionName)
+	workflows := s.fileWorkflowService.Statuses(repo.ID, collection)
 
 	// Convert to FileInfo
 	var files []FileInfo
@@ -360,23 +502,26 @@ func (s *UserGitRepoCollectionService) ListFilesInPath(repo *models.UserGitRepo,
 			continue
 		}
 
-		if !entry.IsDir() && filepath.Ext(entry.Name()) != fmt.Sprintf(".%s", collection.Format) {
+		if !entry.IsDir() && !collection.Format.HasExtension(entry.Name()) {
 			continue
 		}
 
 		relativePath := filepath.Join(cleanSubPath, entry.Name())
 		fileInfo := FileInfo{
-			Name:    entry.Name(),
-			Path:    relativePath,
-			IsDir:   entry.IsDir(),
-			IsDraft: statusMap[relativePath],
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
+			Name:     entry.Name(),
+			Path:     relativePath,
+			IsDir:    entry.IsDir(),
+			IsDraft:  statusMap[relativePath],
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Lease:    leases[relativePath],
+			Workflow: workflows.Of(relativePath, entry.IsDir()),
 		}
 
-		// Add extension for files
+		// Add extension and version for files
 		if !entry.IsDir() {
 			fileInfo.Extension = filepath.Ext(entry.Name())
+			fileInfo.ETag, _ = s.versions.fileETag(filepath.Join(fullPath, entry.Name()), info.Size(), info.ModTime())
 		}
 
 		files = append(files, fileInfo)
@@ -396,6 +541,274 @@ func (s *UserGitRepoCollectionService) ListFilesInPath(repo *models.UserGitRepo,
 	return files, nil
 }
 
+const (
+	// TreeIncludeFormat lists the files of the collection format, the default
+	TreeIncludeFormat = "format"
+	// TreeIncludeAssets lists the files that are not of the collection format
+	TreeIncludeAssets = "assets"
+	// TreeIncludeAll lists every file
+	TreeIncludeAll = "all"
+
+	defaultTreeDepth = 1
+	maxTreeDepth     = 10
+	defaultTreeLimit = 200
+	maxTreeLimit     = 1000
+)
+
+// TreeOptions controls ListTree
+type TreeOptions struct {
+	// Path is the directory to list, relative to the collection
+	Path string
+	// Depth is the number of directory levels expanded below Path
+	Depth int
+	// Include is one of TreeIncludeFormat, TreeIncludeAssets and TreeIncludeAll
+	Include string
+	// Limit caps the number of children listed per directory
+	Limit int
+	// Cursor continues the children of Path after the last child of a previous page
+	Cursor string
+}
+
+// TreeNode is a file or directory of a collection tree. Loaded is set on directories whose children
+// were listed, NextCursor when the directory has more children than the limit.
+type TreeNode struct {
+	FileInfo
+	Loaded     bool        `json:"loaded,omitempty"`
+	Children   []*TreeNode `json:"children,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// treeWalker carries the state of one ListTree call
+type treeWalker struct {
+	repoPath   string
+	collection models.UserGitRepoCollection
+	// collectionRel is the collection path relative to the repository root, ignore rules match from the root
+	collectionRel string
+	matcher       *ignore.Matcher
+	gitignores    map[string]bool
+	statusMap     map[string]bool
+	leases        map[string]*models.FileEditLease
+	workflows     WorkflowStatuses
+	versions      *fileVersionCache
+	options       TreeOptions
+}
+
+// ListTree lists a collection directory recursively, up to options.Depth levels. Dotfiles, paths matched
+// by .gitignore files and paths matched by the exclude patterns of the collection are left out.
+func (s *UserGitRepoCollectionService) ListTree(repo *models.UserGitRepo, collectionName string, options TreeOptions) (*TreeNode, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch options.Include {
+	case "":
+		options.Include = TreeIncludeFormat
+	case TreeIncludeFormat, TreeIncludeAssets, TreeIncludeAll:
+	default:
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("include must be one of %s, %s, %s",
+			TreeIncludeFormat, TreeIncludeAssets, TreeIncludeAll))
+	}
+	if options.Depth <= 0 {
+		options.Depth = defaultTreeDepth
+	}
+	options.Depth = min(options.Depth, maxTreeDepth)
+	if options.Limit <= 0 {
+		options.Limit = defaultTreeLimit
+	}
+	options.Limit = min(options.Limit, maxTreeLimit)
+
+	// Ensure the path doesn't try to escape the collection directory
+	cleanSubPath := filepath.Clean(options.Path)
+	if cleanSubPath == ".." || filepath.IsAbs(cleanSubPath) || strings.HasPrefix(cleanSubPath, "../") {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "invalid path")
+	}
+	info, err := os.Stat(filepath.Join(collection.Path, cleanSubPath))
+	if os.IsNotExist(err) {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, "path does not exist")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "path is not a directory")
+	}
+
+	collectionRel, err := filepath.Rel(repo.LocalPath, collection.Path)
+	if err != nil {
+		return nil, err
+	}
+	if collectionRel == "." {
+		collectionRel = ""
+	}
+	walker := &treeWalker{
+		repoPath:      repo.LocalPath,
+		collection:    collection,
+		collectionRel: filepath.ToSlash(collectionRel),
+		matcher:       &ignore.Matcher{},
+		gitignores:    map[string]bool{},
+		versions:      s.versions,
+		leases:        s.fileLeaseService.ActiveLeases(repo.ID, collectionName),
+		workflows:     s.fileWorkflowService.Statuses(repo.ID, collection),
+		options:       options,
+	}
+	walker.statusMap = s.draftStatus(repo, collection)
+
+	// The rules of the directories above the listed one apply to it as well
+	if err := walker.matcher.AddFile("", filepath.Join(repo.LocalPath, ".git", "info", "exclude")); err != nil {
+		return nil, err
+	}
+	relPath := filepath.ToSlash(cleanSubPath)
+	if relPath == "." {
+		relPath = ""
+	}
+	dir := ""
+	for _, part := range strings.Split(walker.repoRel(relPath), "/") {
+		if err := walker.addGitignore(dir); err != nil {
+			return nil, err
+		}
+		dir = ignore.Join(dir, part)
+	}
+	walker.matcher.AddPatterns(walker.collectionRel, collection.Exclude)
+
+	root := &TreeNode{FileInfo: FileInfo{Name: info.Name(), Path: relPath, IsDir: true, ModTime: info.ModTime()}}
+	if err := walker.fill(root, options.Depth, options.Cursor); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// repoRel converts a path relative to the collection into one relative to the repository root
+func (w *treeWalker) repoRel(rel string) string {
+	if rel == "" {
+		return w.collectionRel
+	}
+	return ignore.Join(w.collectionRel, rel)
+}
+
+// addGitignore adds the .gitignore file of a directory relative to the repository root, once
+func (w *treeWalker) addGitignore(dir string) error {
+	if dir == "." {
+		dir = ""
+	}
+	if w.gitignores[dir] {
+		return nil
+	}
+	w.gitignores[dir] = true
+	return w.matcher.AddFile(dir, filepath.Join(w.repoPath, filepath.FromSlash(dir), ".gitignore"))
+}
+
+// fill lists the children of a directory node and expands subdirectories while depth allows
+func (w *treeWalker) fill(node *TreeNode, depth int, cursor string) error {
+	if err := w.addGitignore(w.repoRel(node.Path)); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(filepath.Join(w.collection.Path, filepath.FromSlash(node.Path)))
+	if err != nil {
+		return err
+	}
+
+	children := []*TreeNode{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		childPath := ignore.Join(node.Path, entry.Name())
+		if w.matcher.Match(w.repoRel(childPath), entry.IsDir()) {
+			continue
+		}
+		if !entry.IsDir() {
+			isFormat := w.collection.Format.HasExtension(entry.Name())
+			if (w.options.Include == TreeIncludeFormat && !isFormat) || (w.options.Include == TreeIncludeAssets && isFormat) {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		child := &TreeNode{FileInfo: FileInfo{
+			Name:     entry.Name(),
+			Path:     childPath,
+			IsDir:    entry.IsDir(),
+			IsDraft:  w.statusMap[childPath],
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Lease:    w.leases[childPath],
+			Workflow: w.workflows.Of(childPath, entry.IsDir()),
+		}}
+		if !entry.IsDir() {
+			child.Extension = filepath.Ext(entry.Name())
+			child.ETag, _ = w.versions.fileETag(filepath.Join(w.collection.Path, filepath.FromSlash(childPath)), info.Size(), info.ModTime())
+		}
+		children = append(children, child)
+	}
+
+	// Same order as ListFilesInPath, directories first and then names in reverse order
+	sort.Slice(children, func(i, j int) bool {
+		return treeNodeLess(children[i].IsDir, children[i].Name, children[j].IsDir, children[j].Name)
+	})
+
+	start := 0
+	if cursor != "" {
+		isDir, name, err := decodeTreeCursor(cursor)
+		if err != nil {
+			return err
+		}
+		start = sort.Search(len(children), func(i int) bool {
+			return treeNodeLess(isDir, name, children[i].IsDir, children[i].Name)
+		})
+	}
+	end := min(start+w.options.Limit, len(children))
+	if end < len(children) {
+		last := children[end-1]
+		node.NextCursor = encodeTreeCursor(last.IsDir, last.Name)
+	}
+	node.Loaded = true
+	node.Children = children[start:end]
+
+	if depth <= 1 {
+		return nil
+	}
+	for _, child := range node.Children {
+		if child.IsDir {
+			if err := w.fill(child, depth-1, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func treeNodeLess(aIsDir bool, aName string, bIsDir bool, bName string) bool {
+	if aIsDir != bIsDir {
+		return aIsDir
+	}
+	return aName > bName
+}
+
+// encodeTreeCursor marks the last child of a page, the next page starts after it even if it was deleted
+func encodeTreeCursor(isDir bool, name string) string {
+	kind := "f"
+	if isDir {
+		kind = "d"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + name))
+}
+
+func decodeTreeCursor(cursor string) (bool, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return false, "", core.NewHTTPErrorStr(http.StatusBadRequest, "invalid cursor")
+	}
+	kind, name, ok := strings.Cut(string(data), ":")
+	if !ok || (kind != "d" && kind != "f") {
+		return false, "", core.NewHTTPErrorStr(http.StatusBadRequest, "invalid cursor")
+	}
+	return kind == "d", name, nil
+}
+
 // GetFileContent retrieves the content of a file within a collection
 func (s *UserGitRepoCollectionService) GetFileContent(repo *models.UserGitRepo, collectionName string, filePath string) ([]byte, string, error) {
 	// Get the collection
@@ -443,6 +856,8 @@ func (s *UserGitRepoCollectionService) GetFileContent(repo *models.UserGitRepo,
 		contentType = "application/javascript"
 	case ".json":
 		contentType = "application/json"
+	case ".yml", ".yaml":
+		contentType = "application/yaml"
 	case ".xml":
 		contentType = "application/xml"
 	case ".md":
@@ -474,8 +889,26 @@ func (s *UserGitRepoCollectionService) handleMarkdown(repo *models.UserGitRepo,
 	return s.mdHandler.Handle(config.MDConfig, content, direction)
 }
 
+// WriteCondition is what a change of an existing file is based on
+type WriteCondition struct {
+	// IfMatch is the ETag the change is based on, the change fails with 412 when the file has changed since
+	IfMatch string
+	// MustNotExist is set by If-None-Match: *, the change fails with 412 when the file exists
+	MustNotExist bool
+	// UserID and LeaseID identify the editor, the change is refused while someone else holds an edit lease
+	UserID  string
+	LeaseID string
+}
+
+// UpdateFileOptions controls how UpdateFileContent treats the front matter of a markdown file
+type UpdateFileOptions struct {
+	WriteCondition
+	// ApplyDefaults fills missing optional fields from the defaults in the collection fields
+	ApplyDefaults bool
+}
+
 // UpdateFileContent updates the content of a file within a collection
-func (s *UserGitRepoCollectionService) UpdateFileContent(repo *models.UserGitRepo, collectionName string, filePath string, content []byte, isDraft *bool) error {
+func (s *UserGitRepoCollectionService) UpdateFileContent(ctx context.Context, repo *models.UserGitRepo, collectionName string, filePath string, content []byte, options UpdateFileOptions) error {
 	// Get the collection
 	collection, err := s.GetCollectionByName(repo, collectionName)
 	if err != nil {
@@ -488,14 +921,38 @@ func (s *UserGitRepoCollectionService) UpdateFileContent(repo *models.UserGitRep
 		return errors.New("invalid path")
 	}
 
+	frontMatterOptions := schema.FrontMatterOptions{
+		ApplyDefaults: options.ApplyDefaults,
+		ReferenceExists: func(collectionName string, value string) bool {
+			return s.referenceExists(repo, collectionName, value)
+		},
+	}
 	ext := filepath.Ext(filePath)
 	if ext == ".md" {
+		// The front matter must satisfy the fields declared for the collection in veda/config.yml
+		content, err = schema.ValidateFrontMatter(collection.Fields, content, frontMatterOptions)
+		if err != nil {
+			return err
+		}
 		content = s.handleMarkdown(repo, content, md.DirectionWrite)
+	} else if collection.Format.IsData() && collection.Format.HasExtension(filePath) {
+		// Every entry of a data file is validated like front matter
+		content, err = schema.ValidateData(collection.Fields, schema.LayoutOf(collection), content, frontMatterOptions)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Construct the full path
 	fullPath := filepath.Join(collection.Path, cleanFilePath)
 
+	// Edits of the config itself must not break every collection of the repository
+	if fullPath == filepath.Join(repo.LocalPath, "veda", "config.yml") {
+		if err := validateVedaConfig(content); err != nil {
+			return core.NewHTTPErrorStr(http.StatusBadRequest, err.Error())
+		}
+	}
+
 	// Check if the file exists and validate
 	fileInfo, err := os.Stat(fullPath)
 	isNewFile := err != nil && os.IsNotExist(err)
@@ -507,6 +964,12 @@ func (s *UserGitRepoCollectionService) UpdateFileContent(repo *models.UserGitRep
 	if err == nil && fileInfo.IsDir() {
 		return errors.New("cannot update a directory")
 	}
+	if err := s.checkWrite(repo, collectionName, fullPath, cleanFilePath, options.WriteCondition); err != nil {
+		return err
+	}
+	if err := s.checkPublish(repo, collection, fullPath, cleanFilePath, content, isNewFile); err != nil {
+		return err
+	}
 
 	// Create parent directories if they don't exist
 	parentDir := filepath.Dir(fullPath)
@@ -515,6 +978,7 @@ func (s *UserGitRepoCollectionService) UpdateFileContent(repo *models.UserGitRep
 	}
 
 	// Write the content to the file
+	s.versions.forget(fullPath)
 	if err := os.WriteFile(fullPath, content, 0644); err != nil {
 		return err
 	}
@@ -525,188 +989,1199 @@ func (s *UserGitRepoCollectionService) UpdateFileContent(repo *models.UserGitRep
 		commitMsg = fmt.Sprintf("Create new file %s in collection %s", cleanFilePath, collectionName)
 	}
 
-	// Commit the changes
-	if err := s.CommitWithGithubApp(*repo, commitMsg); err != nil {
-		return fmt.Errorf("failed to commit changes: %v", err)
+	// Commit the changes, the comment threads of the file follow the commit even when the push fails
+	head := s.fileCommentService.Head(ctx, repo)
+	commitErr := s.CommitWithGithubApp(ctx, *repo, commitMsg)
+	if err := s.fileCommentService.Remap(ctx, repo, head, "HEAD"); err != nil {
+		log.Errorf("Failed to re-map the comment threads of %s: %v", cleanFilePath, err)
 	}
-
-	if isDraft != nil {
-		_ = s.userFileDraftStatusService.SetDraftStatus(repo.UserID, repo.ID, collectionName, cleanFilePath, *isDraft)
+	if commitErr != nil {
+		return fmt.Errorf("failed to commit changes: %w", commitErr)
 	}
 
+	_ = s.reconcileDrafts(repo, collection)
+
 	return nil
 }
 
-// DeleteFile deletes a file or directory within a collection
-func (s *UserGitRepoCollectionService) DeleteFile(repo *models.UserGitRepo, collectionName string, filePath string) error {
-	// Get the collection
-	collection, err := s.GetCollectionByName(repo, collectionName)
-	if err != nil {
-		return err
+// checkPublish refuses a write that publishes an entry of a collection whose workflow requires approvals, until
+// the approvals exist. An entry is published when a draft, or a new file, is written without the draft key. In
+// the folder mode entries are published by moving them out of the drafts folder, so only a new file written
+// outside of it publishes.
+func (s *UserGitRepoCollectionService) checkPublish(repo *models.UserGitRepo, collection models.UserGitRepoCollection,
+	fullPath string, filePath string, content []byte, isNewFile bool) error {
+	if collection.Workflow == nil || collection.Workflow.RequiredApprovals <= 0 ||
+		collection.Entries == models.EntriesList || !collection.Format.HasExtension(filePath) {
+		return nil
+	}
+	if settings := scheduleOf(collection); settings.Mode == models.ScheduleModeFolder {
+		if !isNewFile || inDrafts(settings, filepath.ToSlash(filePath)) {
+			return nil
+		}
+		return s.fileWorkflowService.CheckPublish(repo.ID, collection, filePath)
 	}
-
-	// Ensure the filePath doesn't try to escape the collection directory
-	cleanFilePath := filepath.Clean(filePath)
-	if cleanFilePath == ".." || filepath.IsAbs(cleanFilePath) || strings.HasPrefix(cleanFilePath, "../") {
-		return errors.New("invalid path")
+	if isDraftContent(collection, filePath, content) {
+		return nil
+	}
+	if !isNewFile {
+		if existing, err := os.ReadFile(fullPath); err == nil && !isDraftContent(collection, filePath, existing) {
+			return nil
+		}
 	}
+	return s.fileWorkflowService.CheckPublish(repo.ID, collection, filePath)
+}
 
-	// Construct the full path
-	fullPath := filepath.Join(collection.Path, cleanFilePath)
+// checkPublishMove refuses a move out of the drafts folder of a folder mode collection, which publishes the
+// entries moved, until the approvals of each of them exist
+func (s *UserGitRepoCollectionService) checkPublishMove(repo *models.UserGitRepo, collection models.UserGitRepoCollection,
+	from string, to string) error {
+	settings := scheduleOf(collection)
+	if settings.Mode != models.ScheduleModeFolder || collection.Workflow == nil || collection.Workflow.RequiredApprovals <= 0 ||
+		collection.Entries == models.EntriesList {
+		return nil
+	}
+	if !inDrafts(settings, filepath.ToSlash(from)) || inDrafts(settings, filepath.ToSlash(to)) {
+		return nil
+	}
+	return filepath.WalkDir(filepath.Join(collection.Path, from), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(collection.Path, p)
+		if err != nil || !collection.Format.HasExtension(rel) {
+			return err
+		}
+		return s.fileWorkflowService.CheckPublish(repo.ID, collection, rel)
+	})
+}
 
-	// Check if the path exists
-	_, err = os.Stat(fullPath)
-	if os.IsNotExist(err) {
-		return errors.New("file or directory does not exist")
+// isDraftContent tells whether the front matter of an entry sets the draft key of its collection, content that
+// does not parse counts as a draft
+func isDraftContent(collection models.UserGitRepoCollection, filePath string, content []byte) bool {
+	var doc *schema.Document
+	var err error
+	if collection.Format.IsData() {
+		doc, err = schema.ParseDataDocument(collection.Fields, schema.LayoutOf(collection), content, "")
+	} else {
+		doc, err = schema.ParseDocument(collection.Fields, content)
 	}
 	if err != nil {
-		return err
-	}
-
-	// Delete the file or directory
-	if err := os.RemoveAll(fullPath); err != nil {
-		return err
+		return true
 	}
-
-	// Commit the changes
-	if err := s.CommitWithGithubApp(*repo, fmt.Sprintf("Delete %s from collection %s", filePath, collectionName)); err != nil {
-		return fmt.Errorf("failed to commit changes: %v", err)
+	entry := &IndexEntry{}
+	if err := json.Unmarshal(doc.FrontMatter, &entry.FrontMatter); err != nil {
+		return true
 	}
-
-	_ = s.userFileDraftStatusService.SetDraftStatus(repo.UserID, repo.ID, collectionName, cleanFilePath, false)
-
-	return nil
+	return entry.IsDraft(collection.DraftFrontMatterKey())
 }
 
-func (s *UserGitRepoCollectionService) GetRepo(repoID uint) (models.UserGitRepo, error) {
-	var repo models.UserGitRepo
-	if err := database.DB.Preload("User").First(&repo, repoID).Error; err != nil {
-		return models.UserGitRepo{}, errors.New("repository not found")
+// SetDraft sets the draft key in the front matter of an entry and commits it. It reports false, without a
+// commit, when the entry already is in that state.
+func (s *UserGitRepoCollectionService) SetDraft(ctx context.Context, repo *models.UserGitRepo, collection models.UserGitRepoCollection,
+	filePath string, draft bool, condition WriteCondition) (bool, error) {
+	doc, err := s.GetDocument(repo, collection.Name, filePath, "")
+	if err != nil {
+		return false, err
 	}
-	return repo, nil
-}
-
-// CommitWithGithubApp commits changes using GitHub app authentication
-func (s *UserGitRepoCollectionService) CommitWithGithubApp(repo models.UserGitRepo, message string) error {
-	// Get installation token
-	opts := &github.InstallationTokenOptions{
-		RepositoryIDs: []int64{repo.GitRepoID},
-		Permissions: &github.InstallationPermissions{
-			Contents: github.String("write"),
-			Metadata: github.String("read"),
-		},
+	entry := &IndexEntry{}
+	if err := json.Unmarshal(doc.FrontMatter, &entry.FrontMatter); err != nil {
+		return false, err
 	}
-
-	// Get an installation token
-	token, _, err := s.ctx.GithubAppClient.Apps.CreateInstallationToken(context.Background(), repo.InstallationID, opts)
-
+	draftKey := collection.DraftFrontMatterKey()
+	if entry.IsDraft(draftKey) == draft {
+		return false, nil
+	}
+	patch, err := draftPatch(draftKey, draft)
 	if err != nil {
-		log.Errorf("Failed to get installation token: %v", err)
-		return fmt.Errorf("failed to get installation token: %v", err)
+		return false, err
 	}
-
-	// Set up git config with token
-	remoteURL := fmt.Sprintf("https://x-access-token:%s@%s", token.GetToken(), repo.RemoteURL[8:])
-	cmd := exec.Command("git", "-C", repo.LocalPath, "remote", "set-url", "origin", remoteURL)
-	if output, err := cmd.CombinedOutput(); err != nil {
-
-		log.Errorf("Failed to configure git with token: %s", string(output))
-		return fmt.Errorf("failed to configure git with token: %v", err)
+	options := UpdateFileOptions{WriteCondition: condition}
+	if _, err := s.UpdateDocument(ctx, repo, collection.Name, filePath, "", patch, nil, true, options); err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	// Check if there are any changes
-	statusCmd := exec.Command("git", "-C", repo.LocalPath, "status", "--porcelain")
-	output, err := statusCmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to check git status: %v", err)
+// draftPatch is a JSON merge patch setting a dotted front matter key
+func draftPatch(draftKey string, draft bool) (json.RawMessage, error) {
+	var patch interface{} = draft
+	parts := strings.Split(draftKey, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		patch = map[string]interface{}{parts[i]: patch}
 	}
+	return json.Marshal(patch)
+}
 
-	needPush := len(output) > 0
-	// If no changes, return early
-	if needPush {
-		// Add all changes
-		cmd = exec.Command("git", "-C", repo.LocalPath, "add", ".")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			log.Errorf("Failed to stage changes: %s", string(output))
-			return fmt.Errorf("failed to stage changes: %v", err)
+// reconcileDrafts records the draft files of a collection from the draft key of their front matter, the caller
+// must hold a lock of the repository. Entries of list collections are items, their files are never drafts.
+func (s *UserGitRepoCollectionService) reconcileDrafts(repo *models.UserGitRepo, collection models.UserGitRepoCollection) error {
+	drafts := map[string]bool{}
+	if collection.Entries != models.EntriesList {
+		entries, err := s.collectionIndexService.Entries(repo, collection)
+		if err != nil {
+			log.Errorf("Failed to read the draft status of collection %s: %v", collection.Name, err)
+			return err
 		}
-
-		// Commit changes
-		cmd = exec.Command("git", "-C", repo.LocalPath, "commit", "-m", message)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			log.Errorf("Failed to commit changes: %s", string(output))
-			return fmt.Errorf("failed to commit changes: %v", err)
+		draftKey := collection.DraftFrontMatterKey()
+		for _, entry := range entries {
+			if entry.IsDraft(draftKey) {
+				drafts[entry.Path] = true
+			}
 		}
 	}
-
-	// Push changes
-	cmd = exec.Command("git", "-C", repo.LocalPath, "push", "origin", repo.Branch)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		log.Errorf("Failed to push changes: %s", string(output))
-		return fmt.Errorf("failed to push changes: %v", err)
+	if err := s.fileDraftStatusService.Reconcile(repo.ID, collection.Name, drafts); err != nil {
+		log.Errorf("Failed to record the draft status of collection %s: %v", collection.Name, err)
+		return err
 	}
-
+	s.reconciledDrafts.Store(fmt.Sprintf("%d:%s", repo.ID, collection.Name), true)
 	return nil
 }
 
-// RenameFile renames a file in a collection
-func (s *UserGitRepoCollectionService) RenameFile(repo *models.UserGitRepo, collectionName string, oldPath string, newPath string) error {
-	// Get collection info
-	collection, err := s.GetCollectionByName(repo, collectionName)
+// draftStatus returns the draft files of a collection by path. The first listing of a collection after the
+// server started reads them from the front matter, files may have changed while it was down.
+func (s *UserGitRepoCollectionService) draftStatus(repo *models.UserGitRepo, collection models.UserGitRepoCollection) map[string]bool {
+	if _, ok := s.reconciledDrafts.Load(fmt.Sprintf("%d:%s", repo.ID, collection.Name)); !ok {
+		_ = s.reconcileDrafts(repo, collection)
+	}
+	statusMap, err := s.fileDraftStatusService.GetDraftStatus(repo.ID, collection.Name)
 	if err != nil {
-		return err
+		log.Errorf("failed to get draft status: %v", err)
 	}
+	return statusMap
+}
 
-	// Clean and validate paths
-	cleanOldPath := filepath.Clean(oldPath)
-	cleanNewPath := filepath.Clean(newPath)
-
-	// Ensure paths are within collection directory
-	//if !strings.HasPrefix(cleanOldPath, collectionName+"/") || !strings.HasPrefix(cleanNewPath, collectionName+"/") {
-	//	return errors.New("invalid file path")
-	//}
-
-	// Construct full paths
-	oldFullPath := filepath.Join(collection.Path, cleanOldPath)
-	newFullPath := filepath.Join(collection.Path, cleanNewPath)
-
-	// Check if source file exists and is not a directory
-	fileInfo, err := os.Stat(oldFullPath)
+// ReconcileDraftStatus reads the draft status of the files of every collection of a repository from their
+// front matter, after changes that did not go through the editor. The caller must hold a lock of the repository.
+func (s *UserGitRepoCollectionService) ReconcileDraftStatus(repo *models.UserGitRepo) error {
+	collections, err := s.GetCollectionsByRepo(repo)
 	if err != nil {
 		return err
 	}
-	if fileInfo.IsDir() {
-		return errors.New("cannot rename a directory")
+	for _, collection := range collections {
+		if err := s.reconcileDrafts(repo, collection); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Check if target path doesn't exist
-	if _, err := os.Stat(newFullPath); err == nil {
-		return errors.New("destination file already exists")
-	}
+// CollectionEntry is a collection file, or an item of a list file, returned by QueryEntries
+type CollectionEntry struct {
+	Path        string                 `json:"path"`
+	Item        string                 `json:"item,omitempty"`
+	Name        string                 `json:"name"`
+	IsDraft     bool                   `json:"is_draft"`
+	Size        int64                  `json:"size"`
+	ModTime     time.Time              `json:"mod_time"`
+	ETag        string                 `json:"etag,omitempty"`
+	Lease       *models.FileEditLease  `json:"lease,omitempty"`
+	Workflow    *models.WorkflowStatus `json:"workflow,omitempty"`
+	FrontMatter map[string]interface{} `json:"front_matter"`
+}
 
-	// Create parent directories for the new path if they don't exist
-	parentDir := filepath.Dir(newFullPath)
-	if err := os.MkdirAll(parentDir, 0755); err != nil {
-		return err
-	}
+// CollectionEntryPage is one page of QueryEntries results
+type CollectionEntryPage struct {
+	Entries    []CollectionEntry `json:"entries"`
+	Total      int               `json:"total"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
 
-	// Rename the file
-	if err := os.Rename(oldFullPath, newFullPath); err != nil {
+// draftIndexEntry adds the draft status read from the draft key of the front matter, it can be queried as _draft
+type draftIndexEntry struct {
+	*IndexEntry
+	isDraft bool
+}
+
+func (e draftIndexEntry) Get(field string) (interface{}, bool) {
+	if field == "_draft" {
+		return e.isDraft, true
+	}
+	return e.IndexEntry.Get(field)
+}
+
+// QueryEntries filters, sorts and pages the entries of a collection using the collection index
+func (s *UserGitRepoCollectionService) QueryEntries(repo *models.UserGitRepo, collectionName string, q *query.Query) (*CollectionEntryPage, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.collectionIndexService.Entries(repo, collection)
+	if err != nil {
+		return nil, err
+	}
+	leases := s.fileLeaseService.ActiveLeases(repo.ID, collectionName)
+	workflows := s.fileWorkflowService.Statuses(repo.ID, collection)
+
+	draftKey := collection.DraftFrontMatterKey()
+	items := make([]draftIndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, draftIndexEntry{IndexEntry: entry, isDraft: entry.IsDraft(draftKey)})
+	}
+	page, err := query.Run(q, items)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CollectionEntryPage{Entries: []CollectionEntry{}, Total: page.Total, NextCursor: page.NextCursor}
+	for _, item := range page.Items {
+		frontMatter := item.FrontMatter
+		if len(q.Fields) > 0 {
+			frontMatter = map[string]interface{}{}
+			for _, field := range q.Fields {
+				if value, ok := item.FrontMatter[field]; ok {
+					frontMatter[field] = value
+				}
+			}
+		}
+		etag, _ := s.versions.fileETag(filepath.Join(collection.Path, filepath.FromSlash(item.Path)), item.Size, item.ModTime)
+		result.Entries = append(result.Entries, CollectionEntry{
+			Path:        item.Path,
+			Item:        item.Item,
+			Name:        item.Name,
+			IsDraft:     item.isDraft,
+			Size:        item.Size,
+			ModTime:     item.ModTime,
+			ETag:        etag,
+			Lease:       leases[filepath.FromSlash(item.Path)],
+			Workflow:    workflows.Of(item.Path, false),
+			FrontMatter: frontMatter,
+		})
+	}
+	return result, nil
+}
+
+// FieldOption is a candidate value of a select, reference, image or boolean field
+type FieldOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// referenceExists reports whether value names an entry of the collection: a file, whose extension may be
+// omitted, or the item of a list file
+func (s *UserGitRepoCollectionService) referenceExists(repo *models.UserGitRepo, collectionName string, value string) bool {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return false
+	}
+	if collection.Entries == models.EntriesList {
+		entries, err := s.collectionIndexService.Entries(repo, collection)
+		if err != nil {
+			return false
+		}
+		for _, entry := range entries {
+			if entry.Item == value {
+				return true
+			}
+		}
+		return false
+	}
+	cleanPath := filepath.Clean(filepath.FromSlash(value))
+	if cleanPath == ".." || filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, ".."+string(filepath.Separator)) {
+		return false
+	}
+	candidates := []string{cleanPath}
+	for _, ext := range collection.Format.Extensions() {
+		candidates = append(candidates, cleanPath+ext)
+	}
+	for _, candidate := range candidates {
+		if fi, err := os.Stat(filepath.Join(collection.Path, candidate)); err == nil && !fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// findField resolves a field by name, subfields of object fields are addressed as "parent.child"
+func findField(fields []models.Field, fieldPath string) (models.Field, bool) {
+	name, rest, nested := strings.Cut(fieldPath, ".")
+	for _, field := range fields {
+		if field.Name != name {
+			continue
+		}
+		if !nested {
+			return field, true
+		}
+		return findField(field.Fields, rest)
+	}
+	return models.Field{}, false
+}
+
+// ListFieldOptions returns the values a field of a collection can take: the options of a select field,
+// the entries of the referenced collection or the images below the image path
+func (s *UserGitRepoCollectionService) ListFieldOptions(repo *models.UserGitRepo, collectionName string, fieldPath string) ([]FieldOption, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	field, ok := findField(collection.Fields, fieldPath)
+	if !ok {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("field %s not found in collection %s", fieldPath, collectionName))
+	}
+
+	options := []FieldOption{}
+	switch field.Type {
+	case "select":
+		for _, option := range field.Options {
+			options = append(options, FieldOption{Value: option, Label: option})
+		}
+	case "boolean":
+		options = append(options, FieldOption{Value: "true", Label: "true"}, FieldOption{Value: "false", Label: "false"})
+	case "reference":
+		target, err := s.GetCollectionByName(repo, field.Collection)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := s.collectionIndexService.Entries(repo, target)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// Items of list files, like the authors of a blog, are referenced by their key
+			value, label := entry.Path, strings.TrimSuffix(entry.Name, filepath.Ext(entry.Name))
+			if entry.Item != "" {
+				value, label = entry.Item, entry.Item
+			}
+			for _, key := range []string{"title", "name"} {
+				if text, ok := entry.FrontMatter[key].(string); ok && text != "" {
+					label = text
+					break
+				}
+			}
+			options = append(options, FieldOption{Value: value, Label: label})
+		}
+	case "image":
+		root := collection.Path
+		if field.Path != "" {
+			root = filepath.Join(repo.LocalPath, filepath.FromSlash(field.Path))
+		}
+		err = walkVisibleFiles(root, func(path string, rel string) {
+			if !isImageFile(path) {
+				return
+			}
+			value, err := filepath.Rel(collection.Path, path)
+			if err != nil {
+				return
+			}
+			options = append(options, FieldOption{Value: filepath.ToSlash(value), Label: rel})
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("field %s of type %s has no options", fieldPath, field.Type))
+	}
+	return options, nil
+}
+
+// walkVisibleFiles calls fn for every file below root that is not inside a hidden directory,
+// rel is the slash separated path relative to root
+func walkVisibleFiles(root string, fn func(path string, rel string)) error {
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fn(path, filepath.ToSlash(rel))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func isImageFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, imageExt := range schema.ImageExtensions {
+		if ext == imageExt {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDocument returns the front matter and body of a markdown file. For yaml and json collections the
+// front matter is the entry stored in the file, or the entry named by item when entries are list items.
+func (s *UserGitRepoCollectionService) GetDocument(repo *models.UserGitRepo, collectionName string, filePath string, item string) (*schema.Document, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	isData, err := documentKind(collection, filePath)
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := s.GetFileContent(repo, collectionName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if isData {
+		return schema.ParseDataDocument(collection.Fields, schema.LayoutOf(collection), content, item)
+	}
+	if item != "" {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "item is only supported for yaml and json collections")
+	}
+	doc, err := schema.ParseDocument(collection.Fields, content)
+	if err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusUnprocessableEntity, err.Error())
+	}
+	return doc, nil
+}
+
+// documentKind tells whether a file is a data file of a yaml or json collection or a markdown file,
+// other files have no structured document
+func documentKind(collection models.UserGitRepoCollection, filePath string) (bool, error) {
+	if collection.Format.IsData() && collection.Format.HasExtension(filePath) {
+		return true, nil
+	}
+	if filepath.Ext(filePath) != ".md" {
+		return false, core.NewHTTPErrorStr(http.StatusBadRequest, "only markdown files and the data files of yaml and json collections have front matter")
+	}
+	return false, nil
+}
+
+// UpdateDocument writes the front matter and body of a markdown file, or an entry of a data file, and commits
+// it. With mergePatch the front matter is a JSON merge patch applied to the current one and the file must exist.
+// Entries are appended to the list of a list collection with item schema.AppendItem, the returned
+// document is the one written.
+func (s *UserGitRepoCollectionService) UpdateDocument(ctx context.Context, repo *models.UserGitRepo, collectionName string, filePath string,
+	item string, frontMatter json.RawMessage, body *string, mergePatch bool, options UpdateFileOptions) (*schema.Document, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	isData, err := documentKind(collection, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !isData && item != "" {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "item is only supported for yaml and json collections")
+	}
+
+	var existing []byte
+	if _, err := os.Stat(filepath.Join(collection.Path, filepath.Clean(filePath))); err == nil {
+		existing, _, err = s.GetFileContent(repo, collectionName, filePath)
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else if mergePatch {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, "file does not exist")
+	}
+
+	var content []byte
+	if isData {
+		content, item, err = schema.ComposeDataDocument(collection.Fields, schema.LayoutOf(collection), existing, item, frontMatter, mergePatch)
+	} else {
+		content, err = schema.ComposeDocument(collection.Fields, existing, frontMatter, body, mergePatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.UpdateFileContent(ctx, repo, collectionName, filePath, content, options); err != nil {
+		return nil, err
+	}
+	return s.GetDocument(repo, collectionName, filePath, item)
+}
+
+// CreatedEntry is a file created by CreateEntry
+type CreatedEntry struct {
+	Path string `json:"path"`
+	*schema.Document
+}
+
+// entryDateLayouts are the front matter date formats the date file name generators understand
+var entryDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// CreateEntry creates a markdown or data file in dir, relative to the collection, named by the file name generator
+// of the collection. The title and date of the front matter feed the generator, a taken name gets a suffix.
+func (s *UserGitRepoCollectionService) CreateEntry(ctx context.Context, repo *models.UserGitRepo, collectionName string, dir string,
+	frontMatter json.RawMessage, body *string, options UpdateFileOptions) (*CreatedEntry, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	layout := schema.LayoutOf(collection)
+	if layout.List {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest,
+			fmt.Sprintf("entries of collection %s are list items, they are added to a file as item %s", collectionName, schema.AppendItem))
+	}
+
+	// Ensure the dir doesn't try to escape the collection directory
+	cleanDir := filepath.Clean(dir)
+	if cleanDir == ".." || filepath.IsAbs(cleanDir) || strings.HasPrefix(cleanDir, "../") {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "invalid path")
+	}
+	if cleanDir == "." {
+		cleanDir = ""
+	}
+
+	var content []byte
+	var doc *schema.Document
+	if collection.Format.IsData() {
+		content, _, err = schema.ComposeDataDocument(collection.Fields, layout, nil, "", frontMatter, false)
+		if err == nil {
+			doc, err = schema.ParseDataDocument(collection.Fields, layout, content, "")
+		}
+	} else {
+		content, err = schema.ComposeDocument(collection.Fields, nil, frontMatter, body, false)
+		if err == nil {
+			doc, err = schema.ParseDocument(collection.Fields, content)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(doc.FrontMatter, &values); err != nil {
+		return nil, err
+	}
+	filePath, err := generateEntryPath(collection, cleanDir, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// The generated path is free, a version sent along does not apply to it
+	options.IfMatch = ""
+	if err := s.UpdateFileContent(ctx, repo, collectionName, filePath, content, options); err != nil {
+		return nil, err
+	}
+	created, err := s.GetDocument(repo, collectionName, filePath, "")
+	if err != nil {
+		return nil, err
+	}
+	return &CreatedEntry{Path: filePath, Document: created}, nil
+}
+
+// generateEntryPath names a new entry in dir with the file name generator of the collection, the title
+// and date of the front matter values feed the generator
+func generateEntryPath(collection models.UserGitRepoCollection, dir string, values map[string]interface{}) (string, error) {
+	input := filename.Input{
+		Ext: collection.Format.Extensions()[0],
+		Exists: func(p string) bool {
+			_, err := os.Stat(filepath.Join(collection.Path, filepath.FromSlash(p)))
+			return err == nil
+		},
+	}
+	input.Title, _ = values["title"].(string)
+	if date, ok := values["date"].(string); ok {
+		for _, layout := range entryDateLayouts {
+			if t, err := time.Parse(layout, date); err == nil {
+				input.Date = t
+				break
+			}
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(collection.Path, dir))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	for _, entry := range entries {
+		input.Siblings = append(input.Siblings, entry.Name())
+	}
+
+	generator := filename.Generator{}
+	if collection.FileNameGenerator != nil {
+		generator = filename.Generator{Type: collection.FileNameGenerator.Type, First: collection.FileNameGenerator.First}
+	}
+	filePath, err := generator.Generate(filepath.ToSlash(dir), input)
+	if err != nil {
+		return "", core.NewHTTPErrorStr(http.StatusConflict, err.Error())
+	}
+	return filePath, nil
+}
+
+// DeleteFile deletes a file or directory within a collection
+func (s *UserGitRepoCollectionService) DeleteFile(ctx context.Context, repo *models.UserGitRepo, collectionName string, filePath string, condition WriteCondition) error {
+	// Get the collection
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the filePath doesn't try to escape the collection directory
+	cleanFilePath := filepath.Clean(filePath)
+	if cleanFilePath == ".." || filepath.IsAbs(cleanFilePath) || strings.HasPrefix(cleanFilePath, "../") {
+		return errors.New("invalid path")
+	}
+
+	// Construct the full path
+	fullPath := filepath.Join(collection.Path, cleanFilePath)
+
+	// Check if the path exists
+	_, err = os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return errors.New("file or directory does not exist")
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.checkWrite(repo, collectionName, fullPath, cleanFilePath, condition); err != nil {
+		return err
+	}
+
+	// Delete the file or directory
+	s.versions.forget(fullPath)
+	if err := os.RemoveAll(fullPath); err != nil {
 		return err
 	}
 
 	// Commit the changes
-	commitMsg := fmt.Sprintf("Rename file from %s to %s in collection %s", cleanOldPath, cleanNewPath, collectionName)
-	if err := s.CommitWithGithubApp(*repo, commitMsg); err != nil {
-		return fmt.Errorf("failed to commit changes: %v", err)
+	message := fmt.Sprintf("Delete %s from collection %s", filePath, collectionName)
+	if err := s.commitPathChanges(ctx, repo, collection, message, []PathMove{{From: cleanFilePath}}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// commitPathChanges commits files that were moved, or deleted when To is empty, and moves what is kept per path
+// along: edit leases, autosaves, workflows with their reviews and comment threads. They follow the commit even when only the push fails, the paths are
+// already changed in HEAD then and later syncs do not see the change again.
+func (s *UserGitRepoCollectionService) commitPathChanges(ctx context.Context, repo *models.UserGitRepo, collection models.UserGitRepoCollection,
+	message string, moves []PathMove) error {
+	head := readHeadSHA(repo.LocalPath)
+	commitErr := s.CommitWithGithubApp(ctx, *repo, message)
+	if commitErr != nil && readHeadSHA(repo.LocalPath) == head {
+		return fmt.Errorf("failed to commit changes: %w", commitErr)
+	}
+
+	if err := s.reconcileDrafts(repo, collection); err != nil {
+		log.Errorf("Failed to reconcile the draft status of collection %s: %v", collection.Name, err)
+	}
+	// The autosave service is looked up here because it is initialized after this service
+	autosaveService := s.ctx.MustGetService("autosaveService").(*AutosaveService)
+	for _, move := range moves {
+		if move.To == "" {
+			if err := s.fileLeaseService.DeletePath(repo.ID, collection.Name, move.From); err != nil {
+				log.Errorf("Failed to drop the edit leases of %s: %v", move.From, err)
+			}
+			if err := autosaveService.DeletePath(repo.ID, collection.Name, move.From); err != nil {
+				log.Errorf("Failed to drop the autosaves of %s: %v", move.From, err)
+			}
+			if err := s.fileWorkflowService.DeletePath(repo.ID, collection.Name, move.From); err != nil {
+				log.Errorf("Failed to drop the workflow of %s: %v", move.From, err)
+			}
+			if err := s.fileCommentService.DeletePath(repo.ID, collection.Name, move.From); err != nil {
+				log.Errorf("Failed to drop the comment threads of %s: %v", move.From, err)
+			}
+			continue
+		}
+		if err := s.fileLeaseService.MovePath(repo.ID, collection.Name, move.From, move.To); err != nil {
+			log.Errorf("Failed to move the edit leases of %s to %s: %v", move.From, move.To, err)
+		}
+		if err := autosaveService.MovePath(repo.ID, collection.Name, move.From, move.To); err != nil {
+			log.Errorf("Failed to move the autosaves of %s to %s: %v", move.From, move.To, err)
+		}
+		if err := s.fileWorkflowService.MovePath(repo.ID, collection.Name, move.From, move.To); err != nil {
+			log.Errorf("Failed to move the workflow of %s to %s: %v", move.From, move.To, err)
+		}
+		if err := s.fileCommentService.MovePath(repo.ID, collection.Name, move.From, move.To); err != nil {
+			log.Errorf("Failed to move the comment threads of %s to %s: %v", move.From, move.To, err)
+		}
+	}
+
+	if commitErr != nil {
+		return fmt.Errorf("failed to commit changes: %w", commitErr)
+	}
+	return nil
+}
+
+func (s *UserGitRepoCollectionService) GetRepo(repoID uint) (models.UserGitRepo, error) {
+	var repo models.UserGitRepo
+	if err := database.DB.Preload("User").First(&repo, repoID).Error; err != nil {
+		return models.UserGitRepo{}, errors.New("repository not found")
+	}
+	return repo, nil
+}
+
+// CommitWithGithubApp commits changes using GitHub app authentication
+func (s *UserGitRepoCollectionService) CommitWithGithubApp(ctx context.Context, repo models.UserGitRepo, message string) error {
+	// Set up git config with token
+	if err := s.setTokenRemote(ctx, repo); err != nil {
+		return err
+	}
+
+	// Check if there are any changes
+	output, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "status", "--porcelain")
+	if err != nil {
+		return fmt.Errorf("failed to check git status: %w", err)
+	}
+
+	needPush := len(output) > 0
+	// If no changes, return early
+	if needPush {
+		// Add all changes
+		if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "add", "."); err != nil {
+			log.Errorf("Failed to stage changes: %s", string(output))
+			return fmt.Errorf("failed to stage changes: %w", err)
+		}
+
+		// Commit changes
+		if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "commit", "-m", message); err != nil {
+			log.Errorf("Failed to commit changes: %s", string(output))
+			return fmt.Errorf("failed to commit changes: %w", err)
+		}
+		s.repoConfigCacheService.Invalidate(repo.ID)
+	}
+
+	// Push changes, transient network failures are retried and an expired token is replaced
+	err = s.ctx.Git.Retry(ctx, "git push", func(attempt int, lastFailure git.FailureClass) error {
+		if lastFailure == git.FailureAuth {
+			if err := s.setTokenRemote(ctx, repo); err != nil {
+				return err
+			}
+		}
+		output, err := s.ctx.Git.CombinedOutput(ctx, git.OpPush, repo.LocalPath, "push", "origin", repo.Branch)
+		if err != nil {
+			log.Errorf("Failed to push changes: %s", string(output))
+		}
+		return err
+	}, func(class git.FailureClass, err error) {
+		s.metricsService.IncrementGitRetries("push", string(class))
+	})
+	if err != nil {
+		s.metricsService.IncrementGitFailures("push", string(git.Classify(err)))
+		return fmt.Errorf("failed to push changes: %w", err)
 	}
 
-	_ = s.userFileDraftStatusService.RenameFile(repo.UserID, repo.ID, collectionName, cleanOldPath, cleanNewPath)
+	return nil
+}
 
+// setTokenRemote points origin at the repository using a fresh installation token
+func (s *UserGitRepoCollectionService) setTokenRemote(ctx context.Context, repo models.UserGitRepo) error {
+	opts := &github.InstallationTokenOptions{
+		RepositoryIDs: []int64{repo.GitRepoID},
+		Permissions: &github.InstallationPermissions{
+			Contents: github.String("write"),
+			Metadata: github.String("read"),
+		},
+	}
+
+	// Get an installation token
+	token, _, err := s.ctx.GithubAppClient.Apps.CreateInstallationToken(ctx, repo.InstallationID, opts)
+
+	if err != nil {
+		log.Errorf("Failed to get installation token: %v", err)
+		return fmt.Errorf("failed to get installation token: %v", err)
+	}
+
+	remoteURL := fmt.Sprintf("https://x-access-token:%s@%s", token.GetToken(), repo.RemoteURL[8:])
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "remote", "set-url", "origin", remoteURL); err != nil {
+
+		log.Errorf("Failed to configure git with token: %s", string(output))
+		return fmt.Errorf("failed to configure git with token: %w", err)
+	}
 	return nil
 }
 
-func (s *UserGitRepoCollectionService) CreateFolder(repo *models.UserGitRepo, name string, path string, folder string) error {
+// RenameFile renames or moves a file or directory in a collection
+func (s *UserGitRepoCollectionService) RenameFile(ctx context.Context, repo *models.UserGitRepo, collectionName string, oldPath string, newPath string, condition WriteCondition) error {
+	// Get collection info
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return err
+	}
+
+	// Clean and validate paths
+	cleanOldPath, err := cleanCollectionPath(oldPath)
+	if err != nil {
+		return err
+	}
+	cleanNewPath, err := cleanCollectionPath(newPath)
+	if err != nil {
+		return err
+	}
+	if err := s.checkWrite(repo, collectionName, filepath.Join(collection.Path, cleanOldPath), cleanOldPath, condition); err != nil {
+		return err
+	}
+	if err := s.checkPublishMove(repo, collection, cleanOldPath, cleanNewPath); err != nil {
+		return err
+	}
+
+	fileInfo, err := s.movePath(collection, cleanOldPath, cleanNewPath)
+	if err != nil {
+		return err
+	}
+
+	// Commit the changes
+	kind := "file"
+	if fileInfo.IsDir() {
+		kind = "directory"
+	}
+	commitMsg := fmt.Sprintf("Rename %s from %s to %s in collection %s", kind, cleanOldPath, cleanNewPath, collectionName)
+	if err := s.commitPathChanges(ctx, repo, collection, commitMsg, []PathMove{{From: cleanOldPath, To: cleanNewPath}}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkWrite verifies that nobody else holds an edit lease on a file or directory and that the change is
+// based on its current version. Without If-Match the change is refused only when the config requires it.
+func (s *UserGitRepoCollectionService) checkWrite(repo *models.UserGitRepo, collectionName string, fullPath string, displayPath string, condition WriteCondition) error {
+	if err := s.fileLeaseService.CheckWrite(repo.ID, collectionName, displayPath, condition.UserID, condition.LeaseID); err != nil {
+		return err
+	}
+	return s.versions.checkVersion(fullPath, displayPath, condition.IfMatch, condition.MustNotExist, s.ctx.Config.Concurrency.RequireIfMatch)
+}
+
+// FileETag returns the version of a file or directory of a collection, empty when it does not exist
+func (s *UserGitRepoCollectionService) FileETag(repo *models.UserGitRepo, collectionName string, filePath string) (string, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return "", err
+	}
+	cleanPath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	return s.versions.pathETag(filepath.Join(collection.Path, cleanPath))
+}
+
+// cleanCollectionPath cleans a path relative to a collection and rejects paths that leave it
+func cleanCollectionPath(p string) (string, error) {
+	cleanPath := filepath.Clean(p)
+	if cleanPath == "." || cleanPath == ".." || filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "../") {
+		return "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("invalid path %s", p))
+	}
+	return cleanPath, nil
+}
+
+// checkMove verifies that a file or directory can be moved from one cleaned path to another
+func checkMove(collection models.UserGitRepoCollection, from string, to string) (os.FileInfo, error) {
+	fileInfo, err := os.Stat(filepath.Join(collection.Path, from))
+	if os.IsNotExist(err) {
+		return nil, core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("%s does not exist", from))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("%s is already at its destination", from))
+	}
+	if fileInfo.IsDir() && strings.HasPrefix(to, from+"/") {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("cannot move directory %s into itself", from))
+	}
+	if _, err := os.Stat(filepath.Join(collection.Path, to)); err == nil {
+		return nil, core.NewHTTPErrorStr(http.StatusConflict, fmt.Sprintf("destination %s already exists", to))
+	}
+	return fileInfo, nil
+}
+
+// movePath moves a file or directory within a collection without committing
+func (s *UserGitRepoCollectionService) movePath(collection models.UserGitRepoCollection, from string, to string) (os.FileInfo, error) {
+	fileInfo, err := checkMove(collection, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create parent directories for the new path if they don't exist
+	newFullPath := filepath.Join(collection.Path, to)
+	if err := os.MkdirAll(filepath.Dir(newFullPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(filepath.Join(collection.Path, from), newFullPath); err != nil {
+		return nil, err
+	}
+	return fileInfo, nil
+}
+
+// CopyFile copies a file or directory within a collection and returns the path of the copy. Without newPath an
+// entry is named by the file name generator of the collection, other files and directories get a "-copy" suffix.
+func (s *UserGitRepoCollectionService) CopyFile(ctx context.Context, repo *models.UserGitRepo, collectionName string, filePath string, newPath string) (string, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return "", err
+	}
+	cleanFilePath, err := cleanCollectionPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	fileInfo, err := os.Stat(filepath.Join(collection.Path, cleanFilePath))
+	if os.IsNotExist(err) {
+		return "", core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("%s does not exist", cleanFilePath))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var cleanNewPath string
+	if newPath != "" {
+		if cleanNewPath, err = cleanCollectionPath(newPath); err != nil {
+			return "", err
+		}
+		if fileInfo.IsDir() && strings.HasPrefix(cleanNewPath, cleanFilePath+"/") {
+			return "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("cannot copy directory %s into itself", cleanFilePath))
+		}
+		if _, err := os.Stat(filepath.Join(collection.Path, cleanNewPath)); err == nil {
+			return "", core.NewHTTPErrorStr(http.StatusConflict, fmt.Sprintf("destination %s already exists", cleanNewPath))
+		}
+	} else if cleanNewPath, err = s.copyDestination(repo, collection, cleanFilePath, fileInfo); err != nil {
+		return "", err
+	}
+
+	if err := copyTree(filepath.Join(collection.Path, cleanFilePath), filepath.Join(collection.Path, cleanNewPath)); err != nil {
+		return "", err
+	}
+
+	commitMsg := fmt.Sprintf("Copy %s to %s in collection %s", cleanFilePath, cleanNewPath, collectionName)
+	if err := s.CommitWithGithubApp(ctx, *repo, commitMsg); err != nil {
+		return "", fmt.Errorf("failed to commit changes: %w", err)
+	}
+
+	_ = s.reconcileDrafts(repo, collection)
+
+	return cleanNewPath, nil
+}
+
+// copyDestination chooses the path of a copy next to the original
+func (s *UserGitRepoCollectionService) copyDestination(repo *models.UserGitRepo, collection models.UserGitRepoCollection, filePath string, fileInfo os.FileInfo) (string, error) {
+	dir := filepath.Dir(filePath)
+	if dir == "." {
+		dir = ""
+	}
+	if !fileInfo.IsDir() && collection.Format.HasExtension(filePath) && collection.Entries != models.EntriesList {
+		if doc, err := s.GetDocument(repo, collection.Name, filePath, ""); err == nil {
+			var values map[string]interface{}
+			if err := json.Unmarshal(doc.FrontMatter, &values); err == nil {
+				return generateEntryPath(collection, dir, values)
+			}
+		}
+	}
+
+	ext := ""
+	if !fileInfo.IsDir() {
+		ext = filepath.Ext(filePath)
+	}
+	stem := strings.TrimSuffix(filepath.Base(filePath), ext)
+	for i := 1; i <= 1000; i++ {
+		name := stem + "-copy" + ext
+		if i > 1 {
+			name = fmt.Sprintf("%s-copy-%d%s", stem, i, ext)
+		}
+		candidate := filepath.Join(dir, name)
+		if _, err := os.Stat(filepath.Join(collection.Path, candidate)); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+	return "", core.NewHTTPErrorStr(http.StatusConflict, fmt.Sprintf("no free name for a copy of %s", filePath))
+}
+
+// copyTree copies a file, or a directory with everything below it
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, info.Mode().Perm())
+	})
+}
+
+// selectedPaths cleans the paths of a bulk operation, drops duplicates and paths below another selected
+// directory, and verifies that every path exists
+func selectedPaths(collection models.UserGitRepoCollection, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, core.NewHTTPErrorStr(http.StatusBadRequest, "no paths selected")
+	}
+	var cleaned []string
+	for _, p := range paths {
+		cleanPath, err := cleanCollectionPath(p)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(filepath.Join(collection.Path, cleanPath)); os.IsNotExist(err) {
+			return nil, core.NewHTTPErrorStr(http.StatusNotFound, fmt.Sprintf("%s does not exist", cleanPath))
+		} else if err != nil {
+			return nil, err
+		}
+		cleaned = append(cleaned, cleanPath)
+	}
+
+	sort.Strings(cleaned)
+	var selected []string
+	for _, p := range cleaned {
+		if n := len(selected); n > 0 && (selected[n-1] == p || strings.HasPrefix(p, selected[n-1]+"/")) {
+			continue
+		}
+		selected = append(selected, p)
+	}
+	return selected, nil
+}
+
+// bulkVersions keys the ETags a bulk request was read with by cleaned path, paths without one are only
+// checked for edit leases unless If-Match is required
+func bulkVersions(versions map[string]string) map[string]string {
+	ifMatch := map[string]string{}
+	for p, etag := range versions {
+		if cleanPath, err := cleanCollectionPath(p); err == nil {
+			ifMatch[cleanPath] = etag
+		}
+	}
+	return ifMatch
+}
+
+// DeleteFiles deletes many files and directories of a collection in one commit. versions holds the ETags the
+// paths were read with, keyed by path.
+func (s *UserGitRepoCollectionService) DeleteFiles(ctx context.Context, repo *models.UserGitRepo, collectionName string, paths []string,
+	versions map[string]string, userID string) ([]string, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := selectedPaths(collection, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	ifMatch := bulkVersions(versions)
+	for _, p := range selected {
+		condition := WriteCondition{IfMatch: ifMatch[p], UserID: userID}
+		if err := s.checkWrite(repo, collectionName, filepath.Join(collection.Path, p), p, condition); err != nil {
+			return nil, err
+		}
+	}
+	for i, p := range selected {
+		fullPath := filepath.Join(collection.Path, p)
+		s.versions.forget(fullPath)
+		if err := os.RemoveAll(fullPath); err != nil {
+			// the next commit must not pick up a partial delete, restore what was removed
+			s.restorePaths(ctx, repo, collection, selected[:i+1])
+			return nil, err
+		}
+	}
+
+	commitMsg := fmt.Sprintf("Delete %d files from collection %s\n\n%s", len(selected), collectionName, strings.Join(selected, "\n"))
+	deletes := make([]PathMove, 0, len(selected))
+	for _, p := range selected {
+		deletes = append(deletes, PathMove{From: p})
+	}
+	if err := s.commitPathChanges(ctx, repo, collection, commitMsg, deletes); err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+// restorePaths checks out the committed version of paths of a collection after a failed bulk change, one by one
+// so that a path git does not know does not keep the others from being restored
+func (s *UserGitRepoCollectionService) restorePaths(ctx context.Context, repo *models.UserGitRepo, collection models.UserGitRepoCollection, paths []string) {
+	for _, p := range paths {
+		output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "checkout", "HEAD", "--", filepath.Join(collection.Path, p))
+		if err != nil {
+			log.Errorf("Failed to restore %s: %s", p, string(output))
+		}
+	}
+}
+
+// undoMoves moves completed moves back, the last one first
+func undoMoves(collection models.UserGitRepoCollection, moves []PathMove) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		if err := os.Rename(filepath.Join(collection.Path, moves[i].To), filepath.Join(collection.Path, moves[i].From)); err != nil {
+			log.Errorf("Failed to move %s back to %s: %v", moves[i].To, moves[i].From, err)
+		}
+	}
+}
+
+// PathMove is a file or directory moved by MoveFiles, for commitPathChanges an empty To means it was deleted
+type PathMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MoveFiles moves many files and directories of a collection into the directory target in one commit. Every
+// move is checked before the first one is made, versions holds the ETags the paths were read with.
+func (s *UserGitRepoCollectionService) MoveFiles(ctx context.Context, repo *models.UserGitRepo, collectionName string, paths []string, target string,
+	versions map[string]string, userID string) ([]PathMove, error) {
+	collection, err := s.GetCollectionByName(repo, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := selectedPaths(collection, paths)
+	if err != nil {
+		return nil, err
+	}
+	cleanTarget := ""
+	if strings.Trim(target, "/") != "" {
+		if cleanTarget, err = cleanCollectionPath(target); err != nil {
+			return nil, err
+		}
+		if fi, err := os.Stat(filepath.Join(collection.Path, cleanTarget)); err == nil && !fi.IsDir() {
+			return nil, core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("target %s is not a directory", cleanTarget))
+		}
+	}
+
+	ifMatch := bulkVersions(versions)
+	moves := make([]PathMove, 0, len(selected))
+	destinations := map[string]string{}
+	for _, p := range selected {
+		to := filepath.Join(cleanTarget, filepath.Base(p))
+		if other, ok := destinations[to]; ok {
+			return nil, core.NewHTTPErrorStr(http.StatusConflict, fmt.Sprintf("%s and %s would both be moved to %s", other, p, to))
+		}
+		if _, err := checkMove(collection, p, to); err != nil {
+			return nil, err
+		}
+		condition := WriteCondition{IfMatch: ifMatch[p], UserID: userID}
+		if err := s.checkWrite(repo, collectionName, filepath.Join(collection.Path, p), p, condition); err != nil {
+			return nil, err
+		}
+		if err := s.checkPublishMove(repo, collection, p, to); err != nil {
+			return nil, err
+		}
+		destinations[to] = p
+		moves = append(moves, PathMove{From: p, To: to})
+	}
+
+	for i, move := range moves {
+		s.versions.forget(filepath.Join(collection.Path, move.From))
+		s.versions.forget(filepath.Join(collection.Path, move.To))
+		if _, err := s.movePath(collection, move.From, move.To); err != nil {
+			// the next commit must not pick up a partial move, move back what was moved
+			undoMoves(collection, moves[:i])
+			return nil, err
+		}
+	}
+
+	commitMsg := fmt.Sprintf("Move %d files to %s in collection %s", len(moves), cleanTarget, collectionName)
+	if cleanTarget == "" {
+		commitMsg = fmt.Sprintf("Move %d files to the root of collection %s", len(moves), collectionName)
+	}
+	if err := s.commitPathChanges(ctx, repo, collection, commitMsg, moves); err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+func (s *UserGitRepoCollectionService) CreateFolder(ctx context.Context, repo *models.UserGitRepo, name string, path string, folder string) error {
 	// Get the collection
 	collection, err := s.GetCollectionByName(repo, name)
 	if err != nil {
@@ -748,9 +2223,9 @@ func (s *UserGitRepoCollectionService) CreateFolder(repo *models.UserGitRepo, na
 
 	// Commit the changes
 	commitMsg := fmt.Sprintf("Create folder %s in collection %s", filepath.Join(cleanPath, cleanFolder), name)
-	if err := s.CommitWithGithubApp(*repo, commitMsg); err != nil {
+	if err := s.CommitWithGithubApp(ctx, *repo, commitMsg); err != nil {
 		log.Errorf("Failed to commit changes: %v", err)
-		return fmt.Errorf("failed to commit changes: %v", err)
+		return fmt.Errorf("failed to commit changes: %w", err)
 	}
 
 	return nil
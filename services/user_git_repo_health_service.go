@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/git"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+)
+
+// RepairAction is a self-repair operation that can be run on a local clone
+type RepairAction string
+
+const (
+	// RepairClearLocks removes lock files left behind by killed git processes
+	RepairClearLocks RepairAction = "clear_locks"
+	// RepairStash stashes uncommitted leftovers so they can be recovered later
+	RepairStash RepairAction = "stash"
+	// RepairDiscard throws away uncommitted leftovers
+	RepairDiscard RepairAction = "discard"
+	// RepairResetRemote hard resets the configured branch to its remote counterpart
+	RepairResetRemote RepairAction = "reset_remote"
+	// RepairReclone deletes the local clone and clones it again
+	RepairReclone RepairAction = "reclone"
+)
+
+// RepoLockFile is a git lock file found in the local clone
+type RepoLockFile struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	AgeSec  int64     `json:"age_sec"`
+}
+
+// RepoDiagnosis describes the health of the local clone of a repository
+type RepoDiagnosis struct {
+	RepoID           uint           `json:"repo_id"`
+	Exists           bool           `json:"exists"`
+	IsGitRepo        bool           `json:"is_git_repo"`
+	ExpectedBranch   string         `json:"expected_branch"`
+	CurrentBranch    string         `json:"current_branch"`
+	DetachedHead     bool           `json:"detached_head"`
+	WrongBranch      bool           `json:"wrong_branch"`
+	LockFiles        []RepoLockFile `json:"lock_files"`
+	UncommittedFiles []string       `json:"uncommitted_files"`
+	FsckOK           bool           `json:"fsck_ok"`
+	FsckOutput       string         `json:"fsck_output,omitempty"`
+	Issues           []string       `json:"issues"`
+	SuggestedRepairs []RepairAction `json:"suggested_repairs"`
+	CheckedAt        time.Time      `json:"checked_at"`
+}
+
+// Healthy returns true when no issue was found
+func (d *RepoDiagnosis) Healthy() bool {
+	return len(d.Issues) == 0
+}
+
+// UserGitRepoHealthService diagnoses and repairs local clones under RepoBasePath
+type UserGitRepoHealthService struct {
+	BaseService
+	userGitRepoService     *UserGitRepoService
+	repoConfigCacheService *RepoConfigCacheService
+}
+
+func (s *UserGitRepoHealthService) Init(ctx *core.APPContext) {
+	s.InitService("userGitRepoHealthService", ctx, s)
+	s.userGitRepoService = ctx.MustGetService("userGitRepoService").(*UserGitRepoService)
+	s.repoConfigCacheService = ctx.MustGetService("repoConfigCacheService").(*RepoConfigCacheService)
+}
+
+// Diagnose inspects the local clone for leftovers of an interrupted sync
+func (s *UserGitRepoHealthService) Diagnose(ctx context.Context, repo *models.UserGitRepo) (*RepoDiagnosis, error) {
+	diagnosis := &RepoDiagnosis{
+		RepoID:           repo.ID,
+		ExpectedBranch:   repo.Branch,
+		LockFiles:        []RepoLockFile{},
+		UncommittedFiles: []string{},
+		Issues:           []string{},
+		SuggestedRepairs: []RepairAction{},
+		CheckedAt:        time.Now(),
+	}
+
+	if _, err := os.Stat(repo.LocalPath); os.IsNotExist(err) {
+		diagnosis.addIssue("local clone does not exist", RepairReclone)
+		return diagnosis, nil
+	}
+	diagnosis.Exists = true
+
+	gitDir := filepath.Join(repo.LocalPath, ".git")
+	if fi, err := os.Stat(gitDir); err != nil || !fi.IsDir() {
+		diagnosis.addIssue("local clone is not a git repository", RepairReclone)
+		return diagnosis, nil
+	}
+	diagnosis.IsGitRepo = true
+
+	// Diagnose runs under the read lock, a lock file may belong to the git command of a concurrent reader
+	// until it is older than the longest deadline of a git command
+	lockFiles, err := s.findLockFiles(gitDir, s.staleLockAge())
+	if err != nil {
+		return nil, err
+	}
+	diagnosis.LockFiles = lockFiles
+	if len(lockFiles) > 0 {
+		diagnosis.addIssue(fmt.Sprintf("found %d stale git lock file(s)", len(lockFiles)), RepairClearLocks)
+	}
+
+	if _, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "symbolic-ref", "-q", "HEAD"); err != nil {
+		diagnosis.DetachedHead = true
+		diagnosis.addIssue("HEAD is detached", RepairResetRemote)
+	} else {
+		output, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "branch", "--show-current")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current branch: %w", err)
+		}
+		diagnosis.CurrentBranch = strings.TrimSpace(string(output))
+		if diagnosis.CurrentBranch != repo.Branch {
+			diagnosis.WrongBranch = true
+			diagnosis.addIssue(fmt.Sprintf("branch %s is checked out instead of %s", diagnosis.CurrentBranch, repo.Branch), RepairResetRemote)
+		}
+	}
+
+	output, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "status", "--porcelain")
+	if err != nil {
+		diagnosis.addIssue(fmt.Sprintf("git status failed: %s", git.Hint(err)), RepairReclone)
+	} else {
+		for _, line := range strings.Split(string(output), "\n") {
+			if len(line) > 3 {
+				diagnosis.UncommittedFiles = append(diagnosis.UncommittedFiles, strings.TrimSpace(line[3:]))
+			}
+		}
+		if len(diagnosis.UncommittedFiles) > 0 {
+			diagnosis.addIssue(fmt.Sprintf("found %d uncommitted file(s)", len(diagnosis.UncommittedFiles)), RepairStash, RepairDiscard)
+		}
+	}
+
+	fsckOutput, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "fsck", "--no-progress", "--no-dangling")
+	diagnosis.FsckOutput = strings.TrimSpace(string(fsckOutput))
+	diagnosis.FsckOK = err == nil
+	if err != nil {
+		diagnosis.addIssue("git fsck reported a corrupted object store", RepairReclone)
+	}
+
+	return diagnosis, nil
+}
+
+func (d *RepoDiagnosis) addIssue(issue string, repairs ...RepairAction) {
+	d.Issues = append(d.Issues, issue)
+	for _, repair := range repairs {
+		found := false
+		for _, existing := range d.SuggestedRepairs {
+			if existing == repair {
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.SuggestedRepairs = append(d.SuggestedRepairs, repair)
+		}
+	}
+}
+
+// staleLockAge returns the age after which a lock file cannot belong to a running git command, which is killed
+// at its deadline
+func (s *UserGitRepoHealthService) staleLockAge() time.Duration {
+	age := time.Duration(0)
+	for _, op := range []git.Op{git.OpClone, git.OpFetch, git.OpPush, git.OpLocal} {
+		age = max(age, s.ctx.Git.Timeout(op))
+	}
+	return age
+}
+
+// findLockFiles lists the *.lock files in the git directory that are at least minAge old. Under the write
+// lock of the repository no git process of ours can be running, so every lock file is stale and minAge is 0.
+func (s *UserGitRepoHealthService) findLockFiles(gitDir string, minAge time.Duration) ([]RepoLockFile, error) {
+	lockFiles := []RepoLockFile{}
+	now := time.Now()
+	err := filepath.WalkDir(gitDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "objects" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".lock") {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			// released while scanning
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if now.Sub(info.ModTime()) < minAge {
+			return nil
+		}
+		rel, _ := filepath.Rel(gitDir, path)
+		lockFiles = append(lockFiles, RepoLockFile{
+			Path:    filepath.Join(".git", rel),
+			ModTime: info.ModTime(),
+			AgeSec:  int64(now.Sub(info.ModTime()).Seconds()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan git directory: %v", err)
+	}
+	return lockFiles, nil
+}
+
+// ParseRepairAction validates a repair action received from a client
+func ParseRepairAction(action string) (RepairAction, error) {
+	switch RepairAction(action) {
+	case RepairClearLocks, RepairStash, RepairDiscard, RepairResetRemote, RepairReclone:
+		return RepairAction(action), nil
+	default:
+		return "", core.NewHTTPErrorStr(http.StatusBadRequest, fmt.Sprintf("unknown repair action %s", action))
+	}
+}
+
+// Repair runs a repair action on the local clone, the caller must hold the write lock of the repository
+func (s *UserGitRepoHealthService) Repair(ctx context.Context, repo *models.UserGitRepo, action RepairAction) (string, error) {
+	log.Infof("Running repair %s on repository %d", action, repo.ID)
+	defer s.repoConfigCacheService.Invalidate(repo.ID)
+	switch action {
+	case RepairClearLocks:
+		return s.clearLocks(repo)
+	case RepairStash:
+		return s.stash(ctx, repo)
+	case RepairDiscard:
+		return s.discard(ctx, repo)
+	case RepairResetRemote:
+		return s.resetRemote(ctx, repo)
+	case RepairReclone:
+		return s.reclone(ctx, repo)
+	default:
+		return "", fmt.Errorf("unknown repair action %s", action)
+	}
+}
+
+func (s *UserGitRepoHealthService) clearLocks(repo *models.UserGitRepo) (string, error) {
+	gitDir := filepath.Join(repo.LocalPath, ".git")
+	lockFiles, err := s.findLockFiles(gitDir, 0)
+	if err != nil {
+		return "", err
+	}
+	for _, lockFile := range lockFiles {
+		if err := os.Remove(filepath.Join(repo.LocalPath, lockFile.Path)); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to remove %s: %v", lockFile.Path, err)
+		}
+		log.Infof("Removed stale lock file %s from repository %d", lockFile.Path, repo.ID)
+	}
+	return fmt.Sprintf("removed %d lock file(s)", len(lockFiles)), nil
+}
+
+func (s *UserGitRepoHealthService) stash(ctx context.Context, repo *models.UserGitRepo) (string, error) {
+	message := fmt.Sprintf("mkdocs-cms repair %s", time.Now().Format(time.RFC3339))
+	output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "stash", "push", "--include-untracked", "-m", message)
+	if err != nil {
+		return "", fmt.Errorf("failed to stash changes: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (s *UserGitRepoHealthService) discard(ctx context.Context, repo *models.UserGitRepo) (string, error) {
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "reset", "--hard", "HEAD"); err != nil {
+		log.Errorf("Failed to reset changes: %s", string(output))
+		return "", fmt.Errorf("failed to reset changes: %w", err)
+	}
+	output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "clean", "-fd")
+	if err != nil {
+		return "", fmt.Errorf("failed to remove untracked files: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (s *UserGitRepoHealthService) resetRemote(ctx context.Context, repo *models.UserGitRepo) (string, error) {
+	if err := s.userGitRepoService.FetchRemote(ctx, repo); err != nil {
+		return "", err
+	}
+	remoteBranch := "origin/" + repo.Branch
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "checkout", "-f", "-B", repo.Branch, remoteBranch); err != nil {
+		log.Errorf("Failed to checkout branch: %s", string(output))
+		return "", fmt.Errorf("failed to checkout branch %s: %w", repo.Branch, err)
+	}
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "reset", "--hard", remoteBranch); err != nil {
+		log.Errorf("Failed to reset branch: %s", string(output))
+		return "", fmt.Errorf("failed to reset branch %s: %w", repo.Branch, err)
+	}
+	if output, err := s.ctx.Git.CombinedOutput(ctx, git.OpLocal, repo.LocalPath, "clean", "-fd"); err != nil {
+		log.Errorf("Failed to clean working tree: %s", string(output))
+		return "", fmt.Errorf("failed to clean working tree: %w", err)
+	}
+	output, err := s.ctx.Git.Output(ctx, git.OpLocal, repo.LocalPath, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to get current commit: %w", err)
+	}
+	return fmt.Sprintf("reset %s to %s", repo.Branch, strings.TrimSpace(string(output))), nil
+}
+
+func (s *UserGitRepoHealthService) reclone(ctx context.Context, repo *models.UserGitRepo) (string, error) {
+	if err := s.userGitRepoService.checkLocalPath(repo); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(repo.LocalPath); err != nil {
+		return "", fmt.Errorf("failed to remove local clone: %v", err)
+	}
+	if err := s.userGitRepoService.SyncRepo(ctx, repo, ""); err != nil {
+		return "", err
+	}
+	return "repository cloned again", nil
+}
@@ -1,30 +1,274 @@
 package services
 
 import (
-	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
 	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+)
+
+// LockMode represents how a repository lock is held
+type LockMode string
+
+const (
+	// LockModeRead allows concurrent readers of the working tree
+	LockModeRead LockMode = "read"
+	// LockModeWrite gives exclusive access to the working tree
+	LockModeWrite LockMode = "write"
+)
+
+const (
+	defaultReadLockTimeout  = 10 * time.Second
+	defaultWriteLockTimeout = 30 * time.Second
 )
 
+// LockOwner describes who holds or waits for a repository lock
+type LockOwner struct {
+	ID        uint64    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	Mode      LockMode  `json:"mode"`
+	Since     time.Time `json:"since"`
+	ElapsedMS int64     `json:"elapsed_ms"`
+}
+
+// RepoLockState is a snapshot of a single repository lock
+type RepoLockState struct {
+	RepoID  string      `json:"repo_id"`
+	Holders []LockOwner `json:"holders"`
+	Waiters []LockOwner `json:"waiters"`
+}
+
+// repoLock is a reader/writer lock whose acquisition can be abandoned through a context.
+// Waiting writers block new readers so that a steady stream of reads cannot starve a save.
+type repoLock struct {
+	mu             sync.Mutex
+	changed        chan struct{}
+	readers        int
+	writer         bool
+	writersWaiting int
+	holders        map[uint64]*LockOwner
+	waiters        map[uint64]*LockOwner
+}
+
+func newRepoLock() *repoLock {
+	return &repoLock{
+		changed: make(chan struct{}),
+		holders: make(map[uint64]*LockOwner),
+		waiters: make(map[uint64]*LockOwner),
+	}
+}
+
+// notify wakes every waiter so it can re-check the lock state, must be called with mu held
+func (l *repoLock) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *repoLock) canAcquire(mode LockMode) bool {
+	if mode == LockModeWrite {
+		return !l.writer && l.readers == 0
+	}
+	return !l.writer && l.writersWaiting == 0
+}
+
+// RepoLockHandle is returned by a successful acquisition and must be released with Unlock
+type RepoLockHandle struct {
+	repoID string
+	lock   *repoLock
+	owner  *LockOwner
+	once   sync.Once
+}
+
+// Unlock releases the lock, calling it more than once is a no-op
+func (h *RepoLockHandle) Unlock() {
+	h.once.Do(func() {
+		h.lock.mu.Lock()
+		if h.owner.Mode == LockModeWrite {
+			h.lock.writer = false
+		} else {
+			h.lock.readers--
+		}
+		delete(h.lock.holders, h.owner.ID)
+		h.lock.notify()
+		h.lock.mu.Unlock()
+		log.Debugf("released %s lock on repo %s held by %s for %s", h.owner.Mode, h.repoID, h.owner.UserID, time.Since(h.owner.Since))
+	})
+}
+
+// UserGitRepoLockService serializes access to the local clone of each repository
 type UserGitRepoLockService struct {
 	BaseService
-	repoLocks map[string]*sync.Mutex
-	mutex     sync.Mutex
+	repoLocks    map[string]*repoLock
+	mutex        sync.Mutex
+	nextID       uint64
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 func (s *UserGitRepoLockService) Init(ctx *core.APPContext) {
 	s.InitService("userGitRepoLockService", ctx, s)
+	s.repoLocks = make(map[string]*repoLock)
+	s.readTimeout = defaultReadLockTimeout
+	s.writeTimeout = defaultWriteLockTimeout
+	if ctx.Config.Lock.ReadTimeoutSeconds > 0 {
+		s.readTimeout = time.Duration(ctx.Config.Lock.ReadTimeoutSeconds) * time.Second
+	}
+	if ctx.Config.Lock.WriteTimeoutSeconds > 0 {
+		s.writeTimeout = time.Duration(ctx.Config.Lock.WriteTimeoutSeconds) * time.Second
+	}
 }
 
-func (s *UserGitRepoLockService) Acquire(repoID string) *sync.Mutex {
+func (s *UserGitRepoLockService) getLock(repoID string) (*repoLock, uint64) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if s.repoLocks == nil {
-		s.repoLocks = make(map[string]*sync.Mutex)
+	if s.repoLocks[repoID] == nil {
+		s.repoLocks[repoID] = newRepoLock()
 	}
+	s.nextID++
+	return s.repoLocks[repoID], s.nextID
+}
 
-	if s.repoLocks[repoID] == nil {
-		s.repoLocks[repoID] = &sync.Mutex{}
+// RLock acquires a shared lock on the repository working tree
+func (s *UserGitRepoLockService) RLock(ctx context.Context, repoID string, userID string, purpose string) (*RepoLockHandle, error) {
+	return s.acquire(ctx, repoID, userID, purpose, LockModeRead, s.readTimeout)
+}
+
+// Lock acquires an exclusive lock on the repository working tree
+func (s *UserGitRepoLockService) Lock(ctx context.Context, repoID string, userID string, purpose string) (*RepoLockHandle, error) {
+	return s.acquire(ctx, repoID, userID, purpose, LockModeWrite, s.writeTimeout)
+}
+
+// LockWait acquires an exclusive lock on the repository working tree for background work that must not be
+// dropped, it waits until ctx is done instead of giving up after the write timeout
+func (s *UserGitRepoLockService) LockWait(ctx context.Context, repoID string, userID string, purpose string) (*RepoLockHandle, error) {
+	return s.acquire(ctx, repoID, userID, purpose, LockModeWrite, 0)
+}
+
+// acquire waits for a lock until ctx is done or, when timeout is positive, the timeout has passed
+func (s *UserGitRepoLockService) acquire(ctx context.Context, repoID string, userID string, purpose string, mode LockMode, timeout time.Duration) (*RepoLockHandle, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	lock, id := s.getLock(repoID)
+	owner := &LockOwner{
+		ID:      id,
+		UserID:  userID,
+		Purpose: purpose,
+		Mode:    mode,
+		Since:   time.Now(),
+	}
+
+	lock.mu.Lock()
+	lock.waiters[id] = owner
+	if mode == LockModeWrite {
+		lock.writersWaiting++
+	}
+	for !lock.canAcquire(mode) {
+		changed := lock.changed
+		lock.mu.Unlock()
+
+		select {
+		case <-changed:
+			lock.mu.Lock()
+		case <-ctx.Done():
+			lock.mu.Lock()
+			delete(lock.waiters, id)
+			if mode == LockModeWrite {
+				lock.writersWaiting--
+				// readers may have been held back only by this writer
+				lock.notify()
+			}
+			holders := lock.snapshotHolders()
+			lock.mu.Unlock()
+			return nil, s.acquireError(ctx.Err(), repoID, mode, holders)
+		}
+	}
+
+	delete(lock.waiters, id)
+	if mode == LockModeWrite {
+		lock.writersWaiting--
+		lock.writer = true
+	} else {
+		lock.readers++
+	}
+	owner.Since = time.Now()
+	lock.holders[id] = owner
+	lock.mu.Unlock()
+
+	return &RepoLockHandle{repoID: repoID, lock: lock, owner: owner}, nil
+}
+
+// snapshotHolders returns the holders of the lock, the longest-held first
+func (l *repoLock) snapshotHolders() []LockOwner {
+	holders := make([]LockOwner, 0, len(l.holders))
+	for _, h := range l.holders {
+		holders = append(holders, *h)
+	}
+	sort.Slice(holders, func(i, j int) bool { return holders[i].Since.Before(holders[j].Since) })
+	return holders
+}
+
+// acquireError maps a failed wait to 423 when someone is holding the lock and 503 otherwise, the 423 names the
+// longest-held owner
+func (s *UserGitRepoLockService) acquireError(err error, repoID string, mode LockMode, holders []LockOwner) error {
+	if errors.Is(err, context.DeadlineExceeded) && len(holders) > 0 {
+		holder := holders[0]
+		log.Warnf("timed out waiting for %s lock on repo %s, held by %s (%s) since %s",
+			mode, repoID, holder.UserID, holder.Purpose, holder.Since.Format(time.RFC3339))
+		return core.NewHTTPErrorStr(http.StatusLocked,
+			fmt.Sprintf("repository is locked by %s (%s) since %s, please try again later",
+				holder.UserID, holder.Purpose, holder.Since.Format(time.RFC3339)))
+	}
+	log.Warnf("failed to acquire %s lock on repo %s: %v", mode, repoID, err)
+	return core.NewHTTPErrorStr(http.StatusServiceUnavailable,
+		fmt.Sprintf("repository is busy, please try again later: %v", err))
+}
+
+// GetLockState returns the holders and waiters of a repository lock
+func (s *UserGitRepoLockService) GetLockState(repoID string) RepoLockState {
+	s.mutex.Lock()
+	lock := s.repoLocks[repoID]
+	s.mutex.Unlock()
+
+	state := RepoLockState{RepoID: repoID, Holders: []LockOwner{}, Waiters: []LockOwner{}}
+	if lock == nil {
+		return state
+	}
+
+	now := time.Now()
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	for _, h := range lock.holders {
+		owner := *h
+		owner.ElapsedMS = now.Sub(owner.Since).Milliseconds()
+		state.Holders = append(state.Holders, owner)
+	}
+	for _, w := range lock.waiters {
+		owner := *w
+		owner.ElapsedMS = now.Sub(owner.Since).Milliseconds()
+		state.Waiters = append(state.Waiters, owner)
+	}
+	sort.Slice(state.Holders, func(i, j int) bool { return state.Holders[i].Since.Before(state.Holders[j].Since) })
+	sort.Slice(state.Waiters, func(i, j int) bool { return state.Waiters[i].Since.Before(state.Waiters[j].Since) })
+	return state
+}
+
+// GetLockStates returns the lock state of the given repositories
+func (s *UserGitRepoLockService) GetLockStates(repoIDs []string) []RepoLockState {
+	states := make([]RepoLockState, 0, len(repoIDs))
+	for _, repoID := range repoIDs {
+		states = append(states, s.GetLockState(repoID))
 	}
-	return s.repoLocks[repoID]
+	return states
 }
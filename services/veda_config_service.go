@@ -0,0 +1,514 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhaojunlucky/mkdocs-cms/core"
+	"github.com/zhaojunlucky/mkdocs-cms/core/md"
+	"github.com/zhaojunlucky/mkdocs-cms/core/schema"
+	"github.com/zhaojunlucky/mkdocs-cms/models"
+	"gopkg.in/yaml.v3"
+)
+
+// maxAnalyzedFiles bounds how many markdown files are parsed per proposed collection
+const maxAnalyzedFiles = 500
+
+var (
+	dateFileNameRegex     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+	sequenceFileNameRegex = regexp.MustCompile(`^\d+[-_]`)
+	collectionNameRegex   = regexp.MustCompile(`[^a-z0-9_]+`)
+)
+
+// VedaConfigProposal is a veda/config.yml inferred from mkdocs.yml and the docs tree
+type VedaConfigProposal struct {
+	Config  VedaConfig `json:"config"`
+	Content string     `json:"content"`
+	Exists  bool       `json:"exists"`
+	Notes   []string   `json:"notes"`
+}
+
+// mkdocsConfig holds the parts of mkdocs.yml the analyzer needs
+type mkdocsConfig struct {
+	SiteName string        `yaml:"site_name"`
+	DocsDir  string        `yaml:"docs_dir"`
+	Nav      []interface{} `yaml:"nav"`
+	Plugins  interface{}   `yaml:"plugins"`
+}
+
+// fieldStats collects the values seen for one front matter key
+type fieldStats struct {
+	name      string
+	order     int
+	count     int
+	types     map[string]bool
+	listOfStr bool
+}
+
+// VedaConfigService reads, infers and writes veda/config.yml
+type VedaConfigService struct {
+	BaseService
+	userGitRepoService           *UserGitRepoService
+	userGitRepoCollectionService *UserGitRepoCollectionService
+}
+
+func (s *VedaConfigService) Init(ctx *core.APPContext) {
+	s.InitService("vedaConfigService", ctx, s)
+	s.userGitRepoService = ctx.MustGetService("userGitRepoService").(*UserGitRepoService)
+	s.userGitRepoCollectionService = ctx.MustGetService("userGitRepoCollectionService").(*UserGitRepoCollectionService)
+}
+
+// ProposeConfig analyzes mkdocs.yml and the docs tree and proposes collections for veda/config.yml
+func (s *VedaConfigService) ProposeConfig(repo *models.UserGitRepo) (*VedaConfigProposal, error) {
+	mkdocs, err := s.readMkdocsConfig(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	docsDir := filepath.ToSlash(filepath.Clean(mkdocs.DocsDir))
+	if docsDir == "" || docsDir == "." {
+		docsDir = "docs"
+	}
+	if filepath.IsAbs(docsDir) || docsDir == ".." || strings.HasPrefix(docsDir, "../") {
+		return nil, core.NewHTTPErrorStr(http.StatusUnprocessableEntity, fmt.Sprintf("docs_dir %s is outside of the repository", mkdocs.DocsDir))
+	}
+	docsPath := filepath.Join(repo.LocalPath, docsDir)
+	if fi, err := os.Stat(docsPath); err != nil || !fi.IsDir() {
+		return nil, core.NewHTTPErrorStr(http.StatusUnprocessableEntity, fmt.Sprintf("docs_dir %s not found", docsDir))
+	}
+
+	proposal := &VedaConfigProposal{Notes: []string{}}
+	if _, err := os.Stat(filepath.Join(repo.LocalPath, "veda", "config.yml")); err == nil {
+		proposal.Exists = true
+		proposal.Notes = append(proposal.Notes, "veda/config.yml already exists, committing the proposal replaces it")
+	}
+
+	labels := navLabels(mkdocs.Nav)
+	usedNames := map[string]bool{}
+
+	blogDir, postDir, hasBlog := blogPluginDirs(mkdocs.Plugins)
+	if hasBlog {
+		postsPath := filepath.Join(docsPath, filepath.FromSlash(postDir))
+		collection := Collection{
+			Name:              uniqueCollectionName("posts", usedNames),
+			Label:             "Posts",
+			Path:              docsDir + "/" + postDir,
+			Format:            "md",
+			FileNameGenerator: &FileNameGenerator{Type: "date"},
+		}
+		fields, files := s.inferFields(postsPath)
+		collection.Fields = fields
+		proposal.Config.Collections = append(proposal.Config.Collections, collection)
+		proposal.Notes = append(proposal.Notes, fmt.Sprintf("blog plugin found, posts collection inferred from %d post(s)", files))
+	}
+
+	entries, err := os.ReadDir(docsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docs_dir: %v", err)
+	}
+	rootHasMarkdown := false
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !entry.IsDir() {
+			if strings.HasSuffix(name, ".md") {
+				rootHasMarkdown = true
+			}
+			continue
+		}
+		if hasBlog && name == strings.Split(blogDir, "/")[0] {
+			continue
+		}
+
+		dirPath := filepath.Join(docsPath, name)
+		fields, files := s.inferFields(dirPath)
+		if files == 0 {
+			continue
+		}
+		label := labels[name]
+		if label == "" {
+			label = titleCase(name)
+		}
+		collection := Collection{
+			Name:              uniqueCollectionName(name, usedNames),
+			Label:             label,
+			Path:              docsDir + "/" + name,
+			Format:            "md",
+			FileNameGenerator: inferFileNameGenerator(dirPath),
+			Fields:            fields,
+		}
+		proposal.Config.Collections = append(proposal.Config.Collections, collection)
+		proposal.Notes = append(proposal.Notes, fmt.Sprintf("collection %s inferred from %d file(s) in %s", collection.Name, files, collection.Path))
+	}
+
+	if rootHasMarkdown || len(proposal.Config.Collections) == 0 {
+		fields, files := s.inferFields(docsPath)
+		collection := Collection{
+			Name:   uniqueCollectionName("pages", usedNames),
+			Label:  "Pages",
+			Path:   docsDir,
+			Format: "md",
+			Fields: fields,
+		}
+		proposal.Config.Collections = append(proposal.Config.Collections, collection)
+		proposal.Notes = append(proposal.Notes, fmt.Sprintf("collection %s covers the whole docs_dir (%d file(s)) and overlaps the other collections", collection.Name, files))
+	}
+
+	content, err := marshalVedaConfig(&proposal.Config)
+	if err != nil {
+		return nil, err
+	}
+	proposal.Content = string(content)
+	return proposal, nil
+}
+
+func (s *VedaConfigService) readMkdocsConfig(repo *models.UserGitRepo) (*mkdocsConfig, error) {
+	var data []byte
+	var err error
+	for _, name := range []string{"mkdocs.yml", "mkdocs.yaml"} {
+		data, err = os.ReadFile(filepath.Join(repo.LocalPath, name))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, core.NewHTTPErrorStr(http.StatusUnprocessableEntity, "mkdocs.yml not found in repository")
+		}
+		return nil, fmt.Errorf("failed to read mkdocs.yml: %v", err)
+	}
+
+	// unknown tags such as !!python/name are decoded as empty values
+	config := &mkdocsConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, core.NewHTTPErrorStr(http.StatusUnprocessableEntity, fmt.Sprintf("invalid YAML format in mkdocs.yml: %v", err))
+	}
+	return config, nil
+}
+
+// inferFields derives field definitions from the front matter of the markdown files under dir
+func (s *VedaConfigService) inferFields(dir string) ([]Field, int) {
+	stats := map[string]*fieldStats{}
+	files := 0
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".md") {
+			return nil
+		}
+		if files >= maxAnalyzedFiles {
+			return filepath.SkipAll
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		files++
+		values, _, err := md.ParseFrontMatter(content)
+		if err != nil {
+			log.Debugf("Skipping front matter of %s: %v", path, err)
+			return nil
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := values[key]
+			stat := stats[key]
+			if stat == nil {
+				stat = &fieldStats{name: key, order: len(stats), types: map[string]bool{}, listOfStr: true}
+				stats[key] = stat
+			}
+			stat.count++
+			stat.types[inferValueType(value)] = true
+			if list, ok := value.([]interface{}); ok {
+				for _, item := range list {
+					if _, ok := item.(string); !ok {
+						stat.listOfStr = false
+					}
+				}
+			}
+		}
+		return nil
+	})
+
+	ordered := make([]*fieldStats, 0, len(stats))
+	for _, stat := range stats {
+		ordered = append(ordered, stat)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].count != ordered[j].count {
+			return ordered[i].count > ordered[j].count
+		}
+		return ordered[i].order < ordered[j].order
+	})
+
+	fields := []Field{}
+	for _, stat := range ordered {
+		field := Field{
+			Type:     "string",
+			Name:     stat.name,
+			Label:    titleCase(stat.name),
+			Required: stat.count == files,
+		}
+		if len(stat.types) == 1 {
+			switch {
+			case stat.types["boolean"]:
+				field.Type = "boolean"
+			case stat.types["date"]:
+				field.Type = "date"
+			case stat.types["list"]:
+				field.List = stat.listOfStr
+			}
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) > 0 || files > 0 {
+		fields = append(fields, Field{Type: "markdown", Name: "body", Label: "Body"})
+	}
+	return fields, files
+}
+
+func inferValueType(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "date"
+	case []interface{}:
+		return "list"
+	case string:
+		if _, err := time.Parse("2006-01-02", v); err == nil {
+			return "date"
+		}
+		return "string"
+	default:
+		return "string"
+	}
+}
+
+// inferFileNameGenerator proposes a generator when most file names follow a date or sequence prefix
+func inferFileNameGenerator(dir string) *FileNameGenerator {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	total, dated, sequenced := 0, 0, 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".md") || entry.Name() == "index.md" {
+			continue
+		}
+		total++
+		if dateFileNameRegex.MatchString(entry.Name()) {
+			dated++
+		} else if sequenceFileNameRegex.MatchString(entry.Name()) {
+			sequenced++
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	if dated*2 > total {
+		return &FileNameGenerator{Type: "date"}
+	}
+	if sequenced*2 > total {
+		return &FileNameGenerator{Type: "sequence"}
+	}
+	return nil
+}
+
+// blogPluginDirs returns the blog and post directories relative to docs_dir when the blog plugin is enabled
+func blogPluginDirs(plugins interface{}) (string, string, bool) {
+	var options map[string]interface{}
+	found := false
+
+	check := func(name string, value interface{}) {
+		if name != "blog" && name != "material/blog" {
+			return
+		}
+		found = true
+		if m, ok := value.(map[string]interface{}); ok {
+			options = m
+		}
+	}
+	switch p := plugins.(type) {
+	case []interface{}:
+		for _, plugin := range p {
+			switch v := plugin.(type) {
+			case string:
+				check(v, nil)
+			case map[string]interface{}:
+				for name, value := range v {
+					check(name, value)
+				}
+			}
+		}
+	case map[string]interface{}:
+		for name, value := range p {
+			check(name, value)
+		}
+	}
+	if !found {
+		return "", "", false
+	}
+
+	blogDir := "blog"
+	if v, ok := options["blog_dir"].(string); ok && v != "" {
+		blogDir = strings.Trim(v, "/")
+	}
+	postDir := "{blog}/posts"
+	if v, ok := options["post_dir"].(string); ok && v != "" {
+		postDir = v
+	}
+	postDir = strings.Trim(strings.ReplaceAll(postDir, "{blog}", blogDir), "/")
+	if blogDir == "." {
+		blogDir = ""
+	}
+	return blogDir, postDir, true
+}
+
+// navLabels maps a top level docs directory to the title of the nav section that lists only its pages
+func navLabels(nav []interface{}) map[string]string {
+	labels := map[string]string{}
+	for _, item := range nav {
+		section, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for title, value := range section {
+			var paths []string
+			collectNavPaths(value, &paths)
+			dir := ""
+			for _, p := range paths {
+				parts := strings.SplitN(p, "/", 2)
+				if len(parts) < 2 || (dir != "" && parts[0] != dir) {
+					dir = ""
+					break
+				}
+				dir = parts[0]
+			}
+			if dir != "" {
+				labels[dir] = title
+			}
+		}
+	}
+	return labels
+}
+
+func collectNavPaths(value interface{}, paths *[]string) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "://") {
+			*paths = append(*paths, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectNavPaths(item, paths)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			collectNavPaths(item, paths)
+		}
+	}
+}
+
+func uniqueCollectionName(name string, used map[string]bool) string {
+	base := strings.Trim(collectionNameRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if base == "" {
+		base = "collection"
+	}
+	candidate := base
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s_%d", base, i)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+func titleCase(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == ' ' })
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+func marshalVedaConfig(config *VedaConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); err != nil {
+		return nil, fmt.Errorf("failed to encode veda/config.yml: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode veda/config.yml: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ValidateConfig validates the content of veda/config.yml against the schema and warns about
+// collection paths missing from the repository
+func (s *VedaConfigService) ValidateConfig(repo *models.UserGitRepo, content []byte) schema.ValidationErrors {
+	findings := schema.ValidateVedaConfigWith(content, func(p string) bool {
+		_, err := os.Stat(filepath.Join(repo.LocalPath, filepath.FromSlash(p)))
+		return err == nil
+	})
+	if findings == nil {
+		findings = schema.ValidationErrors{}
+	}
+	return findings
+}
+
+// CommitConfig validates the content of veda/config.yml, writes it and pushes the change.
+// The caller must hold the write lock of the repository.
+func (s *VedaConfigService) CommitConfig(ctx context.Context, repo *models.UserGitRepo, content []byte) error {
+	if err := validateVedaConfig(content); err != nil {
+		return core.NewHTTPErrorStr(http.StatusBadRequest, err.Error())
+	}
+
+	configPath := filepath.Join(repo.LocalPath, "veda", "config.yml")
+	_, statErr := os.Stat(configPath)
+	commitMsg := "Update veda/config.yml"
+	if os.IsNotExist(statErr) {
+		commitMsg = "Add veda/config.yml"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(configPath, content, 0644); err != nil {
+		return err
+	}
+	if err := s.userGitRepoCollectionService.CommitWithGithubApp(ctx, *repo, commitMsg); err != nil {
+		return fmt.Errorf("failed to commit changes: %w", err)
+	}
+
+	// the missing or invalid config was the reason for the warning
+	if repo.Status == models.StatusWarning {
+		if err := s.userGitRepoService.UpdateRepoStatus(repo, models.StatusSynced, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -373,11 +373,7 @@ export class CreateFileComponent implements OnInit, CanComponentDeactivate {
       : finalFileName;
 
     const fileContent = this.generateFileContent()
-    let headers = new HttpHeaders();
-    if (this.frontMatter) {
-      headers = headers.set('X-File-Front-Matter', 'true');
-      headers = headers.set('X-File-Front-Matter-Draft', this.frontMatter['draft'] ? 'true' : 'false');
-    }
+    const headers = new HttpHeaders();
 
     this.collectionService.uploadFile(
       this.repositoryId.toString(),
@@ -395,11 +395,7 @@ export class EditFileComponent implements OnInit, CanComponentDeactivate {
 
     // Combine front matter and markdown content
     const content = this.generateFileContent();
-    let headers = new HttpHeaders();
-    if (this.frontMatter) {
-      headers = headers.set('X-File-Front-Matter', 'true');
-      headers = headers.set('X-File-Front-Matter-Draft', this.frontMatter['draft'] ? 'true' : 'false');
-    }
+    const headers = new HttpHeaders();
 
     this.collectionService.updateFileContent(
       this.repositoryId,